@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"vixel/config"
+	"vixel/domains/image"
+)
+
+func main() {
+	batchSize := flag.Int("batch", 100, "number of images to load per query")
+	flag.Parse()
+
+	if err := config.LoadEnvConfig(); err != nil {
+		log.Fatalf("failed to load env config: %v", err)
+	}
+
+	db, err := config.NewPostgres()
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+	if err := db.Migrator().AutoMigrate(&image.Image{}); err != nil {
+		log.Fatalf("failed to migrate images: %v", err)
+	}
+
+	storage, err := image.NewStorage()
+	if err != nil {
+		log.Fatalf("failed to initialize storage: %v", err)
+	}
+
+	updated, skipped, err := image.BackfillImageSizes(context.Background(), db, storage, *batchSize)
+	if err != nil {
+		log.Fatalf("size backfill stopped after %d images: %v", updated, err)
+	}
+	log.Printf("sizes recorded for %d images, %d skipped", updated, skipped)
+
+	updated, skipped, err = image.BackfillImageMetadata(context.Background(), db, storage, *batchSize)
+	if err != nil {
+		log.Fatalf("backfill stopped after %d images: %v", updated, err)
+	}
+	log.Printf("metadata extracted for %d images, %d skipped", updated, skipped)
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"vixel/config"
 	"vixel/domains/image"
 	"vixel/domains/processing"
 	"vixel/domains/user"
+	"vixel/shared/mailer"
+	"vixel/shared/middlewares"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/cors"
@@ -17,6 +20,9 @@ func main() {
 		log.Fatalf("failed to load env config: %v", err)
 	}
 	app := gin.Default()
+	if err := app.SetTrustedProxies(config.Config.TrustedProxies); err != nil {
+		log.Fatalf("invalid trusted proxies: %v", err)
+	}
 	c := cors.AllowAll()
 	app.Use(func(gc *gin.Context) {
 		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -24,27 +30,53 @@ func main() {
 		})
 		c.Handler(next).ServeHTTP(gc.Writer, gc.Request)
 	})
-	api := app.Group("/api/v1")
+	api := app.Group("/api/v1", middlewares.APIRateLimit())
 
 	db, err := config.NewPostgres()
 	if err != nil {
 		log.Fatalf("failed to connect to database: %v", err)
 	}
-	db.Migrator().AutoMigrate(&user.User{}, &image.Image{})
-
-	userService := user.NewUserService(db)
-	userHandler := user.NewUserHandler(userService)
-	userHandler.SetupUserRoutes(api)
+	db.Migrator().AutoMigrate(&user.User{}, &user.RefreshToken{}, &user.RevokedToken{}, &user.UserToken{}, &user.APIKey{}, &user.UserIdentity{}, &user.OIDCState{}, &user.RecoveryCode{}, &image.Image{}, &image.ImageVersion{}, &image.StoredObject{}, &image.RenderedVariant{}, &processing.Job{})
+	if err := image.MigrateLegacyURLs(db); err != nil {
+		log.Fatalf("failed to migrate legacy image urls: %v", err)
+	}
 
+	mail, err := mailer.New()
+	if err != nil {
+		log.Fatalf("failed to initialize mailer: %v", err)
+	}
 	imageService := image.NewImageService(db)
-	uploadService := image.NewUploadService()
-	imageHandler := image.NewImageHandler(imageService, uploadService)
-	imageHandler.SetupImageRoutes(api)
-
+	storage, err := image.NewStorage()
+	if err != nil {
+		log.Fatalf("failed to initialize storage: %v", err)
+	}
+	if config.Config.StorageDriver != "minio" {
+		app.GET("/storage/*key", image.ServeStorage(storage))
+	}
+	uploadService := image.NewUploadService(storage)
+	urlResolver := image.NewURLResolver(storage)
 	processingService := processing.NewProcessingService(db, uploadService)
-	processingHandler := processing.NewProcessingHandler(processingService)
+	jobService := processing.NewJobService(db, processingService)
+	processing.NewJobWorkerPool(jobService, config.Config.JobWorkers, config.Config.JobPollInterval).Start(context.Background())
+	processingHandler := processing.NewProcessingHandler(processingService, jobService, urlResolver)
 	processingHandler.SetupProcessingRoutes(api)
 
+	contentCleaner := image.NewContentCleaner(db, uploadService, jobService)
+	imageHandler := image.NewImageHandler(imageService, uploadService, contentCleaner, urlResolver)
+	imageHandler.SetupImageRoutes(api)
+	image.NewAdminHandler(imageService, contentCleaner, urlResolver).SetupAdminRoutes(api)
+
+	userService := user.NewUserService(db, mail, contentCleaner)
+	if err := userService.PromoteAdmins(config.Config.AdminEmails); err != nil {
+		log.Fatalf("failed to promote admins: %v", err)
+	}
+	middlewares.SetTokenDenylist(userService)
+	middlewares.SetAPIKeyAuthenticator(userService)
+	userHandler := user.NewUserHandler(userService)
+	userHandler.SetupUserRoutes(api)
+	user.NewAdminHandler(userService).SetupAdminRoutes(api)
+	user.NewOIDCHandler(user.NewOIDCService(userService, config.Config.OIDCProviders, nil)).SetupOIDCRoutes(api)
+
 	if err := app.Run(config.Config.Port); err != nil {
 		log.Fatalf("failed to start server: %v", err)
 	}
@@ -1,22 +1,77 @@
 package config
 
 import (
+	"errors"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
 type EnvConfig struct {
-	DbUrl           string `env:"DB_URL"`
-	Port            string `env:"PORT" envDefault:"8080"`
-	JWTSecret       string `env:"JWT_SECRET"`
-	MINIOEndpoint   string `env:"MINIO_ENDPOINT"`
-	MINIOAccessKey  string `env:"MINIO_ACCESS_KEY"`
-	MINIOSecretKey  string `env:"MINIO_SECRET_KEY"`
-	MINIOUseSSL     bool   `env:"MINIO_USE_SSL" envDefault:"false"`
-	MINIOBucketName string `env:"MINIO_BUCKET_NAME"`
-	MINIORegion     string `env:"MINIO_REGION"`
+	DbUrl                string               `env:"DB_URL"`
+	Port                 string               `env:"PORT" envDefault:"8080"`
+	JWTSecret            string               `env:"JWT_SECRET"`
+	AccessTokenTTL       time.Duration        `env:"ACCESS_TOKEN_TTL" envDefault:"1h"`
+	RefreshTokenTTL      time.Duration        `env:"REFRESH_TOKEN_TTL" envDefault:"720h"`
+	MINIOEndpoint        string               `env:"MINIO_ENDPOINT"`
+	MINIOAccessKey       string               `env:"MINIO_ACCESS_KEY"`
+	MINIOSecretKey       string               `env:"MINIO_SECRET_KEY"`
+	MINIOUseSSL          bool                 `env:"MINIO_USE_SSL" envDefault:"false"`
+	MINIOBucketName      string               `env:"MINIO_BUCKET_NAME"`
+	MINIORegion          string               `env:"MINIO_REGION"`
+	StorageDriver        string               `env:"STORAGE_DRIVER" envDefault:"minio"`
+	StorageLocalPath     string               `env:"STORAGE_LOCAL_PATH" envDefault:"./data"`
+	StorageBaseURL       string               `env:"STORAGE_BASE_URL"`
+	StorageURLMode       string               `env:"STORAGE_URL_MODE" envDefault:"public"`
+	CDNBaseURL           string               `env:"CDN_BASE_URL"`
+	PresignTTL           time.Duration        `env:"PRESIGN_TTL" envDefault:"15m"`
+	StoragePublicRead    bool                 `env:"STORAGE_PUBLIC_READ" envDefault:"false"`
+	StorageSigningKey    string               `env:"STORAGE_SIGNING_KEY"`
+	MaxUploadBytes       int64                `env:"MAX_UPLOAD_BYTES" envDefault:"5242880"`
+	MaxImagePixels       int64                `env:"MAX_IMAGE_PIXELS" envDefault:"40000000"`
+	MaxImageDimension    int                  `env:"MAX_IMAGE_DIMENSION" envDefault:"10000"`
+	StorageQuotaBytes    int64                `env:"STORAGE_QUOTA_BYTES" envDefault:"1073741824"`
+	StorageQuotaObjects  int64                `env:"STORAGE_QUOTA_OBJECTS" envDefault:"10000"`
+	AppBaseURL           string               `env:"APP_BASE_URL"`
+	MailerDriver         string               `env:"MAILER_DRIVER" envDefault:"log"`
+	MailerLogPath        string               `env:"MAILER_LOG_PATH"`
+	MailFrom             string               `env:"MAIL_FROM"`
+	SMTPHost             string               `env:"SMTP_HOST"`
+	SMTPPort             int                  `env:"SMTP_PORT" envDefault:"587"`
+	SMTPUsername         string               `env:"SMTP_USERNAME"`
+	SMTPPassword         string               `env:"SMTP_PASSWORD"`
+	RequireVerifiedEmail bool                 `env:"REQUIRE_VERIFIED_EMAIL" envDefault:"false"`
+	AdminEmails          []string             `env:"ADMIN_EMAILS"`
+	OIDCProviders        []OIDCProviderConfig `env:"OIDC_PROVIDERS"`
+	RateLimitAPI         string               `env:"RATE_LIMIT_API" envDefault:"600/1m"`
+	RateLimitAuth        string               `env:"RATE_LIMIT_AUTH" envDefault:"10/1m"`
+	RateLimitRegister    string               `env:"RATE_LIMIT_REGISTER" envDefault:"5/1h"`
+	RateLimitLogin       string               `env:"RATE_LIMIT_LOGIN" envDefault:"10/1m"`
+	RateLimitLoginUser   string               `env:"RATE_LIMIT_LOGIN_USER" envDefault:"5/5m"`
+	RateLimitTwoFactor   string               `env:"RATE_LIMIT_2FA" envDefault:"10/1m"`
+	RateLimitRefresh     string               `env:"RATE_LIMIT_REFRESH" envDefault:"60/1m"`
+	RateLimitOIDC        string               `env:"RATE_LIMIT_OIDC" envDefault:"20/1m"`
+	RateLimitProcessing  string               `env:"RATE_LIMIT_PROCESSING" envDefault:"30/1m"`
+	LoginMaxAttempts     int                  `env:"LOGIN_MAX_ATTEMPTS" envDefault:"5"`
+	LoginLockoutDuration time.Duration        `env:"LOGIN_LOCKOUT_DURATION" envDefault:"15m"`
+	TrustedProxies       []string             `env:"TRUSTED_PROXIES"`
+	JobWorkers           int                  `env:"JOB_WORKERS" envDefault:"2"`
+	JobPollInterval      time.Duration        `env:"JOB_POLL_INTERVAL" envDefault:"2s"`
+	JobLease             time.Duration        `env:"JOB_LEASE" envDefault:"1m"`
+	RenderCacheLimit     int                  `env:"RENDER_CACHE_LIMIT" envDefault:"50"`
 }
 
 var Config = &EnvConfig{}
@@ -27,17 +82,142 @@ func LoadEnvConfig() error {
 	}
 
 	Config = &EnvConfig{
-		DbUrl:           os.Getenv("DB_URL"),
-		Port:            os.Getenv("PORT"),
-		JWTSecret:       os.Getenv("JWT_SECRET"),
-		MINIOEndpoint:   os.Getenv("MINIO_ENDPOINT"),
-		MINIOAccessKey:  os.Getenv("MINIO_ACCESS_KEY"),
-		MINIOSecretKey:  os.Getenv("MINIO_SECRET_KEY"),
-		MINIOUseSSL:     os.Getenv("MINIO_USE_SSL") == "true",
-		MINIOBucketName: os.Getenv("MINIO_BUCKET_NAME"),
-		MINIORegion:     os.Getenv("MINIO_REGION"),
-	}
-
-	log.Printf("Environment configuration loaded: %+v\n", Config)
+		DbUrl:                os.Getenv("DB_URL"),
+		Port:                 os.Getenv("PORT"),
+		JWTSecret:            os.Getenv("JWT_SECRET"),
+		AccessTokenTTL:       getEnvDuration("ACCESS_TOKEN_TTL", time.Hour),
+		RefreshTokenTTL:      getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		MINIOEndpoint:        os.Getenv("MINIO_ENDPOINT"),
+		MINIOAccessKey:       os.Getenv("MINIO_ACCESS_KEY"),
+		MINIOSecretKey:       os.Getenv("MINIO_SECRET_KEY"),
+		MINIOUseSSL:          os.Getenv("MINIO_USE_SSL") == "true",
+		MINIOBucketName:      os.Getenv("MINIO_BUCKET_NAME"),
+		MINIORegion:          os.Getenv("MINIO_REGION"),
+		StorageDriver:        getEnv("STORAGE_DRIVER", "minio"),
+		StorageLocalPath:     getEnv("STORAGE_LOCAL_PATH", "./data"),
+		StorageBaseURL:       os.Getenv("STORAGE_BASE_URL"),
+		StorageURLMode:       getEnv("STORAGE_URL_MODE", "public"),
+		CDNBaseURL:           os.Getenv("CDN_BASE_URL"),
+		PresignTTL:           getEnvDuration("PRESIGN_TTL", 15*time.Minute),
+		StoragePublicRead:    os.Getenv("STORAGE_PUBLIC_READ") == "true",
+		StorageSigningKey:    os.Getenv("STORAGE_SIGNING_KEY"),
+		MaxUploadBytes:       int64(getEnvInt("MAX_UPLOAD_BYTES", 5*1024*1024)),
+		MaxImagePixels:       int64(getEnvInt("MAX_IMAGE_PIXELS", 40000000)),
+		MaxImageDimension:    getEnvInt("MAX_IMAGE_DIMENSION", 10000),
+		StorageQuotaBytes:    int64(getEnvInt("STORAGE_QUOTA_BYTES", 1<<30)),
+		StorageQuotaObjects:  int64(getEnvInt("STORAGE_QUOTA_OBJECTS", 10000)),
+		AppBaseURL:           os.Getenv("APP_BASE_URL"),
+		MailerDriver:         getEnv("MAILER_DRIVER", "log"),
+		MailerLogPath:        os.Getenv("MAILER_LOG_PATH"),
+		MailFrom:             getEnv("MAIL_FROM", "no-reply@vixel.local"),
+		SMTPHost:             os.Getenv("SMTP_HOST"),
+		SMTPPort:             getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:         os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:         os.Getenv("SMTP_PASSWORD"),
+		RequireVerifiedEmail: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+		AdminEmails:          getEnvList("ADMIN_EMAILS"),
+		RateLimitAPI:         getEnv("RATE_LIMIT_API", "600/1m"),
+		RateLimitAuth:        getEnv("RATE_LIMIT_AUTH", "10/1m"),
+		RateLimitRegister:    getEnv("RATE_LIMIT_REGISTER", "5/1h"),
+		RateLimitLogin:       getEnv("RATE_LIMIT_LOGIN", "10/1m"),
+		RateLimitLoginUser:   getEnv("RATE_LIMIT_LOGIN_USER", "5/5m"),
+		RateLimitTwoFactor:   getEnv("RATE_LIMIT_2FA", "10/1m"),
+		RateLimitRefresh:     getEnv("RATE_LIMIT_REFRESH", "60/1m"),
+		RateLimitOIDC:        getEnv("RATE_LIMIT_OIDC", "20/1m"),
+		RateLimitProcessing:  getEnv("RATE_LIMIT_PROCESSING", "30/1m"),
+		LoginMaxAttempts:     getEnvInt("LOGIN_MAX_ATTEMPTS", 5),
+		LoginLockoutDuration: getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		TrustedProxies:       getEnvList("TRUSTED_PROXIES"),
+		JobWorkers:           getEnvInt("JOB_WORKERS", 2),
+		JobPollInterval:      getEnvDuration("JOB_POLL_INTERVAL", 2*time.Second),
+		JobLease:             getEnvDuration("JOB_LEASE", time.Minute),
+		RenderCacheLimit:     getEnvInt("RENDER_CACHE_LIMIT", 50),
+	}
+	if Config.StorageBaseURL == "" {
+		Config.StorageBaseURL = "http://localhost" + Config.Port + "/storage"
+	}
+	if Config.AppBaseURL == "" {
+		Config.AppBaseURL = "http://localhost" + Config.Port
+	}
+	if Config.StorageDriver != "minio" && Config.StorageSigningKey == "" {
+		return errors.New("STORAGE_SIGNING_KEY is required unless STORAGE_DRIVER is minio")
+	}
+	if Config.StorageSigningKey != "" && Config.StorageSigningKey == Config.JWTSecret {
+		return errors.New("STORAGE_SIGNING_KEY must differ from JWT_SECRET")
+	}
+	Config.OIDCProviders = loadOIDCProviders(Config.AppBaseURL)
+
+	log.Printf("Environment configuration loaded: %+v\n", Config.redacted())
 	return nil
 }
+
+// redacted returns a copy of the configuration that is safe to log.
+func (c *EnvConfig) redacted() EnvConfig {
+	redacted := *c
+	for _, secret := range []*string{&redacted.JWTSecret, &redacted.MINIOSecretKey, &redacted.SMTPPassword, &redacted.StorageSigningKey} {
+		if *secret != "" {
+			*secret = "[redacted]"
+		}
+	}
+	redacted.OIDCProviders = make([]OIDCProviderConfig, len(c.OIDCProviders))
+	for i, provider := range c.OIDCProviders {
+		if provider.ClientSecret != "" {
+			provider.ClientSecret = "[redacted]"
+		}
+		redacted.OIDCProviders[i] = provider
+	}
+	return redacted
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func loadOIDCProviders(appBaseURL string) []OIDCProviderConfig {
+	var providers []OIDCProviderConfig
+	for _, name := range getEnvList("OIDC_PROVIDERS") {
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		scopes := getEnvList(prefix + "SCOPES")
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email", "profile"}
+		}
+		providers = append(providers, OIDCProviderConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", strings.TrimSuffix(appBaseURL, "/")+"/api/v1/users/oidc/"+name+"/callback"),
+			Scopes:       scopes,
+		})
+	}
+	return providers
+}
@@ -0,0 +1,129 @@
+package image
+
+import (
+	"errors"
+	"strconv"
+	"vixel/domains/user"
+	"vixel/shared/middlewares"
+	"vixel/shared/responses"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AdminHandler struct {
+	imageService ImageServiceInterface
+	cleaner      *ContentCleaner
+	urls         *URLResolver
+}
+
+func NewAdminHandler(service ImageServiceInterface, cleaner *ContentCleaner, urls *URLResolver) *AdminHandler {
+	return &AdminHandler{imageService: service, cleaner: cleaner, urls: urls}
+}
+
+func (h *AdminHandler) SetupAdminRoutes(rg *gin.RouterGroup) {
+	admin := rg.Group("/admin", middlewares.JWTMiddleware(), middlewares.RequireRole(user.RoleAdmin))
+	admin.GET("/users/:id/images", h.ListUserImages())
+	admin.GET("/users/:id/usage", h.GetUsage())
+	admin.PUT("/users/:id/quota", h.SetQuota())
+	admin.DELETE("/images/:id", h.DeleteImage())
+}
+
+func (h *AdminHandler) ListUserImages() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+		if err != nil {
+			responses.BadRequest(ctx, errors.New("invalid user id"))
+			return
+		}
+
+		images, err := h.imageService.ListImagesByUser(uint(userID))
+		if err != nil {
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		imageResponses := make([]ImageResponse, 0, len(images))
+		for _, img := range images {
+			response, err := img.ToResponse(ctx, h.urls)
+			if err != nil {
+				responses.InternalServerError(ctx, err)
+				return
+			}
+			imageResponses = append(imageResponses, response)
+		}
+
+		responses.Ok(ctx, imageResponses)
+	}
+}
+
+func (h *AdminHandler) GetUsage() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+		if err != nil {
+			responses.BadRequest(ctx, errors.New("invalid user id"))
+			return
+		}
+
+		usage, err := h.imageService.GetUsage(uint(userID))
+		if err != nil {
+			h.handleUserError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, usage)
+	}
+}
+
+func (h *AdminHandler) SetQuota() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+		if err != nil {
+			responses.BadRequest(ctx, errors.New("invalid user id"))
+			return
+		}
+
+		var dto SetQuotaDto
+		if err := ctx.ShouldBindJSON(&dto); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		usage, err := h.imageService.SetQuota(uint(userID), dto)
+		if err != nil {
+			h.handleUserError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, usage)
+	}
+}
+
+func (h *AdminHandler) handleUserError(ctx *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		responses.NotFound(ctx, errors.New("user not found"))
+		return
+	}
+	responses.InternalServerError(ctx, err)
+}
+
+func (h *AdminHandler) DeleteImage() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+		if err != nil {
+			responses.BadRequest(ctx, errors.New("invalid image id"))
+			return
+		}
+
+		if err := h.cleaner.DeleteImage(ctx, uint(id)); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				responses.NotFound(ctx, errors.New("image not found"))
+				return
+			}
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, gin.H{"message": "image deleted"})
+	}
+}
@@ -0,0 +1,107 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+type ImageReferences interface {
+	ReleaseImages(ctx context.Context, imageIDs []uint) error
+}
+
+type ContentCleaner struct {
+	db         *gorm.DB
+	uploads    *UploadService
+	references []ImageReferences
+}
+
+func NewContentCleaner(db *gorm.DB, uploads *UploadService, references ...ImageReferences) *ContentCleaner {
+	return &ContentCleaner{db: db, uploads: uploads, references: references}
+}
+
+func (c *ContentCleaner) DeleteUserContent(ctx context.Context, userID uint) error {
+	var images []Image
+	if err := c.db.Unscoped().Where("user_id = ?", userID).Find(&images).Error; err != nil {
+		return err
+	}
+	return c.deleteImages(ctx, images)
+}
+
+func (c *ContentCleaner) DeleteImage(ctx context.Context, imageID uint) error {
+	var image Image
+	if err := c.db.Unscoped().First(&image, imageID).Error; err != nil {
+		return err
+	}
+	return c.deleteImages(ctx, []Image{image})
+}
+
+func (c *ContentCleaner) deleteImages(ctx context.Context, images []Image) error {
+	if len(images) == 0 {
+		return nil
+	}
+
+	imageIDs := make([]uint, 0, len(images))
+	keys := make([]string, 0, len(images))
+	buckets := make(map[string]string, len(images))
+	for _, img := range images {
+		imageIDs = append(imageIDs, img.ID)
+		keys = append(keys, img.StorageKey)
+		buckets[img.StorageKey] = img.Bucket
+	}
+
+	for _, refs := range c.references {
+		if err := refs.ReleaseImages(ctx, imageIDs); err != nil {
+			return err
+		}
+	}
+
+	var versions []ImageVersion
+	if err := c.db.Unscoped().Where("image_id IN ?", imageIDs).Find(&versions).Error; err != nil {
+		return err
+	}
+	for _, v := range versions {
+		keys = append(keys, v.StorageKey)
+		buckets[v.StorageKey] = v.Bucket
+	}
+
+	var orphaned []string
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&Image{}).Where("id IN ?", imageIDs).Update("current_version_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("image_id IN ?", imageIDs).Delete(&ImageVersion{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("image_id IN ?", imageIDs).Delete(&RenderedVariant{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("id IN ?", imageIDs).Delete(&Image{}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		orphaned, err = ReleaseObjects(tx, keys)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range orphaned {
+		err := DeleteReleasedObject(c.db, key, func() error {
+			return c.uploads.DeleteInBucket(ctx, buckets[key], key)
+		})
+		if err != nil {
+			log.Printf("failed to delete object %s: %v", key, err)
+		}
+	}
+	for _, id := range imageIDs {
+		if err := c.uploads.DeletePrefix(ctx, fmt.Sprintf("renders/%d/", id)); err != nil {
+			log.Printf("failed to delete renders of image %d: %v", id, err)
+		}
+	}
+	return nil
+}
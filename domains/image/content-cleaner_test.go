@@ -0,0 +1,209 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+func TestContentCleaner_DeleteUserContent(t *testing.T) {
+	db := setupImageTestDB(t)
+	storage := NewMemoryStorage()
+	uploads := NewUploadService(storage)
+	ctx := context.Background()
+
+	put := func(key string) {
+		storage.Put(ctx, key, bytes.NewReader([]byte("x")), 1, "image/png")
+	}
+	put("original")
+	put("version")
+	put("renders/1/v0/abc")
+	put("other")
+
+	img := &Image{StorageKey: "original", UserID: 1}
+	db.Create(img)
+	version := &ImageVersion{ImageID: img.ID, StorageKey: "version"}
+	db.Create(version)
+	db.Model(img).Update("current_version_id", version.ID)
+	db.Create(&Image{StorageKey: "other", UserID: 2})
+
+	if err := NewContentCleaner(db, uploads).DeleteUserContent(ctx, 1); err != nil {
+		t.Fatalf("DeleteUserContent failed: %v", err)
+	}
+
+	var count int64
+	db.Unscoped().Model(&Image{}).Where("user_id = ?", 1).Count(&count)
+	if count != 0 {
+		t.Error("Images of the user should be deleted")
+	}
+	db.Unscoped().Model(&ImageVersion{}).Count(&count)
+	if count != 0 {
+		t.Error("Versions of the user's images should be deleted")
+	}
+
+	objects, _ := storage.List(ctx, "")
+	if len(objects) != 1 || objects[0].Key != "other" {
+		t.Errorf("Only other users' objects should remain, got %+v", objects)
+	}
+}
+
+type recordingReferences struct {
+	released []uint
+}
+
+func (r *recordingReferences) ReleaseImages(ctx context.Context, imageIDs []uint) error {
+	r.released = append(r.released, imageIDs...)
+	return nil
+}
+
+func TestContentCleaner_DeleteImage(t *testing.T) {
+	db := setupImageTestDB(t)
+	storage := NewMemoryStorage()
+	refs := &recordingReferences{}
+	cleaner := NewContentCleaner(db, NewUploadService(storage), refs)
+	ctx := context.Background()
+
+	storage.Put(ctx, "original", bytes.NewReader([]byte("x")), 1, "image/png")
+	img := &Image{StorageKey: "original", UserID: 1}
+	db.Create(img)
+	db.Delete(img)
+
+	if err := cleaner.DeleteImage(ctx, img.ID); err != nil {
+		t.Fatalf("DeleteImage failed: %v", err)
+	}
+	if len(refs.released) != 1 || refs.released[0] != img.ID {
+		t.Errorf("Expected references to image %d to be released, got %v", img.ID, refs.released)
+	}
+	if _, err := storage.Stat(ctx, "original"); err == nil {
+		t.Error("Soft-deleted image's object should be removed")
+	}
+	if err := cleaner.DeleteImage(ctx, img.ID); err == nil {
+		t.Error("Expected an error for a missing image")
+	}
+}
+
+func TestContentCleaner_KeepsSharedObjects(t *testing.T) {
+	db := setupImageTestDB(t)
+	storage := NewMemoryStorage()
+	uploads := NewUploadService(storage)
+	service := NewImageService(db)
+	cleaner := NewContentCleaner(db, uploads)
+	ctx := context.Background()
+
+	data := []byte("same bytes")
+	first, _ := uploads.UploadImageFromBytes(ctx, data, "png")
+	second, _ := uploads.UploadImageFromBytes(ctx, data, "png")
+	if first != second || first != ContentKey(HashBytes(data), "png") {
+		t.Fatalf("Expected identical content to share a key, got %q and %q", first, second)
+	}
+
+	a, _ := service.SaveImage(&Image{StorageKey: first, UserID: 1})
+	b, _ := service.SaveImage(&Image{StorageKey: second, UserID: 2})
+
+	if err := cleaner.DeleteImage(ctx, a.ID); err != nil {
+		t.Fatalf("DeleteImage failed: %v", err)
+	}
+	if _, err := storage.Stat(ctx, first); err != nil {
+		t.Fatalf("Object should remain while another image references it: %v", err)
+	}
+
+	if err := cleaner.DeleteImage(ctx, b.ID); err != nil {
+		t.Fatalf("DeleteImage failed: %v", err)
+	}
+	if _, err := storage.Stat(ctx, first); err == nil {
+		t.Error("Object should be removed with its last reference")
+	}
+	var count int64
+	db.Model(&StoredObject{}).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected the reference row to be removed, got %d", count)
+	}
+}
+
+func TestStoredObject_UploadDuringDeleteIsStoredAgain(t *testing.T) {
+	db := setupImageTestDB(t)
+	storage := NewMemoryStorage()
+	uploads := NewUploadService(storage)
+	service := NewImageService(db)
+	ctx := context.Background()
+
+	data := []byte("same bytes")
+	key := ContentKey(HashBytes(data), "png")
+	stores := 0
+	store := func() error {
+		stores++
+		_, err := uploads.UploadImageFromBytes(ctx, data, "png")
+		return err
+	}
+	deleteObject := func() error { return storage.Delete(ctx, key) }
+
+	first, _ := service.SaveUpload(&Image{StorageKey: key, UserID: 1}, store)
+	service.SaveUpload(&Image{StorageKey: key, UserID: 2}, store)
+	if stores != 1 {
+		t.Fatalf("Expected a stored object to be reused, got %d stores", stores)
+	}
+
+	// The last reference is released while the object is being uploaded
+	// again, and the upload is recorded before the object is deleted.
+	db.Delete(first)
+	orphaned, _ := ReleaseObjects(db, []string{key, key})
+	if len(orphaned) != 1 {
+		t.Fatalf("Expected the object to be released, got %v", orphaned)
+	}
+	if _, err := service.SaveUpload(&Image{StorageKey: key, UserID: 3}, store); err != nil {
+		t.Fatalf("SaveUpload failed: %v", err)
+	}
+	if err := DeleteReleasedObject(db, key, deleteObject); err != nil {
+		t.Fatalf("DeleteReleasedObject failed: %v", err)
+	}
+	if _, err := storage.Stat(ctx, key); err != nil || stores != 2 {
+		t.Fatalf("Expected the revived object to be kept, got %v after %d stores", err, stores)
+	}
+
+	// The object is deleted before the upload is recorded.
+	orphaned, _ = ReleaseObjects(db, []string{key})
+	if err := DeleteReleasedObject(db, key, deleteObject); err != nil || len(orphaned) != 1 {
+		t.Fatalf("DeleteReleasedObject failed: %v", err)
+	}
+	if _, err := storage.Stat(ctx, key); err == nil {
+		t.Fatal("Expected the released object to be deleted")
+	}
+	service.SaveUpload(&Image{StorageKey: key, UserID: 4}, store)
+	if _, err := storage.Stat(ctx, key); err != nil || stores != 3 {
+		t.Errorf("Expected the upload to store the object again, got %v after %d stores", err, stores)
+	}
+}
+
+func TestContentCleaner_DeletesFromTheRowBucket(t *testing.T) {
+	db := setupImageTestDB(t)
+	storage := newBucketedStorage("vixel", "legacy")
+	legacy := storage.buckets["legacy"]
+	uploads := NewUploadService(storage)
+	ctx := context.Background()
+
+	legacy.Put(ctx, "old", bytes.NewReader([]byte("legacy")), 6, "image/png")
+	storage.Put(ctx, "old", bytes.NewReader([]byte("other")), 5, "image/png")
+	img := &Image{StorageKey: "old", Bucket: "legacy", UserID: 1}
+	db.Create(img)
+
+	reader, _, err := uploads.OpenCurrentImage(ctx, img)
+	if err != nil {
+		t.Fatalf("OpenCurrentImage failed: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "legacy" {
+		t.Errorf("Expected the object from the row's bucket, got %q", data)
+	}
+
+	if err := NewContentCleaner(db, uploads).DeleteUserContent(ctx, 1); err != nil {
+		t.Fatalf("DeleteUserContent failed: %v", err)
+	}
+	if _, err := legacy.Stat(ctx, "old"); err == nil {
+		t.Error("Object in the row's bucket should be deleted")
+	}
+	if _, err := storage.Stat(ctx, "old"); err != nil {
+		t.Error("Object with the same key in the configured bucket should remain")
+	}
+}
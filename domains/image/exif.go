@@ -0,0 +1,454 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+var ErrNoExif = errors.New("no exif data")
+
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagSoftware         = 0x0131
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagExposureTime     = 0x829A
+	tagFNumber          = 0x829D
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagFocalLength      = 0x920A
+	tagLensModel        = 0xA434
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+	tagGPSAltitudeRef  = 0x0005
+	tagGPSAltitude     = 0x0006
+)
+
+type ExifData struct {
+	Make         string     `json:"make,omitempty"`
+	Model        string     `json:"model,omitempty"`
+	LensModel    string     `json:"lens_model,omitempty"`
+	Software     string     `json:"software,omitempty"`
+	Orientation  int        `json:"orientation,omitempty"`
+	CapturedAt   *time.Time `json:"captured_at,omitempty"`
+	ExposureTime float64    `json:"exposure_time,omitempty"`
+	FNumber      float64    `json:"f_number,omitempty"`
+	ISO          int        `json:"iso,omitempty"`
+	FocalLength  float64    `json:"focal_length,omitempty"`
+	GPS          *GPSData   `json:"gps,omitempty"`
+}
+
+type GPSData struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+}
+
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func ParseExif(data []byte) (*ExifData, error) {
+	return ReadExif(bytes.NewReader(data), int64(len(data)))
+}
+
+// ReadExif reads only the part of a file that holds its EXIF data. TIFF tags
+// can point anywhere in the file, so TIFF files are read whole.
+func ReadExif(r io.ReaderAt, size int64) (*ExifData, error) {
+	header := make([]byte, 12)
+	n, _ := r.ReadAt(header, 0)
+	header = header[:n]
+
+	switch {
+	case isJPEG(header):
+		return readJPEGExif(r, size)
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return readPNGExif(r, size)
+	case isWebP(header):
+		return readWebPExif(r, size)
+	case isTIFF(header):
+		data, err := io.ReadAll(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, err
+		}
+		return parseTIFFExif(data)
+	}
+	return nil, ErrNoExif
+}
+
+func isJPEG(data []byte) bool {
+	return len(data) > 2 && data[0] == 0xFF && data[1] == 0xD8
+}
+
+func isTIFF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*"))
+}
+
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+func readJPEGExif(r io.ReaderAt, size int64) (*ExifData, error) {
+	segments, err := jpegSegments(r, size)
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		if segment.isExif() {
+			return parseTIFFExif(segment.payload[6:])
+		}
+	}
+	return nil, ErrNoExif
+}
+
+// readPNGExif walks the chunk headers and only reads the eXIf payload.
+func readPNGExif(r io.ReaderAt, size int64) (*ExifData, error) {
+	chunks, err := pngChunks(r, size)
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range chunks {
+		if chunk.kind == "eXIf" {
+			payload, err := readRange(r, chunk.start+8, chunk.end-4)
+			if err != nil {
+				return nil, err
+			}
+			return parseTIFFExif(payload)
+		}
+	}
+	return nil, ErrNoExif
+}
+
+// readWebPExif walks the RIFF chunk headers and only reads the EXIF payload,
+// which follows the image data. Some writers keep the JPEG "Exif" prefix in
+// front of the TIFF header.
+func readWebPExif(r io.ReaderAt, size int64) (*ExifData, error) {
+	chunks, err := webpChunks(r, size)
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range chunks {
+		if chunk.kind == "EXIF" {
+			payload, err := readRange(r, chunk.start+8, chunk.start+8+chunk.length)
+			if err != nil {
+				return nil, err
+			}
+			return parseTIFFExif(bytes.TrimPrefix(payload, []byte("Exif\x00\x00")))
+		}
+	}
+	return nil, ErrNoExif
+}
+
+func readRange(r io.ReaderAt, start, end int64) ([]byte, error) {
+	data := make([]byte, end-start)
+	if _, err := r.ReadAt(data, start); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// jpegSegment is a marker segment before the image data. Only APP1 payloads
+// are read, as they are the only ones told apart by their content.
+type jpegSegment struct {
+	marker  byte
+	start   int64
+	end     int64
+	payload []byte
+}
+
+func (s jpegSegment) isExif() bool {
+	return s.marker == 0xE1 && bytes.HasPrefix(s.payload, []byte("Exif\x00\x00"))
+}
+
+func jpegSegments(r io.ReaderAt, size int64) ([]jpegSegment, error) {
+	var segments []jpegSegment
+	header := make([]byte, 4)
+	for pos := int64(2); pos+4 <= size; {
+		if _, err := r.ReadAt(header, pos); err != nil {
+			return nil, err
+		}
+		if header[0] != 0xFF {
+			break
+		}
+		marker := header[1]
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		if marker == 0xD8 || (marker >= 0xD0 && marker <= 0xD7) || marker == 0x01 {
+			pos += 2
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+
+		length := int64(binary.BigEndian.Uint16(header[2:]))
+		end := pos + 2 + length
+		if length < 2 || end > size {
+			break
+		}
+		segment := jpegSegment{marker: marker, start: pos, end: end}
+		if marker == 0xE1 {
+			payload, err := readRange(r, pos+4, end)
+			if err != nil {
+				return nil, err
+			}
+			segment.payload = payload
+		}
+		segments = append(segments, segment)
+		pos = end
+	}
+	return segments, nil
+}
+
+type pngChunk struct {
+	kind  string
+	start int64
+	end   int64
+}
+
+func pngChunks(r io.ReaderAt, size int64) ([]pngChunk, error) {
+	var chunks []pngChunk
+	header := make([]byte, 8)
+	for pos := int64(8); pos+12 <= size; {
+		if _, err := r.ReadAt(header, pos); err != nil {
+			return nil, err
+		}
+		end := pos + 12 + int64(binary.BigEndian.Uint32(header))
+		if end > size {
+			break
+		}
+		kind := string(header[4:])
+		chunks = append(chunks, pngChunk{kind: kind, start: pos, end: end})
+		if kind == "IEND" {
+			break
+		}
+		pos = end
+	}
+	return chunks, nil
+}
+
+// webpChunk is a RIFF chunk of a WebP file. Chunks are padded to an even
+// length; end includes the padding.
+type webpChunk struct {
+	kind   string
+	start  int64
+	end    int64
+	length int64
+}
+
+func webpChunks(r io.ReaderAt, size int64) ([]webpChunk, error) {
+	var chunks []webpChunk
+	header := make([]byte, 8)
+	for pos := int64(12); pos+8 <= size; {
+		if _, err := r.ReadAt(header, pos); err != nil {
+			return nil, err
+		}
+		length := int64(binary.LittleEndian.Uint32(header[4:]))
+		end := pos + 8 + length
+		if end > size {
+			break
+		}
+		end = min(end+length%2, size)
+		chunks = append(chunks, webpChunk{kind: string(header[:4]), start: pos, end: end, length: length})
+		pos = end
+	}
+	return chunks, nil
+}
+
+func newTIFFReader(data []byte) (*tiffReader, uint32, error) {
+	if len(data) < 8 {
+		return nil, 0, ErrNoExif
+	}
+	r := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return nil, 0, ErrNoExif
+	}
+	if r.order.Uint16(data[2:]) != 42 {
+		return nil, 0, ErrNoExif
+	}
+	return r, r.order.Uint32(data[4:]), nil
+}
+
+func parseTIFFExif(data []byte) (*ExifData, error) {
+	r, offset, err := newTIFFReader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ifd0, err := r.readIFD(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	exif := &ExifData{
+		Make:        r.ascii(ifd0[tagMake]),
+		Model:       r.ascii(ifd0[tagModel]),
+		Software:    r.ascii(ifd0[tagSoftware]),
+		Orientation: int(r.uint(ifd0[tagOrientation])),
+		CapturedAt:  parseExifTime(r.ascii(ifd0[tagDateTime])),
+	}
+
+	if entry, ok := ifd0[tagExifIFD]; ok {
+		if sub, err := r.readIFD(r.uint(entry)); err == nil {
+			if captured := parseExifTime(r.ascii(sub[tagDateTimeOriginal])); captured != nil {
+				exif.CapturedAt = captured
+			}
+			exif.ExposureTime = r.rational(sub[tagExposureTime], 0)
+			exif.FNumber = r.rational(sub[tagFNumber], 0)
+			exif.ISO = int(r.uint(sub[tagISO]))
+			exif.FocalLength = r.rational(sub[tagFocalLength], 0)
+			exif.LensModel = r.ascii(sub[tagLensModel])
+		}
+	}
+
+	if entry, ok := ifd0[tagGPSIFD]; ok {
+		if gps, err := r.readIFD(r.uint(entry)); err == nil {
+			exif.GPS = r.gps(gps)
+		}
+	}
+
+	return exif, nil
+}
+
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+func (r *tiffReader) readIFD(offset uint32) (map[uint16]tiffEntry, error) {
+	start := int(offset)
+	if offset == 0 || start+2 > len(r.data) {
+		return nil, ErrNoExif
+	}
+	count := int(r.order.Uint16(r.data[start:]))
+	if start+2+count*12 > len(r.data) {
+		return nil, ErrNoExif
+	}
+
+	entries := make(map[uint16]tiffEntry, count)
+	for i := 0; i < count; i++ {
+		pos := start + 2 + i*12
+		entry := tiffEntry{
+			tag:   r.order.Uint16(r.data[pos:]),
+			typ:   r.order.Uint16(r.data[pos+2:]),
+			count: r.order.Uint32(r.data[pos+4:]),
+		}
+		size, ok := typeSizes[entry.typ]
+		if !ok || entry.count > uint32(len(r.data)) {
+			continue
+		}
+		total := size * int(entry.count)
+		if total <= 4 {
+			entry.value = r.data[pos+8 : pos+8+total]
+		} else {
+			valueOffset := int(r.order.Uint32(r.data[pos+8:]))
+			if valueOffset < 0 || valueOffset+total > len(r.data) {
+				continue
+			}
+			entry.value = r.data[valueOffset : valueOffset+total]
+		}
+		entries[entry.tag] = entry
+	}
+	return entries, nil
+}
+
+func (r *tiffReader) ascii(e tiffEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+func (r *tiffReader) uint(e tiffEntry) uint32 {
+	switch {
+	case e.typ == 1 && len(e.value) >= 1:
+		return uint32(e.value[0])
+	case e.typ == 3 && len(e.value) >= 2:
+		return uint32(r.order.Uint16(e.value))
+	case e.typ == 4 && len(e.value) >= 4:
+		return r.order.Uint32(e.value)
+	}
+	return 0
+}
+
+func (r *tiffReader) rational(e tiffEntry, index int) float64 {
+	if (e.typ != 5 && e.typ != 10) || len(e.value) < (index+1)*8 {
+		return 0
+	}
+	value := e.value[index*8:]
+	if e.typ == 10 {
+		num, den := int32(r.order.Uint32(value)), int32(r.order.Uint32(value[4:]))
+		if den == 0 {
+			return 0
+		}
+		return float64(num) / float64(den)
+	}
+	num, den := r.order.Uint32(value), r.order.Uint32(value[4:])
+	if den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
+
+func (r *tiffReader) gps(entries map[uint16]tiffEntry) *GPSData {
+	lat, latOK := r.coordinate(entries[tagGPSLatitude], r.ascii(entries[tagGPSLatitudeRef]), "S")
+	lon, lonOK := r.coordinate(entries[tagGPSLongitude], r.ascii(entries[tagGPSLongitudeRef]), "W")
+	if !latOK || !lonOK {
+		return nil
+	}
+
+	gps := &GPSData{Latitude: lat, Longitude: lon}
+	if entry, ok := entries[tagGPSAltitude]; ok {
+		altitude := r.rational(entry, 0)
+		if r.uint(entries[tagGPSAltitudeRef]) == 1 {
+			altitude = -altitude
+		}
+		gps.Altitude = &altitude
+	}
+	return gps
+}
+
+func (r *tiffReader) coordinate(e tiffEntry, ref, negative string) (float64, bool) {
+	if e.count < 3 {
+		return 0, false
+	}
+	value := r.rational(e, 0) + r.rational(e, 1)/60 + r.rational(e, 2)/3600
+	if ref == negative {
+		value = -value
+	}
+	return math.Round(value*1e7) / 1e7, true
+}
+
+func parseExifTime(value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", value, time.UTC)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,230 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"slices"
+	"testing"
+	"time"
+)
+
+type exifEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+	sub   []exifEntry
+}
+
+func asciiEntry(tag uint16, value string) exifEntry {
+	return exifEntry{tag: tag, typ: 2, count: uint32(len(value) + 1), value: append([]byte(value), 0)}
+}
+
+func shortEntry(tag uint16, value uint16) exifEntry {
+	return exifEntry{tag: tag, typ: 3, count: 1, value: binary.LittleEndian.AppendUint16(nil, value)}
+}
+
+func rationalEntry(tag uint16, values ...[2]uint32) exifEntry {
+	var data []byte
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint32(data, v[0])
+		data = binary.LittleEndian.AppendUint32(data, v[1])
+	}
+	return exifEntry{tag: tag, typ: 5, count: uint32(len(values)), value: data}
+}
+
+func subIFDEntry(tag uint16, entries ...exifEntry) exifEntry {
+	return exifEntry{tag: tag, typ: 4, count: 1, sub: entries}
+}
+
+func buildTIFF(entries ...exifEntry) []byte {
+	out := []byte("II*\x00\x08\x00\x00\x00")
+	return writeTestIFD(out, entries)
+}
+
+func writeTestIFD(out []byte, entries []exifEntry) []byte {
+	start := len(out)
+	size := 2 + len(entries)*12 + 4
+	ifd := make([]byte, size)
+	binary.LittleEndian.PutUint16(ifd, uint16(len(entries)))
+
+	var extra []byte
+	for i, e := range entries {
+		pos := 2 + i*12
+		binary.LittleEndian.PutUint16(ifd[pos:], e.tag)
+		binary.LittleEndian.PutUint16(ifd[pos+2:], e.typ)
+		binary.LittleEndian.PutUint32(ifd[pos+4:], e.count)
+		if e.sub != nil {
+			continue
+		}
+		if len(e.value) <= 4 {
+			copy(ifd[pos+8:], e.value)
+		} else {
+			binary.LittleEndian.PutUint32(ifd[pos+8:], uint32(start+size+len(extra)))
+			extra = append(extra, e.value...)
+		}
+	}
+	out = append(out, ifd...)
+	out = append(out, extra...)
+
+	for i, e := range entries {
+		if e.sub != nil {
+			binary.LittleEndian.PutUint32(out[start+2+i*12+8:], uint32(len(out)))
+			out = writeTestIFD(out, e.sub)
+		}
+	}
+	return out
+}
+
+func jpegWithExif(t *testing.T, width, height int, tiff []byte) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / width), 80, 160, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	data := buf.Bytes()
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func testExifTIFF() []byte {
+	return buildTIFF(
+		asciiEntry(tagMake, "Vixel"),
+		asciiEntry(tagModel, "Camera One"),
+		shortEntry(tagOrientation, 6),
+		subIFDEntry(tagExifIFD,
+			asciiEntry(tagDateTimeOriginal, "2024:05:06 07:08:09"),
+			rationalEntry(tagFNumber, [2]uint32{28, 10}),
+			shortEntry(tagISO, 200),
+		),
+		subIFDEntry(tagGPSIFD,
+			asciiEntry(tagGPSLatitudeRef, "N"),
+			rationalEntry(tagGPSLatitude, [2]uint32{37, 1}, [2]uint32{46, 1}, [2]uint32{2964, 100}),
+			asciiEntry(tagGPSLongitudeRef, "W"),
+			rationalEntry(tagGPSLongitude, [2]uint32{122, 1}, [2]uint32{25, 1}, [2]uint32{984, 100}),
+		),
+	)
+}
+
+func TestParseExif_JPEG(t *testing.T) {
+	exif, err := ParseExif(jpegWithExif(t, 8, 4, testExifTIFF()))
+	if err != nil {
+		t.Fatalf("ParseExif failed: %v", err)
+	}
+
+	if exif.Make != "Vixel" || exif.Model != "Camera One" || exif.Orientation != 6 || exif.ISO != 200 || exif.FNumber != 2.8 {
+		t.Errorf("Unexpected exif: %+v", exif)
+	}
+	if exif.CapturedAt == nil || !exif.CapturedAt.Equal(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)) {
+		t.Errorf("Unexpected capture time: %v", exif.CapturedAt)
+	}
+	if exif.GPS == nil || exif.GPS.Latitude < 37.7748 || exif.GPS.Latitude > 37.775 || exif.GPS.Longitude > -122.4193 || exif.GPS.Longitude < -122.4195 {
+		t.Errorf("Unexpected GPS: %+v", exif.GPS)
+	}
+}
+
+func TestParseExif_NoExif(t *testing.T) {
+	if _, err := ParseExif(createTestImageData()); err != ErrNoExif {
+		t.Errorf("Expected ErrNoExif, got %v", err)
+	}
+	if _, err := ParseExif(jpegWithExif(t, 4, 4, []byte("II*\x00\xff\xff\x00\x00"))); err == nil {
+		t.Error("Expected an error for a truncated exif block")
+	}
+}
+
+func TestExtractMetadata(t *testing.T) {
+	data := jpegWithExif(t, 8, 4, testExifTIFF())
+	metadata, err := ExtractMetadata(data)
+	if err != nil {
+		t.Fatalf("ExtractMetadata failed: %v", err)
+	}
+
+	if metadata.Width != 4 || metadata.Height != 8 || metadata.Format != "jpeg" || metadata.ContentType != "image/jpeg" || metadata.Size != int64(len(data)) {
+		t.Errorf("Unexpected metadata: %+v", metadata)
+	}
+	if metadata.Exif == nil || metadata.Exif.Make != "Vixel" {
+		t.Errorf("Expected exif to be parsed, got %+v", metadata.Exif)
+	}
+	if metadata.Colors == nil || len(metadata.Colors.Average) != 7 || metadata.Colors.Brightness <= 0 {
+		t.Errorf("Unexpected color stats: %+v", metadata.Colors)
+	}
+}
+
+func TestImage_ToMetadataResponse_HidesGPSFromOthers(t *testing.T) {
+	exif, _ := ParseExif(jpegWithExif(t, 4, 4, testExifTIFF()))
+	img := Image{UserID: 1, Exif: exif, Visibility: VisibilityPublic}
+
+	if img.ToMetadataResponse(Actor{UserID: 1}).Exif.GPS == nil {
+		t.Error("Owner should see GPS coordinates")
+	}
+	response := img.ToMetadataResponse(Actor{UserID: 2})
+	if response.Exif.GPS != nil || response.Exif.Make != "Vixel" {
+		t.Errorf("Other users should see exif without GPS, got %+v", response.Exif)
+	}
+	if img.Exif.GPS == nil {
+		t.Error("Redaction should not modify the stored exif")
+	}
+}
+
+type countingReaderAt struct {
+	r    io.ReaderAt
+	read int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.read += int64(n)
+	return n, err
+}
+
+func TestReadExif_ReadsOnlyTheHeader(t *testing.T) {
+	header := jpegWithExif(t, 8, 4, testExifTIFF())
+	jpegData := slices.Concat(header, make([]byte, 1<<18))
+	r := &countingReaderAt{r: bytes.NewReader(jpegData)}
+	exif, err := ReadExif(r, int64(len(jpegData)))
+	if err != nil || exif.Make != "Vixel" {
+		t.Fatalf("Expected the JPEG exif to be read, got %+v, %v", exif, err)
+	}
+	if r.read >= int64(len(header)) {
+		t.Errorf("Expected the image data to be skipped, read %d of %d bytes", r.read, len(jpegData))
+	}
+
+	var buf bytes.Buffer
+	noise := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for i := range noise.Pix {
+		noise.Pix[i] = byte(i * 31 % 251)
+	}
+	if err := png.Encode(&buf, noise); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	encoded := buf.Bytes()
+	var chunk bytes.Buffer
+	writePNGChunk(&chunk, "eXIf", testExifTIFF())
+	pngData := slices.Concat(encoded[:len(encoded)-12], chunk.Bytes(), encoded[len(encoded)-12:])
+
+	r = &countingReaderAt{r: bytes.NewReader(pngData)}
+	exif, err = ReadExif(r, int64(len(pngData)))
+	if err != nil || exif.Make != "Vixel" {
+		t.Fatalf("Expected the PNG exif to be read, got %+v, %v", exif, err)
+	}
+	if r.read >= int64(len(encoded)) {
+		t.Errorf("Expected the image data to be skipped, read %d of %d bytes", r.read, len(pngData))
+	}
+}
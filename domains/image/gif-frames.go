@@ -0,0 +1,95 @@
+package image
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+var errInvalidGIF = errors.New("gif: invalid block structure")
+
+// CountGIFFrames counts the image descriptors of a GIF by walking its block
+// structure, so the pixel budget can be applied to an animation before any
+// frame is decoded.
+func CountGIFFrames(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return 0, err
+	}
+	if string(header[:3]) != "GIF" {
+		return 0, errInvalidGIF
+	}
+	if err := skipColorTable(br, header[10]); err != nil {
+		return 0, err
+	}
+
+	frames := 0
+	descriptor := make([]byte, 9)
+	for {
+		introducer, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		switch introducer {
+		case 0x21:
+			if _, err := br.ReadByte(); err != nil {
+				return 0, err
+			}
+			if err := skipSubBlocks(br); err != nil {
+				return 0, err
+			}
+		case 0x2C:
+			if _, err := io.ReadFull(br, descriptor); err != nil {
+				return 0, err
+			}
+			if err := skipColorTable(br, descriptor[8]); err != nil {
+				return 0, err
+			}
+			if _, err := br.ReadByte(); err != nil {
+				return 0, err
+			}
+			if err := skipSubBlocks(br); err != nil {
+				return 0, err
+			}
+			frames++
+		case 0x3B:
+			return frames, nil
+		default:
+			return 0, errInvalidGIF
+		}
+	}
+}
+
+// CheckGIFFrames applies the pixel budget to every frame of a GIF together.
+func CheckGIFFrames(r io.Reader, width, height int) error {
+	frames, err := CountGIFFrames(r)
+	if err != nil {
+		return err
+	}
+	return CheckFrames(width, height, frames)
+}
+
+func skipColorTable(br *bufio.Reader, flags byte) error {
+	if flags&0x80 == 0 {
+		return nil
+	}
+	_, err := br.Discard(3 << (flags&0x07 + 1))
+	return err
+}
+
+func skipSubBlocks(br *bufio.Reader) error {
+	for {
+		size, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		if _, err := br.Discard(int(size)); err != nil {
+			return err
+		}
+	}
+}
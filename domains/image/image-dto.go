@@ -1,41 +1,145 @@
 package image
 
 import (
-	"image"
+	"encoding/json"
+	"errors"
+	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
 	"mime/multipart"
+	"time"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
+var uploadFormats = map[string]bool{"jpeg": true, "png": true, "webp": true, "gif": true, "bmp": true, "tiff": true}
+
 type SaveImageDto struct {
-	File    *multipart.FileHeader `form:"file" binding:"required"`
-	AltText string                `form:"alt_text"`
+	File           *multipart.FileHeader `form:"file" binding:"required"`
+	AltText        string                `form:"alt_text"`
+	Visibility     string                `form:"visibility" binding:"omitempty,oneof=private public unlisted"`
+	AllowDuplicate bool                  `form:"allow_duplicate"`
+	StripMetadata  string                `form:"strip_metadata" binding:"omitempty,oneof=none gps all"`
 }
 
-func (d SaveImageDto) IsValid() string {
-	if d.File.Size > 5*1024*1024 {
-		return "file size exceeds 5MB limit"
+func (d SaveImageDto) IsValid() error {
+	if d.Visibility != "" && !IsValidVisibility(d.Visibility) {
+		return errors.New("visibility must be private, public or unlisted")
+	}
+
+	if err := CheckFileSize(d.File.Size); err != nil {
+		return err
 	}
 
 	f, err := d.File.Open()
 	if err != nil {
-		return "unsupported file"
+		return errors.New("unsupported file")
 	}
 	defer f.Close()
-	_, format, err := image.Decode(f)
+
+	cfg, format, err := DecodeConfig(f)
+	if IsLimitError(err) {
+		return err
+	}
 	if err != nil {
-		return "unsupported image format"
+		return errors.New("unsupported image format")
 	}
 
-	if format != "jpeg" && format != "png" {
-		return "only JPEG and PNG formats are supported"
+	if !uploadFormats[format] {
+		return errors.New("only JPEG, PNG, WebP, GIF, BMP and TIFF formats are supported")
 	}
-	return ""
+
+	if format == "gif" {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return errors.New("unsupported file")
+		}
+		err := CheckGIFFrames(f, cfg.Width, cfg.Height)
+		if IsLimitError(err) {
+			return err
+		}
+		if err != nil {
+			return errors.New("unsupported image format")
+		}
+	}
+	return nil
 }
 
 type ImageResponse struct {
-	ID      uint   `json:"id"`
-	URL     string `json:"url"`
-	AltText string `json:"alt_text"`
-	UserID  uint   `json:"user_id"`
+	ID               uint   `json:"id"`
+	URL              string `json:"url"`
+	OriginalURL      string `json:"original_url"`
+	AltText          string `json:"alt_text"`
+	Visibility       string `json:"visibility"`
+	UserID           uint   `json:"user_id"`
+	CurrentVersionID *uint  `json:"current_version_id"`
+	Width            int    `json:"width"`
+	Height           int    `json:"height"`
+	Format           string `json:"format"`
+	ContentType      string `json:"content_type"`
+	Size             int64  `json:"size"`
+}
+
+type MetadataResponse struct {
+	ID          uint              `json:"id"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	Format      string            `json:"format"`
+	ContentType string            `json:"content_type"`
+	Size        int64             `json:"size"`
+	ContentHash string            `json:"content_hash"`
+	Exif        *ExifData         `json:"exif"`
+	Colors      *ColorStats       `json:"colors"`
+	Stripped    *StrippedMetadata `json:"stripped_metadata"`
+}
+
+type UsageResponse struct {
+	BytesUsed    int64  `json:"bytes_used"`
+	ObjectsUsed  int64  `json:"objects_used"`
+	BytesLimit   *int64 `json:"bytes_limit"`
+	ObjectsLimit *int64 `json:"objects_limit"`
+}
+
+type SetQuotaDto struct {
+	Bytes   *int64 `json:"bytes" binding:"omitempty,min=0"`
+	Objects *int64 `json:"objects" binding:"omitempty,min=0"`
+}
+
+type SimilarImagesQuery struct {
+	MaxDistance *int `form:"max_distance" binding:"omitempty,min=0,max=64"`
+	Limit       *int `form:"limit" binding:"omitempty,min=1,max=100"`
+	All         bool `form:"all"`
+}
+
+type SimilarImageResponse struct {
+	ImageResponse
+	Distance int `json:"distance"`
+}
+
+type UpdateVisibilityDto struct {
+	Visibility string `json:"visibility" binding:"required,oneof=private public unlisted"`
+}
+
+type VersionResponse struct {
+	ID              uint            `json:"id"`
+	ImageID         uint            `json:"image_id"`
+	ParentVersionID *uint           `json:"parent_version_id"`
+	Operations      json.RawMessage `json:"operations"`
+	URL             string          `json:"url"`
+	Width           int             `json:"width"`
+	Height          int             `json:"height"`
+	Size            int64           `json:"size"`
+	Format          string          `json:"format"`
+	ContentType     string          `json:"content_type"`
+	CreatedAt       time.Time       `json:"created_at"`
+}
+
+type VersionDiffResponse struct {
+	FromVersionID    uint              `json:"from_version_id"`
+	ToVersionID      uint              `json:"to_version_id"`
+	CommonAncestorID uint              `json:"common_ancestor_id"`
+	Removed          []json.RawMessage `json:"removed"`
+	Added            []json.RawMessage `json:"added"`
 }
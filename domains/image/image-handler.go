@@ -1,35 +1,62 @@
 package image
 
 import (
+	"context"
 	"errors"
 	"strconv"
+	"vixel/domains/user"
 	"vixel/shared/middlewares"
 	"vixel/shared/responses"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type ImageServiceInterface interface {
-	SaveImage(image *Image) (*Image, error)
+	SaveUpload(image *Image, store func() error) (*Image, error)
+	FindImageByHash(userID uint, hash string) (*Image, error)
+	GetStripMetadata(userID uint) (string, error)
+	FindSimilarImages(source *Image, userID uint, maxDistance, limit int) ([]SimilarImage, error)
 	GetImageByID(id uint) (*Image, error)
 	ListImagesByUser(userID uint) ([]Image, error)
-	DeleteImage(id uint) error
+	ListPublicImagesByUser(userID uint) ([]Image, error)
+	SetVisibility(id uint, visibility string) (*Image, error)
+	ListVersions(imageID uint) ([]ImageVersion, error)
+	RestoreVersion(imageID, versionID uint) (*Image, error)
+	DiffVersions(imageID, fromID, toID uint) (*VersionDiffResponse, error)
+	GetUsage(userID uint) (*UsageResponse, error)
+	CheckQuota(userID uint, bytes, objects int64) error
+	SetQuota(userID uint, dto SetQuotaDto) (*UsageResponse, error)
+}
+
+type ImageDeleter interface {
+	DeleteImage(ctx context.Context, imageID uint) error
 }
 
 type ImageHandler struct {
 	imageService  ImageServiceInterface
 	uploadService UploadServiceInterface
+	deleter       ImageDeleter
+	urls          *URLResolver
 }
 
-func NewImageHandler(service ImageServiceInterface, uploadService UploadServiceInterface) *ImageHandler {
-	return &ImageHandler{imageService: service, uploadService: uploadService}
+func NewImageHandler(service ImageServiceInterface, uploadService UploadServiceInterface, deleter ImageDeleter, urls *URLResolver) *ImageHandler {
+	return &ImageHandler{imageService: service, uploadService: uploadService, deleter: deleter, urls: urls}
 }
 
 func (h *ImageHandler) SetupImageRoutes(rg *gin.RouterGroup) {
-	rg.POST("/images", middlewares.JWTMiddleware(), h.UploadImage())
-	rg.GET("/images/:id", middlewares.JWTMiddleware(), h.GetImage())
-	rg.GET("/users/:user_id/images", middlewares.JWTMiddleware(), h.ListUserImages())
+	rg.POST("/images", middlewares.JWTMiddleware(user.ScopeImagesUpload), middlewares.RequireVerifiedEmail(), h.UploadImage())
+	rg.GET("/images/:id", middlewares.JWTMiddleware(user.ScopeImagesRead), h.GetImage())
+	rg.GET("/images/:id/metadata", middlewares.JWTMiddleware(user.ScopeImagesRead), h.GetMetadata())
+	rg.GET("/images/:id/similar", middlewares.JWTMiddleware(user.ScopeImagesRead), h.FindSimilarImages())
+	rg.GET("/images/:id/content", middlewares.JWTMiddleware(user.ScopeImagesRead), h.DownloadImage())
+	rg.GET("/users/me/usage", middlewares.JWTMiddleware(), h.GetUsage())
+	rg.GET("/users/:user_id/images", middlewares.JWTMiddleware(user.ScopeImagesRead), h.ListUserImages())
+	rg.PUT("/images/:id/visibility", middlewares.JWTMiddleware(), h.UpdateVisibility())
 	rg.DELETE("/images/:id", middlewares.JWTMiddleware(), h.DeleteImage())
+	rg.GET("/images/:id/versions", middlewares.JWTMiddleware(user.ScopeImagesRead), h.ListVersions())
+	rg.POST("/images/:id/versions/:vid/restore", middlewares.JWTMiddleware(user.ScopeImagesTransform), h.RestoreVersion())
+	rg.GET("/images/:id/versions/:vid/diff", middlewares.JWTMiddleware(user.ScopeImagesRead), h.DiffVersions())
 }
 
 func (h *ImageHandler) UploadImage() gin.HandlerFunc {
@@ -40,33 +67,100 @@ func (h *ImageHandler) UploadImage() gin.HandlerFunc {
 			return
 		}
 
-		if errMsg := dto.IsValid(); errMsg != "" {
-			responses.BadRequest(ctx, errors.New(errMsg))
+		if err := dto.IsValid(); err != nil {
+			if IsLimitError(err) {
+				responses.PayloadTooLarge(ctx, err)
+				return
+			}
+			responses.BadRequest(ctx, err)
 			return
 		}
 
-		imageURL, err := h.uploadService.UploadImage(ctx, dto.File)
+		userID := ctx.Value("user_id").(uint)
+		file, err := spoolUpload(dto.File)
 		if err != nil {
 			responses.InternalServerError(ctx, err)
 			return
 		}
+		defer func() { file.Close() }()
+
+		mode := dto.StripMetadata
+		if mode == "" {
+			if mode, err = h.imageService.GetStripMetadata(userID); err != nil {
+				responses.InternalServerError(ctx, err)
+				return
+			}
+		}
+		file, stripped, err := stripSpooled(file, mode)
+		if err != nil {
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		if !dto.AllowDuplicate {
+			existing, err := h.imageService.FindImageByHash(userID, file.hash)
+			if err == nil {
+				response, err := existing.ToResponse(ctx, h.urls)
+				if err != nil {
+					responses.InternalServerError(ctx, err)
+					return
+				}
+				responses.Ok(ctx, response)
+				return
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				responses.InternalServerError(ctx, err)
+				return
+			}
+		}
+
+		metadata, err := ReadMetadata(file.file, file.size)
+		if err != nil {
+			responses.BadRequest(ctx, errors.New("unsupported image format"))
+			return
+		}
+
+		if err := h.imageService.CheckQuota(userID, file.size, 1); err != nil {
+			if errors.Is(err, ErrQuotaExceeded) {
+				responses.Forbidden(ctx, err)
+				return
+			}
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		visibility := dto.Visibility
+		if visibility == "" {
+			visibility = VisibilityPrivate
+		}
 
 		image := &Image{
-			UserID:  ctx.Value("user_id").(uint),
-			URL:     imageURL,
-			AltText: dto.AltText,
+			UserID:      userID,
+			Bucket:      StorageBucket(),
+			StorageKey:  ContentKey(file.hash, metadata.Format),
+			ContentHash: file.hash,
+			AltText:     dto.AltText,
+			Visibility:  visibility,
+		}
+		image.ApplyMetadata(metadata)
+		image.MetadataStripped = NewStrippedMetadata(mode, stripped)
+		savedImage, err := h.imageService.SaveUpload(image, func() error {
+			_, err := h.uploadService.Upload(ctx, file.reader(), file.size, file.hash, metadata.Format)
+			return err
+		})
+		if errors.Is(err, ErrQuotaExceeded) {
+			responses.Forbidden(ctx, err)
+			return
 		}
-		savedImage, err := h.imageService.SaveImage(image)
 		if err != nil {
 			responses.InternalServerError(ctx, err)
 			return
 		}
 
-		response := ImageResponse{
-			ID:      savedImage.ID,
-			URL:     savedImage.URL,
-			AltText: savedImage.AltText,
-			UserID:  savedImage.UserID,
+		response, err := savedImage.ToResponse(ctx, h.urls)
+		if err != nil {
+			responses.InternalServerError(ctx, err)
+			return
 		}
 
 		responses.Created(ctx, response)
@@ -75,33 +169,41 @@ func (h *ImageHandler) UploadImage() gin.HandlerFunc {
 
 func (h *ImageHandler) GetImage() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		idStr := ctx.Param("id")
-		id, err := strconv.ParseUint(idStr, 10, 32)
-		if err != nil {
-			responses.BadRequest(ctx, errors.New("invalid image id"))
+		image, ok := h.findImage(ctx, CanView)
+		if !ok {
 			return
 		}
 
-		image, err := h.imageService.GetImageByID(uint(id))
+		response, err := image.ToResponse(ctx, h.urls)
 		if err != nil {
-			responses.NotFound(ctx, errors.New("image not found"))
+			responses.InternalServerError(ctx, err)
 			return
 		}
 
-		userID := ctx.Value("user_id").(uint)
-		if image.UserID != userID {
-			responses.Unauthorized(ctx, errors.New("access denied"))
+		responses.Ok(ctx, response)
+	}
+}
+
+func (h *ImageHandler) DownloadImage() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		image, ok := h.findImage(ctx, CanView)
+		if !ok {
 			return
 		}
 
-		response := ImageResponse{
-			ID:      image.ID,
-			URL:     image.URL,
-			AltText: image.AltText,
-			UserID:  image.UserID,
+		object, info, err := h.uploadService.OpenCurrentImage(ctx, image)
+		if err != nil {
+			if errors.Is(err, ErrObjectNotFound) {
+				responses.NotFound(ctx, errors.New("image content not found"))
+				return
+			}
+			responses.InternalServerError(ctx, err)
+			return
 		}
+		defer object.Close()
 
-		responses.Ok(ctx, response)
+		ctx.Header("Cache-Control", "private, max-age=300")
+		ServeObject(ctx, object, info)
 	}
 }
 
@@ -114,7 +216,12 @@ func (h *ImageHandler) ListUserImages() gin.HandlerFunc {
 			return
 		}
 
-		images, err := h.imageService.ListImagesByUser(uint(userID))
+		var images []Image
+		if CanListAll(ActorFromContext(ctx), uint(userID)) {
+			images, err = h.imageService.ListImagesByUser(uint(userID))
+		} else {
+			images, err = h.imageService.ListPublicImagesByUser(uint(userID))
+		}
 		if err != nil {
 			responses.InternalServerError(ctx, err)
 			return
@@ -122,45 +229,251 @@ func (h *ImageHandler) ListUserImages() gin.HandlerFunc {
 
 		var imageResponses []ImageResponse
 		for _, img := range images {
-			imageResponses = append(imageResponses, ImageResponse{
-				ID:      img.ID,
-				URL:     img.URL,
-				AltText: img.AltText,
-				UserID:  img.UserID,
-			})
+			response, err := img.ToResponse(ctx, h.urls)
+			if err != nil {
+				responses.InternalServerError(ctx, err)
+				return
+			}
+			imageResponses = append(imageResponses, response)
 		}
 
 		responses.Ok(ctx, imageResponses)
 	}
 }
 
+func (h *ImageHandler) UpdateVisibility() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto UpdateVisibilityDto
+		if err := ctx.ShouldBindJSON(&dto); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		image, ok := h.findImage(ctx, CanEdit)
+		if !ok {
+			return
+		}
+
+		updated, err := h.imageService.SetVisibility(image.ID, dto.Visibility)
+		if err != nil {
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		response, err := updated.ToResponse(ctx, h.urls)
+		if err != nil {
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, response)
+	}
+}
+
 func (h *ImageHandler) DeleteImage() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		idStr := ctx.Param("id")
-		id, err := strconv.ParseUint(idStr, 10, 32)
+		image, ok := h.findImage(ctx, CanDelete)
+		if !ok {
+			return
+		}
+
+		if err := h.deleter.DeleteImage(ctx, image.ID); err != nil {
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, gin.H{"message": "image deleted"})
+	}
+}
+
+func (h *ImageHandler) ListVersions() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		image, ok := h.findImage(ctx, CanEdit)
+		if !ok {
+			return
+		}
+
+		versions, err := h.imageService.ListVersions(image.ID)
+		if err != nil {
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		versionResponses := []VersionResponse{}
+		for _, v := range versions {
+			response, err := v.ToResponse(ctx, h.urls, image.Visibility)
+			if err != nil {
+				responses.InternalServerError(ctx, err)
+				return
+			}
+			versionResponses = append(versionResponses, response)
+		}
+
+		responses.Ok(ctx, versionResponses)
+	}
+}
+
+func (h *ImageHandler) RestoreVersion() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		image, ok := h.findImage(ctx, CanEdit)
+		if !ok {
+			return
+		}
+
+		versionID, err := strconv.ParseUint(ctx.Param("vid"), 10, 32)
 		if err != nil {
-			responses.BadRequest(ctx, errors.New("invalid image id"))
+			responses.BadRequest(ctx, errors.New("invalid version id"))
 			return
 		}
 
-		image, err := h.imageService.GetImageByID(uint(id))
+		restored, err := h.imageService.RestoreVersion(image.ID, uint(versionID))
 		if err != nil {
-			responses.NotFound(ctx, errors.New("image not found"))
+			h.handleVersionError(ctx, err)
 			return
 		}
 
-		userID := ctx.Value("user_id").(uint)
-		if image.UserID != userID {
-			responses.Unauthorized(ctx, errors.New("access denied"))
+		response, err := restored.ToResponse(ctx, h.urls)
+		if err != nil {
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, response)
+	}
+}
+
+func (h *ImageHandler) DiffVersions() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		image, ok := h.findImage(ctx, CanEdit)
+		if !ok {
 			return
 		}
 
-		err = h.imageService.DeleteImage(uint(id))
+		toID, err := strconv.ParseUint(ctx.Param("vid"), 10, 32)
+		if err != nil {
+			responses.BadRequest(ctx, errors.New("invalid version id"))
+			return
+		}
+
+		fromID, err := strconv.ParseUint(ctx.DefaultQuery("against", "0"), 10, 32)
+		if err != nil {
+			responses.BadRequest(ctx, errors.New("invalid against version id"))
+			return
+		}
+
+		diff, err := h.imageService.DiffVersions(image.ID, uint(fromID), uint(toID))
+		if err != nil {
+			h.handleVersionError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, diff)
+	}
+}
+
+func (h *ImageHandler) findImage(ctx *gin.Context, allowed func(Actor, *Image) bool) (*Image, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		responses.BadRequest(ctx, errors.New("invalid image id"))
+		return nil, false
+	}
+
+	image, err := h.imageService.GetImageByID(uint(id))
+	if err != nil {
+		responses.NotFound(ctx, errors.New("image not found"))
+		return nil, false
+	}
+
+	if !allowed(ActorFromContext(ctx), image) {
+		responses.Forbidden(ctx, ErrForbidden)
+		return nil, false
+	}
+
+	return image, true
+}
+
+func (h *ImageHandler) handleVersionError(ctx *gin.Context, err error) {
+	if errors.Is(err, ErrVersionNotFound) {
+		responses.NotFound(ctx, err)
+		return
+	}
+	responses.InternalServerError(ctx, err)
+}
+
+func (h *ImageHandler) GetUsage() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		usage, err := h.imageService.GetUsage(ctx.Value("user_id").(uint))
 		if err != nil {
 			responses.InternalServerError(ctx, err)
 			return
 		}
 
-		responses.Ok(ctx, gin.H{"message": "image deleted"})
+		responses.Ok(ctx, usage)
+	}
+}
+
+func (h *ImageHandler) FindSimilarImages() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var query SimilarImagesQuery
+		if err := ctx.ShouldBindQuery(&query); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		source, ok := h.findImage(ctx, CanView)
+		if !ok {
+			return
+		}
+
+		actor := ActorFromContext(ctx)
+		userID := actor.UserID
+		if query.All {
+			if !actor.IsAdmin() {
+				responses.Forbidden(ctx, ErrForbidden)
+				return
+			}
+			userID = 0
+		}
+		if source.PerceptualHash == nil {
+			responses.UnprocessableEntity(ctx, errors.New("image has no perceptual hash"))
+			return
+		}
+
+		maxDistance, limit := 10, 20
+		if query.MaxDistance != nil {
+			maxDistance = *query.MaxDistance
+		}
+		if query.Limit != nil {
+			limit = *query.Limit
+		}
+
+		similar, err := h.imageService.FindSimilarImages(source, userID, maxDistance, limit)
+		if err != nil {
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		similarResponses := make([]SimilarImageResponse, 0, len(similar))
+		for _, match := range similar {
+			response, err := match.Image.ToResponse(ctx, h.urls)
+			if err != nil {
+				responses.InternalServerError(ctx, err)
+				return
+			}
+			similarResponses = append(similarResponses, SimilarImageResponse{ImageResponse: response, Distance: match.Distance})
+		}
+
+		responses.Ok(ctx, similarResponses)
+	}
+}
+
+func (h *ImageHandler) GetMetadata() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		image, ok := h.findImage(ctx, CanView)
+		if !ok {
+			return
+		}
+
+		responses.Ok(ctx, image.ToMetadataResponse(ActorFromContext(ctx)))
 	}
 }
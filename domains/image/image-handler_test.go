@@ -6,13 +6,22 @@ import (
 	"encoding/json"
 	"image"
 	"image/color"
+	"image/gif"
 	"image/jpeg"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+	"vixel/domains/user"
+	"vixel/shared/webp"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
 	"gorm.io/gorm"
 )
 
@@ -29,8 +38,11 @@ func createTestImageData() []byte {
 }
 
 type mockImageService struct {
-	images map[uint]*Image
-	nextID uint
+	images        map[uint]*Image
+	versions      []ImageVersion
+	nextID        uint
+	quotaErr      error
+	stripMetadata string
 }
 
 func newMockImageService() *mockImageService {
@@ -47,6 +59,26 @@ func (m *mockImageService) SaveImage(image *Image) (*Image, error) {
 	return image, nil
 }
 
+func (m *mockImageService) SaveUpload(image *Image, store func() error) (*Image, error) {
+	if err := store(); err != nil {
+		return nil, err
+	}
+	return m.SaveImage(image)
+}
+
+func (m *mockImageService) FindImageByHash(userID uint, hash string) (*Image, error) {
+	for _, img := range m.images {
+		if img.UserID == userID && img.ContentHash == hash {
+			return img, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (m *mockImageService) FindSimilarImages(source *Image, userID uint, maxDistance, limit int) ([]SimilarImage, error) {
+	return []SimilarImage{}, nil
+}
+
 func (m *mockImageService) GetImageByID(id uint) (*Image, error) {
 	if img, ok := m.images[id]; ok {
 		return img, nil
@@ -64,35 +96,131 @@ func (m *mockImageService) ListImagesByUser(userID uint) ([]Image, error) {
 	return images, nil
 }
 
-func (m *mockImageService) DeleteImage(id uint) error {
-	if _, ok := m.images[id]; ok {
-		delete(m.images, id)
-		return nil
+func (m *mockImageService) ListPublicImagesByUser(userID uint) ([]Image, error) {
+	var images []Image
+	for _, img := range m.images {
+		if img.UserID == userID && img.Visibility == VisibilityPublic {
+			images = append(images, *img)
+		}
+	}
+	return images, nil
+}
+
+func (m *mockImageService) SetVisibility(id uint, visibility string) (*Image, error) {
+	img, ok := m.images[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
 	}
-	return gorm.ErrRecordNotFound
+	img.Visibility = visibility
+	return img, nil
+}
+
+type recordingDeleter struct {
+	deleted []uint
+}
+
+func (d *recordingDeleter) DeleteImage(ctx context.Context, imageID uint) error {
+	d.deleted = append(d.deleted, imageID)
+	return nil
+}
+
+func (m *mockImageService) ListVersions(imageID uint) ([]ImageVersion, error) {
+	var versions []ImageVersion
+	for _, v := range m.versions {
+		if v.ImageID == imageID {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+func (m *mockImageService) RestoreVersion(imageID, versionID uint) (*Image, error) {
+	img, ok := m.images[imageID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if versionID == 0 {
+		img.CurrentVersionID, img.CurrentVersion = nil, nil
+		return img, nil
+	}
+	for _, v := range m.versions {
+		if v.ID == versionID && v.ImageID == imageID {
+			img.CurrentVersionID, img.CurrentVersion = &v.ID, &v
+			return img, nil
+		}
+	}
+	return nil, ErrVersionNotFound
+}
+
+func (m *mockImageService) DiffVersions(imageID, fromID, toID uint) (*VersionDiffResponse, error) {
+	return &VersionDiffResponse{FromVersionID: fromID, ToVersionID: toID}, nil
+}
+
+type presignStubStorage struct {
+	*MemoryStorage
+}
+
+func (s presignStubStorage) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return "signed:" + key, nil
+}
+
+func newTestURLResolver() *URLResolver {
+	return NewURLResolver(presignStubStorage{NewMemoryStorage()})
+}
+
+func (m *mockImageService) GetUsage(userID uint) (*UsageResponse, error) {
+	return &UsageResponse{}, nil
+}
+
+func (m *mockImageService) CheckQuota(userID uint, bytes, objects int64) error {
+	return m.quotaErr
+}
+
+func (m *mockImageService) GetStripMetadata(userID uint) (string, error) {
+	if m.stripMetadata == "" {
+		return user.StripMetadataNone, nil
+	}
+	return m.stripMetadata, nil
+}
+
+func (m *mockImageService) SetQuota(userID uint, dto SetQuotaDto) (*UsageResponse, error) {
+	return &UsageResponse{}, nil
 }
 
 type mockUploadService struct {
-	uploadedFiles map[string]string
+	objects map[string][]byte
 }
 
 func newMockUploadService() *mockUploadService {
 	return &mockUploadService{
-		uploadedFiles: make(map[string]string),
+		objects: make(map[string][]byte),
+	}
+}
+
+func (m *mockUploadService) OpenCurrentImage(ctx context.Context, image *Image) (io.ReadSeekCloser, *ObjectInfo, error) {
+	data, ok := m.objects[image.StorageKey]
+	if !ok {
+		return nil, nil, ErrObjectNotFound
 	}
+	info := &ObjectInfo{Key: image.StorageKey, Size: int64(len(data)), ContentType: "image/jpeg", ETag: "abc"}
+	return nopSeekCloser{bytes.NewReader(data)}, info, nil
 }
 
-func (m *mockUploadService) UploadImage(ctx context.Context, file *multipart.FileHeader) (string, error) {
-	url := "http://mock.com/uploads/" + file.Filename
-	m.uploadedFiles[file.Filename] = url
-	return url, nil
+func (m *mockUploadService) Upload(ctx context.Context, r io.Reader, size int64, hash, format string) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	key := ContentKey(hash, format)
+	m.objects[key] = data
+	return key, nil
 }
 
 func TestImageHandler_UploadImage(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	mockImgService := newMockImageService()
 	mockUploadService := newMockUploadService()
-	handler := NewImageHandler(mockImgService, mockUploadService)
+	handler := NewImageHandler(mockImgService, mockUploadService, &recordingDeleter{}, newTestURLResolver())
 
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
@@ -134,19 +262,301 @@ func TestImageHandler_UploadImage(t *testing.T) {
 	if !ok || url == "" {
 		t.Error("URL should not be empty")
 	}
+
+	if data["width"] != float64(10) || data["height"] != float64(10) || data["format"] != "jpeg" || data["content_type"] != "image/jpeg" {
+		t.Errorf("Expected image metadata in the response, got %v", data)
+	}
+}
+
+func TestImageHandler_UploadImage_WebP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockUploadService := newMockUploadService()
+	handler := NewImageHandler(newMockImageService(), mockUploadService, &recordingDeleter{}, newTestURLResolver())
+
+	img, _ := jpeg.Decode(bytes.NewReader(createTestImageData()))
+	var file bytes.Buffer
+	if err := webp.Encode(&file, img, &webp.Options{Lossless: true}); err != nil {
+		t.Fatalf("Failed to encode webp: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	fileWriter, _ := writer.CreateFormFile("file", "test.webp")
+	fileWriter.Write(file.Bytes())
+	writer.Close()
+
+	c.Request = httptest.NewRequest("POST", "/images", body)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	c.Set("user_id", uint(1))
+
+	handler.UploadImage()(c)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"content_type":"image/webp"`) {
+		t.Errorf("Expected webp content type, got %s", w.Body.String())
+	}
+	if _, ok := mockUploadService.objects[ContentKey(HashBytes(file.Bytes()), "webp")]; !ok {
+		t.Error("Expected the object to be stored with a .webp key")
+	}
+}
+
+func TestImageHandler_UploadImage_WebPStripsGPS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockImgService := newMockImageService()
+	mockUploadService := newMockUploadService()
+	handler := NewImageHandler(mockImgService, mockUploadService, &recordingDeleter{}, newTestURLResolver())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	fileWriter, _ := writer.CreateFormFile("file", "phone.webp")
+	fileWriter.Write(webpWithExif(t, 8, 4, testExifTIFF()))
+	writer.WriteField("strip_metadata", user.StripMetadataGPS)
+	writer.Close()
+
+	c.Request = httptest.NewRequest("POST", "/images", body)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	c.Set("user_id", uint(1))
+	handler.UploadImage()(c)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	img := mockImgService.images[1]
+	if img.MetadataStripped == nil || !slices.Equal(img.MetadataStripped.Fields, []string{"exif.gps"}) {
+		t.Errorf("Expected GPS to be stripped, got %+v", img.MetadataStripped)
+	}
+	if img.Exif == nil || img.Exif.GPS != nil || img.Exif.Make != "Vixel" {
+		t.Errorf("Expected the remaining exif to be recorded without GPS, got %+v", img.Exif)
+	}
+	if exif, _ := ParseExif(mockUploadService.objects[img.StorageKey]); exif == nil || exif.GPS != nil {
+		t.Error("Stored object should not contain GPS data")
+	}
+}
+
+func TestImageHandler_UploadImage_OtherFormats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	img, _ := jpeg.Decode(bytes.NewReader(createTestImageData()))
+	encoders := map[string]func(io.Writer, image.Image) error{
+		"gif":  func(w io.Writer, m image.Image) error { return gif.Encode(w, m, nil) },
+		"bmp":  bmp.Encode,
+		"tiff": func(w io.Writer, m image.Image) error { return tiff.Encode(w, m, nil) },
+	}
+
+	for format, encode := range encoders {
+		var file bytes.Buffer
+		if err := encode(&file, img); err != nil {
+			t.Fatalf("Failed to encode %s: %v", format, err)
+		}
+
+		handler := NewImageHandler(newMockImageService(), newMockUploadService(), &recordingDeleter{}, newTestURLResolver())
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		fileWriter, _ := writer.CreateFormFile("file", "test."+format)
+		fileWriter.Write(file.Bytes())
+		writer.Close()
+
+		c.Request = httptest.NewRequest("POST", "/images", body)
+		c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+		c.Set("user_id", uint(1))
+
+		handler.UploadImage()(c)
+
+		if w.Code != http.StatusCreated {
+			t.Errorf("Expected status 201 for %s, got %d: %s", format, w.Code, w.Body.String())
+			continue
+		}
+		if !strings.Contains(w.Body.String(), `"format":"`+format+`"`) {
+			t.Errorf("Expected format %s, got %s", format, w.Body.String())
+		}
+	}
+}
+
+func TestImageHandler_UploadImage_ReturnsExistingDuplicate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockImgService := newMockImageService()
+	handler := NewImageHandler(mockImgService, newMockUploadService(), &recordingDeleter{}, newTestURLResolver())
+
+	upload := func(allowDuplicate bool) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		fileWriter, _ := writer.CreateFormFile("file", "test.jpg")
+		fileWriter.Write(createTestImageData())
+		if allowDuplicate {
+			writer.WriteField("allow_duplicate", "true")
+		}
+		writer.Close()
+
+		c.Request = httptest.NewRequest("POST", "/images", body)
+		c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+		c.Set("user_id", uint(1))
+		handler.UploadImage()(c)
+		return w
+	}
+
+	if w := upload(false); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", w.Code)
+	}
+	if w := upload(false); w.Code != http.StatusOK {
+		t.Errorf("Expected the existing image with status 200, got %d", w.Code)
+	}
+	if len(mockImgService.images) != 1 {
+		t.Errorf("Expected a single image, got %d", len(mockImgService.images))
+	}
+
+	if w := upload(true); w.Code != http.StatusCreated {
+		t.Errorf("Expected a new image when duplicates are allowed, got %d", w.Code)
+	}
+}
+
+func TestImageHandler_UploadImage_QuotaExceeded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockImgService := newMockImageService()
+	mockImgService.quotaErr = ErrQuotaExceeded
+	handler := NewImageHandler(mockImgService, newMockUploadService(), &recordingDeleter{}, newTestURLResolver())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	fileWriter, _ := writer.CreateFormFile("file", "test.jpg")
+	fileWriter.Write(createTestImageData())
+	writer.Close()
+
+	c.Request = httptest.NewRequest("POST", "/images", body)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	c.Set("user_id", uint(1))
+
+	handler.UploadImage()(c)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+	if len(mockImgService.images) != 0 {
+		t.Error("No image should be saved over quota")
+	}
+}
+
+func TestImageHandler_UploadImage_StripsMetadata(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockImgService := newMockImageService()
+	mockImgService.stripMetadata = user.StripMetadataGPS
+	mockUploadService := newMockUploadService()
+	handler := NewImageHandler(mockImgService, mockUploadService, &recordingDeleter{}, newTestURLResolver())
+
+	upload := func(mode string) *Image {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		fileWriter, _ := writer.CreateFormFile("file", "phone.jpg")
+		fileWriter.Write(jpegWithExif(t, 8, 4, testExifTIFF()))
+		writer.WriteField("allow_duplicate", "true")
+		if mode != "" {
+			writer.WriteField("strip_metadata", mode)
+		}
+		writer.Close()
+
+		c.Request = httptest.NewRequest("POST", "/images", body)
+		c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+		c.Set("user_id", uint(1))
+		handler.UploadImage()(c)
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+		}
+		return mockImgService.images[mockImgService.nextID-1]
+	}
+
+	img := upload("")
+	if img.MetadataStripped == nil || img.MetadataStripped.Mode != user.StripMetadataGPS || img.Exif.GPS != nil {
+		t.Errorf("Expected the account default to strip GPS, got %+v", img.MetadataStripped)
+	}
+	if exif, _ := ParseExif(mockUploadService.objects[img.StorageKey]); exif == nil || exif.GPS != nil {
+		t.Error("Stored object should not contain GPS data")
+	}
+	if img.ContentHash != HashBytes(mockUploadService.objects[img.StorageKey]) {
+		t.Error("Content hash should describe the stored bytes")
+	}
+
+	if img := upload(user.StripMetadataNone); img.MetadataStripped != nil || img.Exif.GPS == nil {
+		t.Error("The upload option should override the account default")
+	}
+}
+
+func TestImageHandler_UploadImage_OverPixelBudget(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	withLimits(t, 0, 50, 0)
+	handler := NewImageHandler(newMockImageService(), newMockUploadService(), &recordingDeleter{}, newTestURLResolver())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	fileWriter, _ := writer.CreateFormFile("file", "bomb.jpg")
+	fileWriter.Write(createTestImageData())
+	writer.Close()
+
+	c.Request = httptest.NewRequest("POST", "/images", body)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	c.Set("user_id", uint(1))
+
+	handler.UploadImage()(c)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413, got %d", w.Code)
+	}
+}
+
+func TestImageHandler_UploadImage_AnimationOverPixelBudget(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	withLimits(t, 0, 1000, 0)
+	handler := NewImageHandler(newMockImageService(), newMockUploadService(), &recordingDeleter{}, newTestURLResolver())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	fileWriter, _ := writer.CreateFormFile("file", "bomb.gif")
+	fileWriter.Write(animatedGIF(t, 10, 10, 11))
+	writer.Close()
+
+	c.Request = httptest.NewRequest("POST", "/images", body)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	c.Set("user_id", uint(1))
+
+	handler.UploadImage()(c)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413, got %d: %s", w.Code, w.Body.String())
+	}
 }
 
 func TestImageHandler_GetImage(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	mockImgService := newMockImageService()
 	mockUploadService := newMockUploadService()
-	handler := NewImageHandler(mockImgService, mockUploadService)
+	handler := NewImageHandler(mockImgService, mockUploadService, &recordingDeleter{}, newTestURLResolver())
 
 	// Pre-create image
 	img := &Image{
-		URL:     "http://example.com/image.jpg",
-		AltText: "Test",
-		UserID:  1,
+		StorageKey: "image.jpg",
+		AltText:    "Test",
+		UserID:     1,
 	}
 	mockImgService.SaveImage(img)
 
@@ -182,13 +592,13 @@ func TestImageHandler_GetImage_Unauthorized(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	mockImgService := newMockImageService()
 	mockUploadService := newMockUploadService()
-	handler := NewImageHandler(mockImgService, mockUploadService)
+	handler := NewImageHandler(mockImgService, mockUploadService, &recordingDeleter{}, newTestURLResolver())
 
 	// Pre-create image for different user
 	img := &Image{
-		URL:     "http://example.com/image.jpg",
-		AltText: "Test",
-		UserID:  2,
+		StorageKey: "image.jpg",
+		AltText:    "Test",
+		UserID:     2,
 	}
 	mockImgService.SaveImage(img)
 
@@ -201,8 +611,84 @@ func TestImageHandler_GetImage_Unauthorized(t *testing.T) {
 
 	handler.GetImage()(c)
 
-	if w.Code != http.StatusUnauthorized {
-		t.Errorf("Expected status 401, got %d", w.Code)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+}
+
+func TestImageHandler_GetImage_SharedWithOtherUsers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockImgService := newMockImageService()
+	handler := NewImageHandler(mockImgService, newMockUploadService(), &recordingDeleter{}, newTestURLResolver())
+
+	mockImgService.SaveImage(&Image{StorageKey: "image.jpg", UserID: 2, Visibility: VisibilityUnlisted})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request = httptest.NewRequest("GET", "/images/1", nil)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	c.Set("user_id", uint(1))
+
+	handler.GetImage()(c)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+}
+
+func TestImageHandler_ListUserImages_OtherUserSeesPublicOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockImgService := newMockImageService()
+	handler := NewImageHandler(mockImgService, newMockUploadService(), &recordingDeleter{}, newTestURLResolver())
+
+	mockImgService.SaveImage(&Image{StorageKey: "key1", UserID: 2, Visibility: VisibilityPublic})
+	mockImgService.SaveImage(&Image{StorageKey: "key2", UserID: 2, Visibility: VisibilityUnlisted})
+	mockImgService.SaveImage(&Image{StorageKey: "key3", UserID: 2, Visibility: VisibilityPrivate})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request = httptest.NewRequest("GET", "/users/2/images", nil)
+	c.Params = gin.Params{{Key: "user_id", Value: "2"}}
+	c.Set("user_id", uint(1))
+
+	handler.ListUserImages()(c)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	data, _ := response["data"].([]interface{})
+	if len(data) != 1 {
+		t.Errorf("Expected 1 public image, got %d", len(data))
+	}
+}
+
+func TestImageHandler_UpdateVisibility(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockImgService := newMockImageService()
+	handler := NewImageHandler(mockImgService, newMockUploadService(), &recordingDeleter{}, newTestURLResolver())
+
+	mockImgService.SaveImage(&Image{StorageKey: "key", UserID: 1, Visibility: VisibilityPrivate})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request = httptest.NewRequest("PUT", "/images/1/visibility", bytes.NewBufferString(`{"visibility":"public"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	c.Set("user_id", uint(1))
+
+	handler.UpdateVisibility()(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	if mockImgService.images[1].Visibility != VisibilityPublic {
+		t.Errorf("Expected visibility public, got %s", mockImgService.images[1].Visibility)
 	}
 }
 
@@ -210,11 +696,11 @@ func TestImageHandler_ListUserImages(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	mockImgService := newMockImageService()
 	mockUploadService := newMockUploadService()
-	handler := NewImageHandler(mockImgService, mockUploadService)
+	handler := NewImageHandler(mockImgService, mockUploadService, &recordingDeleter{}, newTestURLResolver())
 
 	// Pre-create images
-	img1 := &Image{URL: "url1", UserID: 1}
-	img2 := &Image{URL: "url2", UserID: 1}
+	img1 := &Image{StorageKey: "key1", UserID: 1}
+	img2 := &Image{StorageKey: "key2", UserID: 1}
 	mockImgService.SaveImage(img1)
 	mockImgService.SaveImage(img2)
 
@@ -249,11 +735,10 @@ func TestImageHandler_ListUserImages(t *testing.T) {
 func TestImageHandler_DeleteImage(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	mockImgService := newMockImageService()
-	mockUploadService := newMockUploadService()
-	handler := NewImageHandler(mockImgService, mockUploadService)
+	deleter := &recordingDeleter{}
+	handler := NewImageHandler(mockImgService, newMockUploadService(), deleter, newTestURLResolver())
 
-	// Pre-create image
-	img := &Image{URL: "url", UserID: 1}
+	img := &Image{StorageKey: "key", UserID: 1}
 	mockImgService.SaveImage(img)
 
 	w := httptest.NewRecorder()
@@ -269,9 +754,131 @@ func TestImageHandler_DeleteImage(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", w.Code)
 	}
 
-	// Check deleted
-	_, err := mockImgService.GetImageByID(1)
-	if err == nil {
-		t.Error("Image should be deleted")
+	if len(deleter.deleted) != 1 || deleter.deleted[0] != 1 {
+		t.Errorf("Expected image 1 to be deleted with its objects, got %v", deleter.deleted)
+	}
+}
+
+func TestImageHandler_RestoreVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockImgService := newMockImageService()
+	handler := NewImageHandler(mockImgService, newMockUploadService(), &recordingDeleter{}, newTestURLResolver())
+
+	mockImgService.SaveImage(&Image{StorageKey: "original", UserID: 1})
+	version := ImageVersion{ImageID: 1, StorageKey: "v1"}
+	version.ID = 5
+	mockImgService.versions = append(mockImgService.versions, version)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request = httptest.NewRequest("POST", "/images/1/versions/5/restore", nil)
+	c.Params = gin.Params{{Key: "id", Value: "1"}, {Key: "vid", Value: "5"}}
+	c.Set("user_id", uint(1))
+
+	handler.RestoreVersion()(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	data := response["data"].(map[string]interface{})
+	if data["current_version_id"].(float64) != 5 || data["original_url"] != "signed:original" {
+		t.Errorf("Unexpected response: %v", data)
+	}
+}
+
+func TestImageHandler_RestoreVersion_NotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockImgService := newMockImageService()
+	handler := NewImageHandler(mockImgService, newMockUploadService(), &recordingDeleter{}, newTestURLResolver())
+
+	mockImgService.SaveImage(&Image{StorageKey: "original", UserID: 1})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request = httptest.NewRequest("POST", "/images/1/versions/9/restore", nil)
+	c.Params = gin.Params{{Key: "id", Value: "1"}, {Key: "vid", Value: "9"}}
+	c.Set("user_id", uint(1))
+
+	handler.RestoreVersion()(c)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestImageHandler_DownloadImage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockImgService := newMockImageService()
+	mockUploadService := newMockUploadService()
+	handler := NewImageHandler(mockImgService, mockUploadService, &recordingDeleter{}, newTestURLResolver())
+
+	mockImgService.SaveImage(&Image{StorageKey: "key", UserID: 1})
+	mockUploadService.objects["key"] = []byte("0123456789")
+
+	cases := []struct {
+		name    string
+		header  string
+		value   string
+		status  int
+		body    string
+		headers map[string]string
+	}{
+		{"full", "", "", http.StatusOK, "0123456789", map[string]string{"Content-Length": "10", "Content-Type": "image/jpeg", "ETag": `"abc"`}},
+		{"range", "Range", "bytes=2-5", http.StatusPartialContent, "2345", map[string]string{"Content-Range": "bytes 2-5/10"}},
+		{"not modified", "If-None-Match", `"abc"`, http.StatusNotModified, "", nil},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request = httptest.NewRequest("GET", "/images/1/content", nil)
+		if tc.header != "" {
+			c.Request.Header.Set(tc.header, tc.value)
+		}
+		c.Params = gin.Params{{Key: "id", Value: "1"}}
+		c.Set("user_id", uint(1))
+
+		handler.DownloadImage()(c)
+		c.Writer.WriteHeaderNow()
+
+		if w.Code != tc.status || w.Body.String() != tc.body {
+			t.Errorf("%s: expected %d %q, got %d %q", tc.name, tc.status, tc.body, w.Code, w.Body.String())
+		}
+		for header, expected := range tc.headers {
+			if got := w.Header().Get(header); got != expected {
+				t.Errorf("%s: expected %s %q, got %q", tc.name, header, expected, got)
+			}
+		}
+	}
+}
+
+func TestImageHandler_DownloadImage_PrivateImageOfOtherUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockImgService := newMockImageService()
+	mockUploadService := newMockUploadService()
+	handler := NewImageHandler(mockImgService, mockUploadService, &recordingDeleter{}, newTestURLResolver())
+
+	mockImgService.SaveImage(&Image{StorageKey: "key", UserID: 2, Visibility: VisibilityPrivate})
+	mockUploadService.objects["key"] = []byte("data")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request = httptest.NewRequest("GET", "/images/1/content", nil)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	c.Set("user_id", uint(1))
+
+	handler.DownloadImage()(c)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
 	}
 }
@@ -0,0 +1,56 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"vixel/config"
+)
+
+var (
+	ErrFileTooLarge        = errors.New("file exceeds the upload size limit")
+	ErrPixelBudgetExceeded = errors.New("image exceeds the pixel budget")
+	ErrDimensionTooLarge   = errors.New("image dimension exceeds the limit")
+)
+
+func CheckFileSize(size int64) error {
+	if limit := config.Config.MaxUploadBytes; limit > 0 && size > limit {
+		return fmt.Errorf("%w: %d bytes is more than %d", ErrFileTooLarge, size, limit)
+	}
+	return nil
+}
+
+func CheckDimensions(width, height int) error {
+	if limit := config.Config.MaxImageDimension; limit > 0 && (width > limit || height > limit) {
+		return fmt.Errorf("%w: %dx%d is larger than %d pixels per side", ErrDimensionTooLarge, width, height, limit)
+	}
+	if budget := config.Config.MaxImagePixels; budget > 0 && int64(width)*int64(height) > budget {
+		return fmt.Errorf("%w: %dx%d is more than %d pixels", ErrPixelBudgetExceeded, width, height, budget)
+	}
+	return nil
+}
+
+// CheckFrames applies the pixel budget to all frames of an animation together,
+// as every frame is decoded before processing.
+func CheckFrames(width, height, frames int) error {
+	if err := CheckDimensions(width, height); err != nil {
+		return err
+	}
+	if budget := config.Config.MaxImagePixels; budget > 0 && int64(width)*int64(height)*int64(frames) > budget {
+		return fmt.Errorf("%w: %d frames of %dx%d are more than %d pixels", ErrPixelBudgetExceeded, frames, width, height, budget)
+	}
+	return nil
+}
+
+func DecodeConfig(r io.Reader) (image.Config, string, error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return cfg, format, err
+	}
+	return cfg, format, CheckDimensions(cfg.Width, cfg.Height)
+}
+
+func IsLimitError(err error) bool {
+	return errors.Is(err, ErrFileTooLarge) || errors.Is(err, ErrPixelBudgetExceeded) || errors.Is(err, ErrDimensionTooLarge)
+}
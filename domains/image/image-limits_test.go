@@ -0,0 +1,90 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"testing"
+	"vixel/config"
+)
+
+func withLimits(t *testing.T, maxBytes, maxPixels int64, maxDimension int) {
+	previous := *config.Config
+	config.Config.MaxUploadBytes = maxBytes
+	config.Config.MaxImagePixels = maxPixels
+	config.Config.MaxImageDimension = maxDimension
+	t.Cleanup(func() { *config.Config = previous })
+}
+
+func TestDecodeConfig_RejectsImagesOverPixelBudget(t *testing.T) {
+	withLimits(t, 0, 100, 0)
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 20, 10)))
+
+	if _, _, err := DecodeConfig(&buf); !errors.Is(err, ErrPixelBudgetExceeded) {
+		t.Errorf("Expected ErrPixelBudgetExceeded, got %v", err)
+	}
+}
+
+func TestCheckDimensions(t *testing.T) {
+	withLimits(t, 0, 1000, 50)
+
+	cases := []struct {
+		width, height int
+		expected      error
+	}{
+		{20, 20, nil},
+		{51, 1, ErrDimensionTooLarge},
+		{40, 40, ErrPixelBudgetExceeded},
+	}
+	for _, tc := range cases {
+		if err := CheckDimensions(tc.width, tc.height); !errors.Is(err, tc.expected) {
+			t.Errorf("%dx%d: expected %v, got %v", tc.width, tc.height, tc.expected, err)
+		}
+	}
+}
+
+func TestCheckFrames(t *testing.T) {
+	withLimits(t, 0, 1000, 0)
+
+	if err := CheckFrames(10, 10, 10); err != nil {
+		t.Errorf("Expected 10 frames of 10x10 to fit, got %v", err)
+	}
+	if err := CheckFrames(10, 10, 11); !errors.Is(err, ErrPixelBudgetExceeded) {
+		t.Errorf("Expected ErrPixelBudgetExceeded, got %v", err)
+	}
+}
+
+func animatedGIF(t *testing.T, width, height, frames int) []byte {
+	g := &gif.GIF{LoopCount: 0}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9)
+		frame.Pix[0] = uint8(i)
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatalf("Failed to encode GIF: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestCheckGIFFrames(t *testing.T) {
+	withLimits(t, 0, 1000, 0)
+	data := animatedGIF(t, 10, 10, 11)
+
+	if frames, err := CountGIFFrames(bytes.NewReader(data)); err != nil || frames != 11 {
+		t.Fatalf("Expected 11 frames, got %d, %v", frames, err)
+	}
+	if err := CheckGIFFrames(bytes.NewReader(data), 10, 10); !errors.Is(err, ErrPixelBudgetExceeded) {
+		t.Errorf("Expected ErrPixelBudgetExceeded, got %v", err)
+	}
+	if _, err := CountGIFFrames(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Error("Expected an error for a GIF without a trailer")
+	}
+}
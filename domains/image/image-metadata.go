@@ -0,0 +1,121 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+var formatContentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"bmp":  "image/bmp",
+	"tiff": "image/tiff",
+	"webp": "image/webp",
+}
+
+func ContentTypeForFormat(format string) string {
+	if contentType, ok := formatContentTypes[format]; ok {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+type ColorStats struct {
+	Average    string  `json:"average"`
+	Dominant   string  `json:"dominant"`
+	Brightness float64 `json:"brightness"`
+}
+
+type Metadata struct {
+	Width          int
+	Height         int
+	Format         string
+	ContentType    string
+	Size           int64
+	Exif           *ExifData
+	Colors         *ColorStats
+	PerceptualHash uint64
+}
+
+func ExtractMetadata(data []byte) (*Metadata, error) {
+	return ReadMetadata(bytes.NewReader(data), int64(len(data)))
+}
+
+// ReadMetadata decodes the image from r and reads its EXIF data from the
+// file header, without loading the whole file.
+func ReadMetadata(r io.ReaderAt, size int64) (*Metadata, error) {
+	img, format, err := image.Decode(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	exif, exifErr := ReadExif(r, size)
+	if exifErr == nil {
+		img = Orient(img, exif.Orientation)
+	}
+
+	bounds := img.Bounds()
+	metadata := &Metadata{
+		Width:          bounds.Dx(),
+		Height:         bounds.Dy(),
+		Format:         format,
+		ContentType:    ContentTypeForFormat(format),
+		Size:           size,
+		Colors:         colorStats(img),
+		PerceptualHash: DifferenceHash(img),
+	}
+	if exifErr == nil {
+		metadata.Exif = exif
+	}
+	return metadata, nil
+}
+
+func colorStats(img image.Image) *ColorStats {
+	small := imaging.Resize(img, 64, 0, imaging.Box)
+	if small.Bounds().Dy() > 64 {
+		small = imaging.Resize(img, 0, 64, imaging.Box)
+	}
+
+	var sumR, sumG, sumB, sumLuma float64
+	buckets := make(map[uint16][4]int)
+	pixels := 0
+	for i := 0; i+3 < len(small.Pix); i += 4 {
+		r, g, b := int(small.Pix[i]), int(small.Pix[i+1]), int(small.Pix[i+2])
+		sumR += float64(r)
+		sumG += float64(g)
+		sumB += float64(b)
+		sumLuma += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+		pixels++
+
+		key := uint16(r>>4)<<8 | uint16(g>>4)<<4 | uint16(b>>4)
+		bucket := buckets[key]
+		buckets[key] = [4]int{bucket[0] + r, bucket[1] + g, bucket[2] + b, bucket[3] + 1}
+	}
+	if pixels == 0 {
+		return nil
+	}
+
+	var dominantKey uint16
+	for key, bucket := range buckets {
+		best := buckets[dominantKey]
+		if bucket[3] > best[3] || (bucket[3] == best[3] && key < dominantKey) {
+			dominantKey = key
+		}
+	}
+	dominant := buckets[dominantKey]
+
+	n := float64(pixels)
+	return &ColorStats{
+		Average:    hexColor(int(sumR/n+0.5), int(sumG/n+0.5), int(sumB/n+0.5)),
+		Dominant:   hexColor(dominant[0]/dominant[3], dominant[1]/dominant[3], dominant[2]/dominant[3]),
+		Brightness: float64(int(sumLuma/n/255*1000+0.5)) / 1000,
+	}
+}
+
+func hexColor(r, g, b int) string {
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
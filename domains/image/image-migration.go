@@ -0,0 +1,127 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+func MigrateLegacyURLs(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&Image{}, "url") {
+		return nil
+	}
+
+	var rows []struct {
+		ID  uint
+		URL string
+	}
+	if err := db.Table("images").Select("id, url").Where("storage_key IS NULL OR storage_key = ''").Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			bucket, key, err := parseLegacyURL(row.URL)
+			if err != nil {
+				return fmt.Errorf("image %d: %w", row.ID, err)
+			}
+			if err := tx.Table("images").Where("id = ?", row.ID).Updates(map[string]interface{}{
+				"bucket":      bucket,
+				"storage_key": key,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return db.Migrator().DropColumn(&Image{}, "url")
+}
+
+// BackfillImageSizes records the stored size of images created before sizes
+// were counted against the quota. Rows are handled in batches of batchSize.
+func BackfillImageSizes(ctx context.Context, db *gorm.DB, storage Storage, batchSize int) (updated, skipped int, err error) {
+	var lastID uint
+	for {
+		var images []Image
+		if err := db.Select("id", "bucket", "storage_key").
+			Where("(size = 0 OR size IS NULL) AND id > ?", lastID).
+			Order("id").Limit(batchSize).
+			Find(&images).Error; err != nil {
+			return updated, skipped, err
+		}
+		if len(images) == 0 {
+			return updated, skipped, nil
+		}
+
+		for _, img := range images {
+			lastID = img.ID
+			info, err := StorageFor(storage, img.Bucket).Stat(ctx, img.StorageKey)
+			if errors.Is(err, ErrObjectNotFound) {
+				skipped++
+				continue
+			}
+			if err != nil {
+				return updated, skipped, fmt.Errorf("image %d: %w", img.ID, err)
+			}
+			if err := db.Model(&Image{}).Where("id = ?", img.ID).Update("size", info.Size).Error; err != nil {
+				return updated, skipped, err
+			}
+			updated++
+		}
+	}
+}
+
+func BackfillImageMetadata(ctx context.Context, db *gorm.DB, storage Storage, batchSize int) (updated, skipped int, err error) {
+	var lastID uint
+	for {
+		var images []Image
+		if err := db.Select("id", "bucket", "storage_key").
+			Where("(perceptual_hash IS NULL OR format = '' OR format IS NULL) AND id > ?", lastID).
+			Order("id").Limit(batchSize).
+			Find(&images).Error; err != nil {
+			return updated, skipped, err
+		}
+		if len(images) == 0 {
+			return updated, skipped, nil
+		}
+
+		for _, img := range images {
+			lastID = img.ID
+			object, _, err := StorageFor(storage, img.Bucket).Get(ctx, img.StorageKey)
+			if errors.Is(err, ErrObjectNotFound) {
+				skipped++
+				continue
+			}
+			if err != nil {
+				return updated, skipped, fmt.Errorf("image %d: %w", img.ID, err)
+			}
+			data, err := io.ReadAll(object)
+			object.Close()
+			if err != nil {
+				return updated, skipped, fmt.Errorf("image %d: %w", img.ID, err)
+			}
+			metadata, err := ExtractMetadata(data)
+			if err != nil {
+				log.Printf("skipping image %d: %v", img.ID, err)
+				skipped++
+				continue
+			}
+
+			img.ApplyMetadata(metadata)
+			if err := db.Model(&img).
+				Select("width", "height", "format", "content_type", "size", "exif", "colors", "perceptual_hash").
+				Updates(&img).Error; err != nil {
+				return updated, skipped, err
+			}
+			updated++
+		}
+	}
+}
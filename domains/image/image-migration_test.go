@@ -0,0 +1,69 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"vixel/config"
+)
+
+func TestMigrateLegacyURLs(t *testing.T) {
+	config.Config.StorageDriver = "minio"
+	config.Config.MINIOBucketName = "images"
+	db := setupImageTestDB(t)
+
+	if err := db.Exec("ALTER TABLE `images` ADD COLUMN `url` text").Error; err != nil {
+		t.Fatalf("Failed to add legacy column: %v", err)
+	}
+	if err := db.Exec("INSERT INTO images (url, user_id) VALUES (?, 1)", "http://localhost:9000/images/vixel-1-2").Error; err != nil {
+		t.Fatalf("Failed to insert legacy row: %v", err)
+	}
+
+	if err := MigrateLegacyURLs(db); err != nil {
+		t.Fatalf("MigrateLegacyURLs failed: %v", err)
+	}
+
+	var img Image
+	if err := db.First(&img).Error; err != nil {
+		t.Fatalf("Failed to load image: %v", err)
+	}
+	if img.Bucket != "images" || img.StorageKey != "vixel-1-2" {
+		t.Errorf("Expected images/vixel-1-2, got %s/%s", img.Bucket, img.StorageKey)
+	}
+	if db.Migrator().HasColumn(&Image{}, "url") {
+		t.Error("Legacy url column should be dropped")
+	}
+}
+
+func TestMigrateLegacyURLs_NoLegacyColumn(t *testing.T) {
+	db := setupImageTestDB(t)
+
+	if err := MigrateLegacyURLs(db); err != nil {
+		t.Errorf("Expected no-op, got %v", err)
+	}
+}
+
+func TestBackfillImageSizes(t *testing.T) {
+	db := setupImageTestDB(t)
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+	storage.Put(ctx, "stored", bytes.NewReader([]byte("12345")), 5, "image/png")
+
+	stored := &Image{StorageKey: "stored", UserID: 1}
+	db.Create(stored)
+	missing := &Image{StorageKey: "missing", UserID: 1}
+	db.Create(missing)
+
+	updated, skipped, err := BackfillImageSizes(ctx, db, storage, 1)
+	if err != nil {
+		t.Fatalf("BackfillImageSizes failed: %v", err)
+	}
+	if updated != 1 || skipped != 1 {
+		t.Errorf("Expected 1 updated and 1 skipped, got %d and %d", updated, skipped)
+	}
+
+	db.First(stored, stored.ID)
+	if stored.Size != 5 {
+		t.Errorf("Expected size 5, got %d", stored.Size)
+	}
+}
@@ -1,16 +1,157 @@
 package image
 
 import (
+	"context"
+	"encoding/json"
 	"vixel/domains/user"
 
 	"gorm.io/gorm"
 )
 
+const (
+	VisibilityPrivate  = "private"
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+)
+
+func IsValidVisibility(visibility string) bool {
+	return visibility == VisibilityPrivate || visibility == VisibilityPublic || visibility == VisibilityUnlisted
+}
+
 type Image struct {
 	gorm.Model
-	URL             string `gorm:"not null"`
-	AltText         string
-	UserID          uint      `gorm:"not null"`
-	User            user.User `gorm:"foreignKey:UserID"`
-	Transformations []string  `gorm:"type:json"`
+	Bucket           string
+	StorageKey       string
+	AltText          string
+	Size             int64
+	Width            int
+	Height           int
+	Format           string
+	ContentType      string
+	Exif             *ExifData         `gorm:"type:json;serializer:json"`
+	Colors           *ColorStats       `gorm:"type:json;serializer:json"`
+	MetadataStripped *StrippedMetadata `gorm:"type:json;serializer:json"`
+	ContentHash      string            `gorm:"index"`
+	PerceptualHash   *int64
+	Visibility       string        `gorm:"not null;default:private;index"`
+	UserID           uint          `gorm:"not null"`
+	User             user.User     `gorm:"foreignKey:UserID"`
+	Transformations  []string      `gorm:"type:json"`
+	CurrentVersionID *uint         `gorm:"index"`
+	CurrentVersion   *ImageVersion `gorm:"foreignKey:CurrentVersionID"`
+}
+
+func (i *Image) ApplyMetadata(metadata *Metadata) {
+	i.Width = metadata.Width
+	i.Height = metadata.Height
+	i.Format = metadata.Format
+	i.ContentType = metadata.ContentType
+	i.Size = metadata.Size
+	i.Exif = metadata.Exif
+	i.Colors = metadata.Colors
+	i.SetPerceptualHash(metadata.PerceptualHash)
+}
+
+func (i *Image) SetPerceptualHash(hash uint64) {
+	value := int64(hash)
+	i.PerceptualHash = &value
+}
+
+func (i Image) IsShared() bool {
+	return i.Visibility == VisibilityPublic || i.Visibility == VisibilityUnlisted
+}
+
+func (i Image) ToResponse(ctx context.Context, urls *URLResolver) (ImageResponse, error) {
+	originalURL, err := urls.Resolve(ctx, i.Bucket, i.StorageKey, i.Visibility)
+	if err != nil {
+		return ImageResponse{}, err
+	}
+
+	response := ImageResponse{
+		ID:          i.ID,
+		URL:         originalURL,
+		OriginalURL: originalURL,
+		AltText:     i.AltText,
+		Visibility:  i.Visibility,
+		UserID:      i.UserID,
+		Width:       i.Width,
+		Height:      i.Height,
+		Format:      i.Format,
+		ContentType: i.ContentType,
+		Size:        i.Size,
+	}
+	if i.CurrentVersion != nil {
+		if response.URL, err = urls.Resolve(ctx, i.CurrentVersion.Bucket, i.CurrentVersion.StorageKey, i.Visibility); err != nil {
+			return ImageResponse{}, err
+		}
+		response.CurrentVersionID = i.CurrentVersionID
+		response.Width = i.CurrentVersion.Width
+		response.Height = i.CurrentVersion.Height
+		response.Format = i.CurrentVersion.Format
+		response.ContentType = i.CurrentVersion.ContentType
+		response.Size = i.CurrentVersion.Size
+	}
+	return response, nil
+}
+
+func (i Image) ToMetadataResponse(actor Actor) MetadataResponse {
+	exif := i.Exif
+	if exif != nil && exif.GPS != nil && !actor.Owns(i.UserID) && !actor.IsAdmin() {
+		redacted := *exif
+		redacted.GPS = nil
+		exif = &redacted
+	}
+
+	return MetadataResponse{
+		ID:          i.ID,
+		Width:       i.Width,
+		Height:      i.Height,
+		Format:      i.Format,
+		ContentType: i.ContentType,
+		Size:        i.Size,
+		ContentHash: i.ContentHash,
+		Exif:        exif,
+		Colors:      i.Colors,
+		Stripped:    i.MetadataStripped,
+	}
+}
+
+type ImageVersion struct {
+	gorm.Model
+	ImageID         uint   `gorm:"not null;index"`
+	ParentVersionID *uint  `gorm:"index"`
+	Operations      string `gorm:"type:json"`
+	Bucket          string
+	StorageKey      string `gorm:"not null"`
+	Width           int
+	Height          int
+	Size            int64
+	Format          string
+	ContentType     string
+}
+
+func (v ImageVersion) ToResponse(ctx context.Context, urls *URLResolver, visibility string) (VersionResponse, error) {
+	url, err := urls.Resolve(ctx, v.Bucket, v.StorageKey, visibility)
+	if err != nil {
+		return VersionResponse{}, err
+	}
+
+	var operations json.RawMessage
+	if v.Operations != "" {
+		operations = json.RawMessage(v.Operations)
+	}
+
+	return VersionResponse{
+		ID:              v.ID,
+		ImageID:         v.ImageID,
+		ParentVersionID: v.ParentVersionID,
+		Operations:      operations,
+		URL:             url,
+		Width:           v.Width,
+		Height:          v.Height,
+		Size:            v.Size,
+		Format:          v.Format,
+		ContentType:     v.ContentType,
+		CreatedAt:       v.CreatedAt,
+	}, nil
 }
@@ -0,0 +1,44 @@
+package image
+
+import (
+	"errors"
+	"vixel/domains/user"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrForbidden = errors.New("access denied")
+
+type Actor struct {
+	UserID uint
+	Role   string
+}
+
+func ActorFromContext(ctx *gin.Context) Actor {
+	userID, _ := ctx.Value("user_id").(uint)
+	return Actor{UserID: userID, Role: ctx.GetString("role")}
+}
+
+func (a Actor) IsAdmin() bool {
+	return a.Role == user.RoleAdmin
+}
+
+func (a Actor) Owns(ownerID uint) bool {
+	return a.UserID != 0 && a.UserID == ownerID
+}
+
+func CanView(actor Actor, image *Image) bool {
+	return actor.Owns(image.UserID) || actor.IsAdmin() || image.IsShared()
+}
+
+func CanEdit(actor Actor, image *Image) bool {
+	return actor.Owns(image.UserID)
+}
+
+func CanDelete(actor Actor, image *Image) bool {
+	return actor.Owns(image.UserID) || actor.IsAdmin()
+}
+
+func CanListAll(actor Actor, ownerID uint) bool {
+	return actor.Owns(ownerID) || actor.IsAdmin()
+}
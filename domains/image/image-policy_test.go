@@ -0,0 +1,46 @@
+package image
+
+import "testing"
+
+func TestImagePolicy(t *testing.T) {
+	owner := Actor{UserID: 1}
+	other := Actor{UserID: 2}
+	admin := Actor{UserID: 3, Role: "admin"}
+	anonymous := Actor{}
+
+	private := &Image{UserID: 1, Visibility: VisibilityPrivate}
+	unlisted := &Image{UserID: 1, Visibility: VisibilityUnlisted}
+	orphan := &Image{Visibility: VisibilityPrivate}
+
+	tests := []struct {
+		name  string
+		check func(Actor, *Image) bool
+		actor Actor
+		image *Image
+		want  bool
+	}{
+		{"owner views private", CanView, owner, private, true},
+		{"other views private", CanView, other, private, false},
+		{"other views unlisted", CanView, other, unlisted, true},
+		{"admin views private", CanView, admin, private, true},
+		{"anonymous views image without owner", CanView, anonymous, orphan, false},
+		{"owner edits", CanEdit, owner, private, true},
+		{"other edits unlisted", CanEdit, other, unlisted, false},
+		{"admin edits", CanEdit, admin, private, false},
+		{"owner deletes", CanDelete, owner, private, true},
+		{"other deletes", CanDelete, other, unlisted, false},
+		{"admin deletes", CanDelete, admin, private, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.check(tt.actor, tt.image); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if CanListAll(other, 1) || !CanListAll(owner, 1) || !CanListAll(admin, 1) {
+		t.Error("Only owners and admins should list all images of a user")
+	}
+}
@@ -1,6 +1,20 @@
 package image
 
-import "gorm.io/gorm"
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"vixel/domains/user"
+
+	"gorm.io/gorm"
+)
+
+var ErrVersionNotFound = errors.New("version not found")
+
+type SimilarImage struct {
+	Image
+	Distance int
+}
 
 type ImageService struct {
 	db *gorm.DB
@@ -10,16 +24,144 @@ func NewImageService(db *gorm.DB) *ImageService {
 	return &ImageService{db: db}
 }
 
+// SaveImage records an image whose object is already in storage.
 func (s *ImageService) SaveImage(image *Image) (*Image, error) {
-	if err := s.db.Create(image).Error; err != nil {
+	return s.SaveUpload(image, nil)
+}
+
+// SaveUpload records an uploaded image. store writes its object and is only
+// called when the content is not already stored under the image's key; it
+// runs inside the transaction, so a failed upload records nothing.
+func (s *ImageService) SaveUpload(image *Image, store func() error) (*Image, error) {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ReserveQuota(tx, image.UserID, image.Size, 1); err != nil {
+			return err
+		}
+		if err := tx.Create(image).Error; err != nil {
+			return err
+		}
+		return RetainObject(tx, StoredObject{Key: image.StorageKey, ContentHash: image.ContentHash, Size: image.Size}, store)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return image, nil
 }
 
+func (s *ImageService) FindImageByHash(userID uint, hash string) (*Image, error) {
+	var image Image
+	if err := s.db.Preload("CurrentVersion").Where("user_id = ? AND content_hash = ?", userID, hash).First(&image).Error; err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+func (s *ImageService) GetStripMetadata(userID uint) (string, error) {
+	var owner user.User
+	err := s.db.Select("strip_metadata").First(&owner, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && owner.StripMetadata == "") {
+		return user.StripMetadataNone, nil
+	}
+	return owner.StripMetadata, err
+}
+
+// similarScanBatch is how many hashes are compared per query when the
+// database cannot compute Hamming distances itself.
+const similarScanBatch = 1000
+
+type similarMatch struct {
+	ID       uint
+	Distance int
+}
+
+// FindSimilarImages returns up to limit images within maxDistance of the
+// source, nearest first. On Postgres the distances are computed in the query;
+// other databases scan the hashes in batches and keep only the best matches.
+func (s *ImageService) FindSimilarImages(source *Image, userID uint, maxDistance, limit int) ([]SimilarImage, error) {
+	if source.PerceptualHash == nil {
+		return []SimilarImage{}, nil
+	}
+
+	query := s.db.Model(&Image{}).Where("perceptual_hash IS NOT NULL AND id <> ?", source.ID)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	var matches []similarMatch
+	var err error
+	if s.db.Dialector.Name() == "postgres" {
+		matches, err = nearestInQuery(query, *source.PerceptualHash, maxDistance, limit)
+	} else {
+		matches, err = nearestByScan(query, *source.PerceptualHash, maxDistance, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(matches))
+	for i, match := range matches {
+		ids[i] = match.ID
+	}
+	var images []Image
+	if err := s.db.Preload("CurrentVersion").Where("id IN ?", ids).Find(&images).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]Image, len(images))
+	for _, img := range images {
+		byID[img.ID] = img
+	}
+
+	similar := make([]SimilarImage, 0, len(matches))
+	for _, match := range matches {
+		if img, ok := byID[match.ID]; ok {
+			similar = append(similar, SimilarImage{Image: img, Distance: match.Distance})
+		}
+	}
+	return similar, nil
+}
+
+func nearestInQuery(query *gorm.DB, hash int64, maxDistance, limit int) ([]similarMatch, error) {
+	const distance = "bit_count(int8send(perceptual_hash # ?))"
+	var matches []similarMatch
+	err := query.Select("id, "+distance+" AS distance", hash).
+		Where(distance+" <= ?", hash, maxDistance).
+		Order("distance, id").Limit(limit).
+		Scan(&matches).Error
+	return matches, err
+}
+
+func nearestByScan(query *gorm.DB, hash int64, maxDistance, limit int) ([]similarMatch, error) {
+	var matches []similarMatch
+	var lastID uint
+	for {
+		var batch []Image
+		if err := query.Session(&gorm.Session{}).Select("id", "perceptual_hash").
+			Where("id > ?", lastID).Order("id").Limit(similarScanBatch).
+			Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		if len(batch) == 0 {
+			return matches, nil
+		}
+
+		for _, candidate := range batch {
+			lastID = candidate.ID
+			distance := HammingDistance(uint64(hash), uint64(*candidate.PerceptualHash))
+			if distance <= maxDistance {
+				matches = append(matches, similarMatch{ID: candidate.ID, Distance: distance})
+			}
+		}
+		sort.SliceStable(matches, func(i, j int) bool {
+			return matches[i].Distance < matches[j].Distance
+		})
+		if len(matches) > limit {
+			matches = matches[:limit]
+		}
+	}
+}
+
 func (s *ImageService) GetImageByID(id uint) (*Image, error) {
 	var image Image
-	if err := s.db.Preload("User").First(&image, id).Error; err != nil {
+	if err := s.db.Preload("User").Preload("CurrentVersion").First(&image, id).Error; err != nil {
 		return nil, err
 	}
 	return &image, nil
@@ -27,15 +169,132 @@ func (s *ImageService) GetImageByID(id uint) (*Image, error) {
 
 func (s *ImageService) ListImagesByUser(userID uint) ([]Image, error) {
 	var images []Image
-	if err := s.db.Where("user_id = ?", userID).Find(&images).Error; err != nil {
+	if err := s.db.Preload("CurrentVersion").Where("user_id = ?", userID).Find(&images).Error; err != nil {
 		return nil, err
 	}
 	return images, nil
 }
 
-func (s *ImageService) DeleteImage(id uint) error {
-	if err := s.db.Delete(&Image{}, id).Error; err != nil {
-		return err
+func (s *ImageService) ListPublicImagesByUser(userID uint) ([]Image, error) {
+	var images []Image
+	if err := s.db.Preload("CurrentVersion").Where("user_id = ? AND visibility = ?", userID, VisibilityPublic).Find(&images).Error; err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (s *ImageService) SetVisibility(id uint, visibility string) (*Image, error) {
+	if err := s.db.Model(&Image{}).Where("id = ?", id).Update("visibility", visibility).Error; err != nil {
+		return nil, err
+	}
+	return s.GetImageByID(id)
+}
+
+func (s *ImageService) GetUsage(userID uint) (*UsageResponse, error) {
+	return GetUsage(s.db, userID)
+}
+
+func (s *ImageService) CheckQuota(userID uint, bytes, objects int64) error {
+	return CheckQuota(s.db, userID, bytes, objects)
+}
+
+func (s *ImageService) SetQuota(userID uint, dto SetQuotaDto) (*UsageResponse, error) {
+	return SetQuota(s.db, userID, dto)
+}
+
+func (s *ImageService) ListVersions(imageID uint) ([]ImageVersion, error) {
+	var versions []ImageVersion
+	if err := s.db.Where("image_id = ?", imageID).Order("id").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (s *ImageService) RestoreVersion(imageID, versionID uint) (*Image, error) {
+	var currentVersionID *uint
+	if versionID != 0 {
+		var version ImageVersion
+		if err := s.db.Where("image_id = ?", imageID).First(&version, versionID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrVersionNotFound
+			}
+			return nil, err
+		}
+		currentVersionID = &version.ID
+	}
+
+	if err := s.db.Model(&Image{}).Where("id = ?", imageID).Update("current_version_id", currentVersionID).Error; err != nil {
+		return nil, err
+	}
+	return s.GetImageByID(imageID)
+}
+
+func (s *ImageService) DiffVersions(imageID, fromID, toID uint) (*VersionDiffResponse, error) {
+	versions, err := s.ListVersions(imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]ImageVersion, len(versions))
+	for _, v := range versions {
+		byID[v.ID] = v
+	}
+
+	from, err := versionChain(byID, fromID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := versionChain(byID, toID)
+	if err != nil {
+		return nil, err
+	}
+
+	common := 0
+	for common < len(from) && common < len(to) && from[common].ID == to[common].ID {
+		common++
+	}
+
+	diff := &VersionDiffResponse{FromVersionID: fromID, ToVersionID: toID}
+	if common > 0 {
+		diff.CommonAncestorID = from[common-1].ID
+	}
+	if diff.Removed, err = flattenOperations(from[common:]); err != nil {
+		return nil, err
+	}
+	if diff.Added, err = flattenOperations(to[common:]); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+func versionChain(byID map[uint]ImageVersion, versionID uint) ([]ImageVersion, error) {
+	var chain []ImageVersion
+	for id := versionID; id != 0; {
+		version, ok := byID[id]
+		if !ok {
+			return nil, ErrVersionNotFound
+		}
+		chain = append([]ImageVersion{version}, chain...)
+
+		if version.ParentVersionID == nil || *version.ParentVersionID >= id {
+			break
+		}
+		id = *version.ParentVersionID
+	}
+	return chain, nil
+}
+
+func flattenOperations(versions []ImageVersion) ([]json.RawMessage, error) {
+	operations := []json.RawMessage{}
+	for _, v := range versions {
+		if v.Operations == "" {
+			continue
+		}
+		var ops []json.RawMessage
+		if err := json.Unmarshal([]byte(v.Operations), &ops); err != nil {
+			return nil, err
+		}
+		operations = append(operations, ops...)
 	}
-	return nil
+	return operations, nil
 }
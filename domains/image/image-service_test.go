@@ -14,7 +14,7 @@ func setupImageTestDB(t *testing.T) *gorm.DB {
 	if err != nil {
 		t.Fatalf("Failed to open test DB: %v", err)
 	}
-	db.AutoMigrate(&Image{}, &user.User{})
+	db.AutoMigrate(&Image{}, &ImageVersion{}, &StoredObject{}, &RenderedVariant{}, &user.User{})
 	return db
 }
 
@@ -23,9 +23,9 @@ func TestImageService_SaveImage(t *testing.T) {
 	service := NewImageService(db)
 
 	img := &Image{
-		URL:     "http://example.com/image.jpg",
-		AltText: "Test image",
-		UserID:  1,
+		StorageKey: "image.jpg",
+		AltText:    "Test image",
+		UserID:     1,
 	}
 
 	saved, err := service.SaveImage(img)
@@ -37,8 +37,8 @@ func TestImageService_SaveImage(t *testing.T) {
 		t.Error("Image ID should be set")
 	}
 
-	if saved.URL != "http://example.com/image.jpg" {
-		t.Error("URL not saved correctly")
+	if saved.StorageKey != "image.jpg" {
+		t.Error("StorageKey not saved correctly")
 	}
 }
 
@@ -50,9 +50,9 @@ func TestImageService_GetImageByID(t *testing.T) {
 	db.Create(usr)
 
 	img := &Image{
-		URL:     "http://example.com/image.jpg",
-		AltText: "Test image",
-		UserID:  usr.ID,
+		StorageKey: "image.jpg",
+		AltText:    "Test image",
+		UserID:     usr.ID,
 	}
 	db.Create(img)
 
@@ -87,14 +87,14 @@ func TestImageService_ListImagesByUser(t *testing.T) {
 	usr := &user.User{Username: "test", Email: "test@example.com", Password: "pass"}
 	db.Create(usr)
 
-	img1 := &Image{URL: "url1", UserID: usr.ID}
-	img2 := &Image{URL: "url2", UserID: usr.ID}
+	img1 := &Image{StorageKey: "key1", UserID: usr.ID}
+	img2 := &Image{StorageKey: "key2", UserID: usr.ID}
 	db.Create(img1)
 	db.Create(img2)
 
 	usr2 := &user.User{Username: "test2", Email: "test2@example.com", Password: "pass"}
 	db.Create(usr2)
-	img3 := &Image{URL: "url3", UserID: usr2.ID}
+	img3 := &Image{StorageKey: "key3", UserID: usr2.ID}
 	db.Create(img3)
 
 	images, err := service.ListImagesByUser(usr.ID)
@@ -107,21 +107,74 @@ func TestImageService_ListImagesByUser(t *testing.T) {
 	}
 }
 
-func TestImageService_DeleteImage(t *testing.T) {
+func createVersion(db *gorm.DB, imageID uint, parent *uint, operations string) *ImageVersion {
+	version := &ImageVersion{ImageID: imageID, ParentVersionID: parent, Operations: operations, StorageKey: "key"}
+	db.Create(version)
+	return version
+}
+
+func TestImageService_RestoreVersion(t *testing.T) {
+	db := setupImageTestDB(t)
+	service := NewImageService(db)
+
+	img := &Image{StorageKey: "key", UserID: 1}
+	db.Create(img)
+	v1 := createVersion(db, img.ID, nil, `[{"op":"flip","direction":"vertical"}]`)
+
+	restored, err := service.RestoreVersion(img.ID, v1.ID)
+	if err != nil {
+		t.Fatalf("RestoreVersion failed: %v", err)
+	}
+
+	if restored.CurrentVersionID == nil || *restored.CurrentVersionID != v1.ID {
+		t.Error("Current version not restored")
+	}
+
+	restored, err = service.RestoreVersion(img.ID, 0)
+	if err != nil {
+		t.Fatalf("RestoreVersion to original failed: %v", err)
+	}
+
+	if restored.CurrentVersionID != nil {
+		t.Error("Expected original to be restored")
+	}
+
+	other := &Image{StorageKey: "other", UserID: 1}
+	db.Create(other)
+	if _, err := service.RestoreVersion(other.ID, v1.ID); err != ErrVersionNotFound {
+		t.Errorf("Expected ErrVersionNotFound for a version of another image, got %v", err)
+	}
+}
+
+func TestImageService_DiffVersions(t *testing.T) {
 	db := setupImageTestDB(t)
 	service := NewImageService(db)
 
-	img := &Image{URL: "url", UserID: 1}
+	img := &Image{StorageKey: "key", UserID: 1}
 	db.Create(img)
+	v1 := createVersion(db, img.ID, nil, `[{"op":"resize","width":10}]`)
+	v2 := createVersion(db, img.ID, &v1.ID, `[{"op":"flip","direction":"vertical"}]`)
+	v3 := createVersion(db, img.ID, &v1.ID, `[{"op":"rotate","angle":90},{"op":"format","format":"png"}]`)
+
+	diff, err := service.DiffVersions(img.ID, v2.ID, v3.ID)
+	if err != nil {
+		t.Fatalf("DiffVersions failed: %v", err)
+	}
+
+	if diff.CommonAncestorID != v1.ID {
+		t.Errorf("Expected common ancestor %d, got %d", v1.ID, diff.CommonAncestorID)
+	}
 
-	err := service.DeleteImage(img.ID)
+	if len(diff.Removed) != 1 || len(diff.Added) != 2 {
+		t.Errorf("Expected 1 removed and 2 added operations, got %d and %d", len(diff.Removed), len(diff.Added))
+	}
+
+	diff, err = service.DiffVersions(img.ID, 0, v2.ID)
 	if err != nil {
-		t.Fatalf("DeleteImage failed: %v", err)
+		t.Fatalf("DiffVersions against original failed: %v", err)
 	}
 
-	var count int64
-	db.Model(&Image{}).Where("id = ?", img.ID).Count(&count)
-	if count != 0 {
-		t.Error("Image not deleted")
+	if len(diff.Removed) != 0 || len(diff.Added) != 2 {
+		t.Errorf("Expected 0 removed and 2 added operations, got %d and %d", len(diff.Removed), len(diff.Added))
 	}
-}
\ No newline at end of file
+}
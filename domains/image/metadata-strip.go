@@ -0,0 +1,431 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"slices"
+	"time"
+	"vixel/domains/user"
+)
+
+type StrippedMetadata struct {
+	Mode       string    `json:"mode"`
+	Fields     []string  `json:"fields"`
+	StrippedAt time.Time `json:"stripped_at"`
+}
+
+func NewStrippedMetadata(mode string, fields []string) *StrippedMetadata {
+	if len(fields) == 0 {
+		return nil
+	}
+	return &StrippedMetadata{Mode: mode, Fields: fields, StrippedAt: time.Now().UTC()}
+}
+
+// StripMetadata removes GPS or all descriptive metadata from a JPEG, PNG,
+// WebP or TIFF file without re-encoding it. The orientation tag survives an "all"
+// strip so the image still displays upright.
+func StripMetadata(data []byte, mode string) ([]byte, []string) {
+	plan, fields, err := planStrip(bytes.NewReader(data), int64(len(data)), mode)
+	if err != nil || len(fields) == 0 {
+		return data, nil
+	}
+	out, err := io.ReadAll(plan.reader())
+	if err != nil {
+		return data, nil
+	}
+	return out, fields
+}
+
+// stripPlan describes a stripped file as ranges of the original and
+// replacement bytes, so a large upload can be rewritten while only its
+// metadata is held in memory.
+type stripPlan struct {
+	src   io.ReaderAt
+	parts []stripPart
+}
+
+type stripPart interface {
+	io.Reader
+	Size() int64
+}
+
+func (p *stripPlan) copy(start, end int64) {
+	if end > start {
+		p.parts = append(p.parts, io.NewSectionReader(p.src, start, end-start))
+	}
+}
+
+func (p *stripPlan) write(data []byte) {
+	p.parts = append(p.parts, bytes.NewReader(data))
+}
+
+func (p *stripPlan) size() int64 {
+	var size int64
+	for _, part := range p.parts {
+		size += part.Size()
+	}
+	return size
+}
+
+// reader streams the stripped file. A plan can be read once.
+func (p *stripPlan) reader() io.Reader {
+	readers := make([]io.Reader, len(p.parts))
+	for i, part := range p.parts {
+		readers[i] = part
+	}
+	return io.MultiReader(readers...)
+}
+
+func planStrip(r io.ReaderAt, size int64, mode string) (*stripPlan, []string, error) {
+	if mode != user.StripMetadataGPS && mode != user.StripMetadataAll {
+		return nil, nil, nil
+	}
+
+	header := make([]byte, 12)
+	n, _ := r.ReadAt(header, 0)
+	header = header[:n]
+	plan := &stripPlan{src: r}
+
+	switch {
+	case isJPEG(header):
+		return stripJPEG(plan, size, mode)
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return stripPNG(plan, size, mode)
+	case isWebP(header):
+		return stripWebP(plan, size, mode)
+	case isTIFF(header):
+		// TIFF tags can point anywhere in the file, so the GPS IFD is
+		// erased in a full copy, as ReadExif reads TIFF files whole.
+		data, err := readRange(r, 0, size)
+		if err != nil {
+			return nil, nil, err
+		}
+		if removeGPS(data) {
+			plan.write(data)
+			return plan, []string{"exif.gps"}, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// stripGPS erases the GPS IFD of the TIFF block at [start, end) and keeps
+// everything else, so the file keeps its length. after is called with the
+// patched block to write bytes that depend on it, such as a PNG checksum,
+// and returns how many source bytes they replace.
+func stripGPS(plan *stripPlan, size, start, end int64, after func(tiff []byte) int64) (*stripPlan, []string, error) {
+	tiff, err := readRange(plan.src, start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !removeGPS(tiff) {
+		return nil, nil, nil
+	}
+
+	plan.copy(0, start)
+	plan.write(tiff)
+	skip := int64(0)
+	if after != nil {
+		skip = after(tiff)
+	}
+	plan.copy(end+skip, size)
+	return plan, []string{"exif.gps"}, nil
+}
+
+func readOrientation(r io.ReaderAt, size int64) int {
+	exif, err := ReadExif(r, size)
+	if err != nil || exif.Orientation < 1 || exif.Orientation > 8 {
+		return 1
+	}
+	return exif.Orientation
+}
+
+func stripJPEG(plan *stripPlan, size int64, mode string) (*stripPlan, []string, error) {
+	segments, err := jpegSegments(plan.src, size)
+	if err != nil || len(segments) == 0 {
+		return nil, nil, err
+	}
+
+	if mode == user.StripMetadataGPS {
+		for _, segment := range segments {
+			if segment.isExif() {
+				return stripGPS(plan, size, segment.start+10, segment.end, nil)
+			}
+		}
+		return nil, nil, nil
+	}
+
+	orientation := readOrientation(plan.src, size)
+	plan.copy(0, 2)
+	var fields []string
+	exifWritten := false
+	writeOrientation := func() {
+		if orientation != 1 && !exifWritten {
+			payload := append([]byte("Exif\x00\x00"), orientationTIFF(orientation)...)
+			segment := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(payload)+2))
+			plan.write(append(segment, payload...))
+			exifWritten = true
+		}
+	}
+
+	for i, segment := range segments {
+		field := jpegMetadataField(segment)
+		if field == "" {
+			if i > 0 || segment.marker != 0xE0 {
+				writeOrientation()
+			}
+			plan.copy(segment.start, segment.end)
+			continue
+		}
+		if !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, nil, nil
+	}
+	writeOrientation()
+	plan.copy(segments[len(segments)-1].end, size)
+	return plan, fields, nil
+}
+
+func jpegMetadataField(segment jpegSegment) string {
+	switch {
+	case segment.isExif():
+		return "exif"
+	case segment.marker == 0xE1 && bytes.HasPrefix(segment.payload, []byte("http://ns.adobe.com/xap/1.0/")):
+		return "xmp"
+	case segment.marker == 0xED:
+		return "iptc"
+	case segment.marker == 0xFE:
+		return "comment"
+	case segment.marker == 0xE1 || (segment.marker >= 0xE3 && segment.marker <= 0xEC) || segment.marker == 0xEF:
+		return "app_data"
+	}
+	return ""
+}
+
+var pngMetadataFields = map[string]string{
+	"eXIf": "exif",
+	"tEXt": "text",
+	"zTXt": "text",
+	"iTXt": "text",
+	"tIME": "time",
+}
+
+func stripPNG(plan *stripPlan, size int64, mode string) (*stripPlan, []string, error) {
+	chunks, err := pngChunks(plan.src, size)
+	if err != nil || len(chunks) == 0 {
+		return nil, nil, err
+	}
+
+	if mode == user.StripMetadataGPS {
+		for _, chunk := range chunks {
+			if chunk.kind != "eXIf" {
+				continue
+			}
+			return stripGPS(plan, size, chunk.start+8, chunk.end-4, func(tiff []byte) int64 {
+				checksum := crc32.Update(crc32.ChecksumIEEE([]byte(chunk.kind)), crc32.IEEETable, tiff)
+				plan.write(binary.BigEndian.AppendUint32(nil, checksum))
+				return 4
+			})
+		}
+		return nil, nil, nil
+	}
+
+	orientation := readOrientation(plan.src, size)
+	plan.copy(0, 8)
+	var fields []string
+	for _, chunk := range chunks {
+		if field, ok := pngMetadataFields[chunk.kind]; ok {
+			if !slices.Contains(fields, field) {
+				fields = append(fields, field)
+			}
+			continue
+		}
+		plan.copy(chunk.start, chunk.end)
+		if chunk.kind == "IHDR" && orientation != 1 {
+			var out bytes.Buffer
+			writePNGChunk(&out, "eXIf", orientationTIFF(orientation))
+			plan.write(out.Bytes())
+		}
+	}
+	if len(fields) == 0 {
+		return nil, nil, nil
+	}
+	plan.copy(chunks[len(chunks)-1].end, size)
+	return plan, fields, nil
+}
+
+func writePNGChunk(out *bytes.Buffer, kind string, payload []byte) {
+	binary.Write(out, binary.BigEndian, uint32(len(payload)))
+	body := append([]byte(kind), payload...)
+	out.Write(body)
+	binary.Write(out, binary.BigEndian, crc32.ChecksumIEEE(body))
+}
+
+var webpMetadataFields = map[string]string{
+	"EXIF": "exif",
+	"XMP ": "xmp",
+}
+
+const (
+	webpXMPFlag  = 1 << 2
+	webpExifFlag = 1 << 3
+)
+
+func stripWebP(plan *stripPlan, size int64, mode string) (*stripPlan, []string, error) {
+	chunks, err := webpChunks(plan.src, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if mode == user.StripMetadataGPS {
+		for _, chunk := range chunks {
+			if chunk.kind != "EXIF" {
+				continue
+			}
+			start := chunk.start + 8
+			prefix, err := readRange(plan.src, start, start+min(6, chunk.length))
+			if err != nil {
+				return nil, nil, err
+			}
+			if string(prefix) == "Exif\x00\x00" {
+				start += 6
+			}
+			return stripGPS(plan, size, start, chunk.start+8+chunk.length, nil)
+		}
+		return nil, nil, nil
+	}
+
+	orientation := readOrientation(plan.src, size)
+	riff := &stripPlan{src: plan.src}
+	var fields []string
+	for _, chunk := range chunks {
+		if field, ok := webpMetadataFields[chunk.kind]; ok {
+			if slices.Contains(fields, field) {
+				continue
+			}
+			fields = append(fields, field)
+			// The orientation takes the place of the EXIF chunk, which the
+			// container layout puts after the image data and any frames.
+			if field == "exif" && orientation != 1 {
+				var out bytes.Buffer
+				writeWebPChunk(&out, "EXIF", orientationTIFF(orientation))
+				riff.write(out.Bytes())
+			}
+			continue
+		}
+		if chunk.kind != "VP8X" || chunk.length < 10 {
+			riff.copy(chunk.start, chunk.end)
+			continue
+		}
+
+		vp8x, err := readRange(plan.src, chunk.start, chunk.end)
+		if err != nil {
+			return nil, nil, err
+		}
+		vp8x[8] &^= webpXMPFlag
+		if orientation == 1 {
+			vp8x[8] &^= webpExifFlag
+		}
+		riff.write(vp8x)
+	}
+	if len(fields) == 0 {
+		return nil, nil, nil
+	}
+
+	plan.write(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(riff.size()+4)))
+	plan.write([]byte("WEBP"))
+	plan.parts = append(plan.parts, riff.parts...)
+	return plan, fields, nil
+}
+
+func writeWebPChunk(out *bytes.Buffer, kind string, payload []byte) {
+	out.WriteString(kind)
+	binary.Write(out, binary.LittleEndian, uint32(len(payload)))
+	out.Write(payload)
+	if len(payload)%2 == 1 {
+		out.WriteByte(0)
+	}
+}
+
+// orientationTIFF builds a little-endian TIFF header holding a single
+// Orientation entry in IFD0.
+func orientationTIFF(orientation int) []byte {
+	tiff := make([]byte, 26)
+	copy(tiff, "II*\x00")
+	binary.LittleEndian.PutUint32(tiff[4:], 8)
+	binary.LittleEndian.PutUint16(tiff[8:], 1)
+	binary.LittleEndian.PutUint16(tiff[10:], tagOrientation)
+	binary.LittleEndian.PutUint16(tiff[12:], 3)
+	binary.LittleEndian.PutUint32(tiff[14:], 1)
+	binary.LittleEndian.PutUint16(tiff[18:], uint16(orientation))
+	return tiff
+}
+
+// removeGPS blanks the GPS IFD and everything it points to, then drops the
+// GPS pointer from IFD0. The TIFF block is modified in place and keeps its
+// length, so the surrounding container needs no offset fix-ups.
+func removeGPS(tiff []byte) bool {
+	r, offset, err := newTIFFReader(tiff)
+	if err != nil {
+		return false
+	}
+	ifd0, err := r.readIFD(offset)
+	if err != nil {
+		return false
+	}
+	entry, ok := ifd0[tagGPSIFD]
+	if !ok {
+		return false
+	}
+
+	r.clearIFD(r.uint(entry))
+	r.removeEntry(offset, tagGPSIFD)
+	return true
+}
+
+func (r *tiffReader) clearIFD(offset uint32) {
+	start := int(offset)
+	if offset == 0 || start+2 > len(r.data) {
+		return
+	}
+	count := int(r.order.Uint16(r.data[start:]))
+	end := min(start+2+count*12+4, len(r.data))
+
+	for i := 0; i < count && start+2+(i+1)*12 <= len(r.data); i++ {
+		pos := start + 2 + i*12
+		size, ok := typeSizes[r.order.Uint16(r.data[pos+2:])]
+		total := size * int(r.order.Uint32(r.data[pos+4:]))
+		if !ok || total <= 4 || total > len(r.data) {
+			continue
+		}
+		valueOffset := int(r.order.Uint32(r.data[pos+8:]))
+		if valueOffset >= 0 && valueOffset+total <= len(r.data) {
+			clear(r.data[valueOffset : valueOffset+total])
+		}
+	}
+	clear(r.data[start:end])
+}
+
+func (r *tiffReader) removeEntry(offset uint32, tag uint16) {
+	start := int(offset) + 2
+	count := int(r.order.Uint16(r.data[offset:]))
+	end := start + count*12 + 4
+	if end > len(r.data) {
+		return
+	}
+
+	for i := 0; i < count; i++ {
+		pos := start + i*12
+		if r.order.Uint16(r.data[pos:]) != tag {
+			continue
+		}
+		copy(r.data[pos:], r.data[pos+12:end])
+		clear(r.data[end-12 : end])
+		r.order.PutUint16(r.data[offset:], uint16(count-1))
+		return
+	}
+}
@@ -0,0 +1,237 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"slices"
+	"testing"
+	"vixel/domains/user"
+	"vixel/shared/webp"
+)
+
+// webpWithExif builds an extended WebP file carrying EXIF and XMP chunks
+// after the image data, as cameras and editors write them.
+func webpWithExif(t *testing.T, width, height int, tiff []byte) []byte {
+	var encoded bytes.Buffer
+	if err := webp.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, width, height)), &webp.Options{Lossless: true}); err != nil {
+		t.Fatalf("Failed to encode WebP: %v", err)
+	}
+
+	header := make([]byte, 10)
+	header[0] = webpExifFlag | webpXMPFlag
+	header[4], header[5], header[6] = byte(width-1), byte((width-1)>>8), byte((width-1)>>16)
+	header[7], header[8], header[9] = byte(height-1), byte((height-1)>>8), byte((height-1)>>16)
+
+	var out bytes.Buffer
+	out.WriteString("RIFF\x00\x00\x00\x00WEBP")
+	writeWebPChunk(&out, "VP8X", header)
+	out.Write(encoded.Bytes()[12:])
+	writeWebPChunk(&out, "EXIF", tiff)
+	writeWebPChunk(&out, "XMP ", []byte("<x:xmpmeta>Jane Doe</x:xmpmeta>"))
+	data := out.Bytes()
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	return data
+}
+
+func TestStripMetadata_GPS(t *testing.T) {
+	data := jpegWithExif(t, 8, 4, testExifTIFF())
+	stripped, fields := StripMetadata(data, user.StripMetadataGPS)
+
+	if !slices.Equal(fields, []string{"exif.gps"}) {
+		t.Errorf("Expected GPS to be reported, got %v", fields)
+	}
+	if len(stripped) != len(data) {
+		t.Errorf("GPS stripping should keep the file length, got %d want %d", len(stripped), len(data))
+	}
+	latitude := binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, 2964), 100)
+	if bytes.Contains(stripped, latitude) {
+		t.Error("GPS coordinates should be erased from the file")
+	}
+
+	exif, err := ParseExif(stripped)
+	if err != nil {
+		t.Fatalf("ParseExif failed: %v", err)
+	}
+	if exif.GPS != nil || exif.Make != "Vixel" || exif.Orientation != 6 || exif.ISO != 200 {
+		t.Errorf("Expected only GPS to be removed, got %+v", exif)
+	}
+	if _, err := ExtractMetadata(stripped); err != nil {
+		t.Errorf("Stripped image should still decode: %v", err)
+	}
+}
+
+func TestStripMetadata_AllKeepsOrientation(t *testing.T) {
+	data := jpegWithExif(t, 8, 4, testExifTIFF())
+	stripped, fields := StripMetadata(data, user.StripMetadataAll)
+
+	if !slices.Equal(fields, []string{"exif"}) {
+		t.Errorf("Expected exif to be reported, got %v", fields)
+	}
+	exif, err := ParseExif(stripped)
+	if err != nil {
+		t.Fatalf("Orientation should survive: %v", err)
+	}
+	if exif.Orientation != 6 || exif.Make != "" || exif.GPS != nil || exif.CapturedAt != nil {
+		t.Errorf("Expected only the orientation to remain, got %+v", exif)
+	}
+
+	metadata, err := ExtractMetadata(stripped)
+	if err != nil {
+		t.Fatalf("Stripped image should still decode: %v", err)
+	}
+	if metadata.Width != 4 || metadata.Height != 8 {
+		t.Errorf("Expected oriented dimensions 4x8, got %dx%d", metadata.Width, metadata.Height)
+	}
+}
+
+func TestStripMetadata_PNGText(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	var chunk bytes.Buffer
+	body := []byte("tEXtAuthor\x00Jane Doe")
+	binary.Write(&chunk, binary.BigEndian, uint32(len(body)-4))
+	chunk.Write(body)
+	binary.Write(&chunk, binary.BigEndian, crc32.ChecksumIEEE(body))
+	data := slices.Concat(encoded[:33], chunk.Bytes(), encoded[33:])
+
+	stripped, fields := StripMetadata(data, user.StripMetadataAll)
+	if !slices.Equal(fields, []string{"text"}) || bytes.Contains(stripped, []byte("Jane Doe")) {
+		t.Errorf("Expected the text chunk to be removed, got %v", fields)
+	}
+	if !bytes.Equal(stripped, encoded) {
+		t.Error("Only the metadata chunk should be removed")
+	}
+}
+
+func TestStripMetadata_WebP(t *testing.T) {
+	data := webpWithExif(t, 8, 4, testExifTIFF())
+	if exif, err := ParseExif(data); err != nil || exif.GPS == nil {
+		t.Fatalf("Expected the WebP exif to be parsed, got %+v, %v", exif, err)
+	}
+
+	stripped, fields := StripMetadata(data, user.StripMetadataGPS)
+	if !slices.Equal(fields, []string{"exif.gps"}) || len(stripped) != len(data) {
+		t.Errorf("Expected GPS to be erased in place, got %v", fields)
+	}
+	if exif, err := ParseExif(stripped); err != nil || exif.GPS != nil || exif.Make != "Vixel" {
+		t.Errorf("Expected only GPS to be removed, got %+v, %v", exif, err)
+	}
+
+	stripped, fields = StripMetadata(data, user.StripMetadataAll)
+	if !slices.Equal(fields, []string{"exif", "xmp"}) || bytes.Contains(stripped, []byte("Jane Doe")) {
+		t.Errorf("Expected the EXIF and XMP chunks to be removed, got %v", fields)
+	}
+	if size := binary.LittleEndian.Uint32(stripped[4:]); int(size) != len(stripped)-8 || stripped[20]&webpXMPFlag != 0 {
+		t.Errorf("Expected the RIFF size and VP8X flags to be updated, got size %d and flags %08b", size, stripped[20])
+	}
+	exif, err := ParseExif(stripped)
+	if err != nil || exif.Orientation != 6 || exif.Make != "" {
+		t.Errorf("Expected only the orientation to be kept, got %+v, %v", exif, err)
+	}
+	metadata, err := ExtractMetadata(stripped)
+	if err != nil {
+		t.Fatalf("Stripped image should still decode: %v", err)
+	}
+	if metadata.Width != 4 || metadata.Height != 8 {
+		t.Errorf("Expected oriented dimensions 4x8, got %dx%d", metadata.Width, metadata.Height)
+	}
+}
+
+func TestStripMetadata_WebPKeepsTheChunkOrder(t *testing.T) {
+	header := make([]byte, 10)
+	header[0] = webpExifFlag | webpXMPFlag | 1<<1
+
+	var out bytes.Buffer
+	out.WriteString("RIFF\x00\x00\x00\x00WEBP")
+	writeWebPChunk(&out, "VP8X", header)
+	writeWebPChunk(&out, "ANIM", make([]byte, 6))
+	writeWebPChunk(&out, "ANMF", make([]byte, 16))
+	writeWebPChunk(&out, "EXIF", testExifTIFF())
+	writeWebPChunk(&out, "XMP ", []byte("<x:xmpmeta>Jane Doe</x:xmpmeta>"))
+	writeWebPChunk(&out, "ABCD", []byte("unknown"))
+	data := out.Bytes()
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+
+	stripped, fields := StripMetadata(data, user.StripMetadataAll)
+	if !slices.Equal(fields, []string{"exif", "xmp"}) {
+		t.Fatalf("Expected the EXIF and XMP chunks to be removed, got %v", fields)
+	}
+	chunks, err := webpChunks(bytes.NewReader(stripped), int64(len(stripped)))
+	if err != nil {
+		t.Fatalf("webpChunks failed: %v", err)
+	}
+	var kinds []string
+	for _, chunk := range chunks {
+		kinds = append(kinds, chunk.kind)
+	}
+	if expected := []string{"VP8X", "ANIM", "ANMF", "EXIF", "ABCD"}; !slices.Equal(kinds, expected) {
+		t.Errorf("Expected chunks %v, got %v", expected, kinds)
+	}
+	if exif, err := ParseExif(stripped); err != nil || exif.Orientation != 6 {
+		t.Errorf("Expected the orientation to be kept, got %+v, %v", exif, err)
+	}
+}
+
+func TestStripSpooled_StreamsTheImageData(t *testing.T) {
+	header := jpegWithExif(t, 8, 4, testExifTIFF())
+	data := slices.Concat(header, make([]byte, 1<<18))
+	expected, _ := StripMetadata(data, user.StripMetadataAll)
+
+	r := &countingReaderAt{r: bytes.NewReader(data)}
+	plan, fields, err := planStrip(r, int64(len(data)), user.StripMetadataAll)
+	if err != nil || !slices.Equal(fields, []string{"exif"}) {
+		t.Fatalf("Expected exif to be stripped, got %v, %v", fields, err)
+	}
+	if r.read >= int64(len(header)) {
+		t.Errorf("Planning should only read the metadata, read %d of %d bytes", r.read, len(data))
+	}
+
+	file, err := spool(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("spool failed: %v", err)
+	}
+	stripped, _, err := stripSpooled(file, user.StripMetadataAll)
+	if err != nil {
+		t.Fatalf("stripSpooled failed: %v", err)
+	}
+	defer stripped.Close()
+	out, _ := io.ReadAll(stripped.reader())
+	if !bytes.Equal(out, expected) || stripped.size != plan.size() {
+		t.Error("The streamed copy should match the stripped file")
+	}
+}
+
+func TestStripMetadata_None(t *testing.T) {
+	data := jpegWithExif(t, 4, 4, testExifTIFF())
+	if stripped, fields := StripMetadata(data, user.StripMetadataNone); !bytes.Equal(stripped, data) || fields != nil {
+		t.Error("Nothing should be stripped")
+	}
+	if NewStrippedMetadata(user.StripMetadataGPS, nil) != nil {
+		t.Error("No audit record should be created when nothing was removed")
+	}
+}
+
+func TestOrient(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+
+	rotated := Orient(img, 6)
+	if rotated.Bounds().Dx() != 1 || rotated.Bounds().Dy() != 2 {
+		t.Fatalf("Expected a 1x2 image, got %v", rotated.Bounds())
+	}
+	if r, _, _, _ := rotated.At(0, 0).RGBA(); r == 0 {
+		t.Error("Orientation 6 should rotate the left edge to the top")
+	}
+	if Orient(img, 1) != image.Image(img) {
+		t.Error("Orientation 1 should leave the image untouched")
+	}
+}
@@ -0,0 +1,149 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"vixel/config"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type MinioStorage struct {
+	client *minio.Client
+	bucket string
+	region string
+	mu     sync.Mutex
+	ready  bool
+}
+
+func NewMinioStorage() (*MinioStorage, error) {
+	c, err := minio.New(config.Config.MINIOEndpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.Config.MINIOAccessKey, config.Config.MINIOSecretKey, ""),
+		Secure: config.Config.MINIOUseSSL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MinioStorage{client: c, bucket: config.Config.MINIOBucketName, region: config.Config.MINIORegion}, nil
+}
+
+func (s *MinioStorage) InBucket(bucket string) Storage {
+	if bucket == s.bucket {
+		return s
+	}
+	return &MinioStorage{client: s.client, bucket: bucket, region: s.region}
+}
+
+func (s *MinioStorage) ensureBucket(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ready {
+		return nil
+	}
+
+	if ok, err := s.client.BucketExists(ctx, s.bucket); err != nil || !ok {
+		if err := s.client.MakeBucket(ctx, s.bucket, minio.MakeBucketOptions{
+			Region: s.region,
+		}); err != nil {
+			return err
+		}
+	}
+
+	policy := ""
+	if config.Config.StoragePublicRead {
+		policy = fmt.Sprintf(`{
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Effect": "Allow",
+				"Principal": {"AWS": ["*"]},
+				"Action": ["s3:GetObject"],
+				"Resource": ["arn:aws:s3:::%s/*"]
+			}]
+		}`, s.bucket)
+	}
+	if err := s.client.SetBucketPolicy(ctx, s.bucket, policy); err != nil {
+		return err
+	}
+
+	s.ready = true
+	return nil
+}
+
+func (s *MinioStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := s.ensureBucket(ctx); err != nil {
+		return err
+	}
+
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	return err
+}
+
+func (s *MinioStorage) Get(ctx context.Context, key string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, minioError(err)
+	}
+
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, nil, minioError(err)
+	}
+
+	return object, toObjectInfo(info), nil
+}
+
+func (s *MinioStorage) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *MinioStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, minioError(err)
+	}
+	return toObjectInfo(info), nil
+}
+
+func (s *MinioStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if info.Err != nil {
+			return nil, minioError(info.Err)
+		}
+		objects = append(objects, *toObjectInfo(info))
+	}
+	return objects, nil
+}
+
+func (s *MinioStorage) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func toObjectInfo(info minio.ObjectInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+	}
+}
+
+func minioError(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket":
+		return ErrObjectNotFound
+	}
+	return err
+}
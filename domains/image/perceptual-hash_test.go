@@ -0,0 +1,111 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func gradientImage(width, height int, horizontal bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(x * 255 / width)
+			if !horizontal {
+				v = uint8(y * 255 / height)
+			}
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+func TestDifferenceHash_SimilarImages(t *testing.T) {
+	original := gradientImage(200, 100, true)
+	resized := imaging.Resize(original, 120, 60, imaging.Lanczos)
+	brighter := imaging.AdjustBrightness(original, 10)
+	different := gradientImage(200, 100, false)
+
+	hash := DifferenceHash(original)
+	if d := HammingDistance(hash, DifferenceHash(resized)); d > 4 {
+		t.Errorf("Expected a resized copy to be close, got distance %d", d)
+	}
+	if d := HammingDistance(hash, DifferenceHash(brighter)); d > 4 {
+		t.Errorf("Expected a brighter copy to be close, got distance %d", d)
+	}
+	if d := HammingDistance(hash, DifferenceHash(different)); d < 20 {
+		t.Errorf("Expected a different image to be far, got distance %d", d)
+	}
+}
+
+func TestImageService_FindSimilarImages(t *testing.T) {
+	db := setupImageTestDB(t)
+	service := NewImageService(db)
+
+	save := func(userID uint, hash uint64) *Image {
+		img := &Image{StorageKey: "k", UserID: userID}
+		img.SetPerceptualHash(hash)
+		db.Create(img)
+		return img
+	}
+	source := save(1, 0)
+	near := save(1, 0b11)
+	save(1, 0xFFFF)
+	other := save(2, 0b1)
+	db.Create(&Image{StorageKey: "unhashed", UserID: 1})
+
+	similar, err := service.FindSimilarImages(source, 1, 5, 10)
+	if err != nil {
+		t.Fatalf("FindSimilarImages failed: %v", err)
+	}
+	if len(similar) != 1 || similar[0].ID != near.ID || similar[0].Distance != 2 {
+		t.Errorf("Expected only the near image of the same user, got %+v", similar)
+	}
+
+	similar, _ = service.FindSimilarImages(source, 0, 5, 10)
+	if len(similar) != 2 || similar[0].ID != other.ID {
+		t.Errorf("Expected matches across users ordered by distance, got %+v", similar)
+	}
+
+	similar, _ = service.FindSimilarImages(source, 0, 5, 1)
+	if len(similar) != 1 || similar[0].ID != other.ID {
+		t.Errorf("Expected only the nearest match within the limit, got %+v", similar)
+	}
+}
+
+func TestBackfillImageMetadata(t *testing.T) {
+	db := setupImageTestDB(t)
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	png.Encode(&buf, gradientImage(40, 20, true))
+	storage.Put(ctx, "photo", bytes.NewReader(buf.Bytes()), int64(buf.Len()), "image/png")
+	storage.Put(ctx, "broken", bytes.NewReader([]byte("not an image")), 12, "image/png")
+
+	photo := &Image{StorageKey: "photo", UserID: 1}
+	db.Create(photo)
+	db.Create(&Image{StorageKey: "broken", UserID: 1})
+	db.Create(&Image{StorageKey: "missing", UserID: 1})
+
+	updated, skipped, err := BackfillImageMetadata(ctx, db, storage, 2)
+	if err != nil {
+		t.Fatalf("BackfillImageMetadata failed: %v", err)
+	}
+	if updated != 1 || skipped != 2 {
+		t.Errorf("Expected 1 updated and 2 skipped, got %d and %d", updated, skipped)
+	}
+
+	db.First(photo, photo.ID)
+	if photo.PerceptualHash == nil || uint64(*photo.PerceptualHash) != DifferenceHash(gradientImage(40, 20, true)) {
+		t.Errorf("Expected the perceptual hash to be stored, got %v", photo.PerceptualHash)
+	}
+	if photo.Width != 40 || photo.Height != 20 || photo.Format != "png" || photo.Colors == nil {
+		t.Errorf("Expected metadata to be stored, got %dx%d %q %+v", photo.Width, photo.Height, photo.Format, photo.Colors)
+	}
+}
@@ -0,0 +1,113 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"vixel/config"
+	"vixel/domains/user"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+type usageTotals struct {
+	Objects int64
+	Bytes   int64
+}
+
+func GetUsage(db *gorm.DB, userID uint) (*UsageResponse, error) {
+	var owner user.User
+	if err := db.Select("id", "quota_bytes", "quota_objects").First(&owner, userID).Error; err != nil {
+		return nil, err
+	}
+	return measureUsage(db, owner)
+}
+
+func CheckQuota(db *gorm.DB, userID uint, bytes, objects int64) error {
+	owner := user.User{}
+	err := db.Select("id", "quota_bytes", "quota_objects").First(&owner, userID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	owner.ID = userID
+
+	usage, err := measureUsage(db, owner)
+	if err != nil {
+		return err
+	}
+	if usage.BytesLimit != nil && usage.BytesUsed+bytes > *usage.BytesLimit {
+		return fmt.Errorf("%w: %d of %d bytes used, %d more requested", ErrQuotaExceeded, usage.BytesUsed, *usage.BytesLimit, bytes)
+	}
+	if usage.ObjectsLimit != nil && usage.ObjectsUsed+objects > *usage.ObjectsLimit {
+		return fmt.Errorf("%w: %d of %d objects used", ErrQuotaExceeded, usage.ObjectsUsed, *usage.ObjectsLimit)
+	}
+	return nil
+}
+
+// ReserveQuota checks the quota inside the transaction that records new
+// objects. The owner's row is locked first, so concurrent writes for the same
+// user are checked one after another instead of against the same usage.
+func ReserveQuota(tx *gorm.DB, userID uint, bytes, objects int64) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Limit(1).Find(&user.User{}, userID).Error; err != nil {
+		return err
+	}
+	return CheckQuota(tx, userID, bytes, objects)
+}
+
+func measureUsage(db *gorm.DB, owner user.User) (*UsageResponse, error) {
+	var images, versions, renders usageTotals
+	if err := db.Model(&Image{}).
+		Select("COUNT(*) AS objects, COALESCE(SUM(size), 0) AS bytes").
+		Where("user_id = ?", owner.ID).
+		Scan(&images).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&ImageVersion{}).
+		Select("COUNT(*) AS objects, COALESCE(SUM(image_versions.size), 0) AS bytes").
+		Joins("JOIN images ON images.id = image_versions.image_id AND images.deleted_at IS NULL").
+		Where("images.user_id = ?", owner.ID).
+		Scan(&versions).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&RenderedVariant{}).
+		Select("COUNT(*) AS objects, COALESCE(SUM(rendered_variants.size), 0) AS bytes").
+		Joins("JOIN images ON images.id = rendered_variants.image_id AND images.deleted_at IS NULL").
+		Where("images.user_id = ?", owner.ID).
+		Scan(&renders).Error; err != nil {
+		return nil, err
+	}
+
+	return &UsageResponse{
+		BytesUsed:    images.Bytes + versions.Bytes + renders.Bytes,
+		ObjectsUsed:  images.Objects + versions.Objects + renders.Objects,
+		BytesLimit:   effectiveQuota(owner.QuotaBytes, config.Config.StorageQuotaBytes),
+		ObjectsLimit: effectiveQuota(owner.QuotaObjects, config.Config.StorageQuotaObjects),
+	}, nil
+}
+
+func SetQuota(db *gorm.DB, userID uint, dto SetQuotaDto) (*UsageResponse, error) {
+	result := db.Model(&user.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"quota_bytes":   dto.Bytes,
+		"quota_objects": dto.Objects,
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return GetUsage(db, userID)
+}
+
+func effectiveQuota(override *int64, fallback int64) *int64 {
+	limit := fallback
+	if override != nil {
+		limit = *override
+	}
+	if limit <= 0 {
+		return nil
+	}
+	return &limit
+}
@@ -0,0 +1,105 @@
+package image
+
+import (
+	"errors"
+	"testing"
+
+	"vixel/config"
+	"vixel/domains/user"
+)
+
+func TestGetUsage_CountsImagesAndVersions(t *testing.T) {
+	previous := *config.Config
+	t.Cleanup(func() { *config.Config = previous })
+	config.Config.StorageQuotaBytes = 1000
+	config.Config.StorageQuotaObjects = 0
+
+	db := setupImageTestDB(t)
+	owner := &user.User{Username: "u", Email: "u@example.com", Password: "x"}
+	db.Create(owner)
+
+	img := &Image{StorageKey: "a", UserID: owner.ID, Size: 100}
+	db.Create(img)
+	db.Create(&ImageVersion{ImageID: img.ID, StorageKey: "a-1", Size: 40})
+	deleted := &Image{StorageKey: "b", UserID: owner.ID, Size: 500}
+	db.Create(deleted)
+	db.Create(&ImageVersion{ImageID: deleted.ID, StorageKey: "b-1", Size: 50})
+	db.Delete(deleted)
+	db.Create(&Image{StorageKey: "c", UserID: owner.ID + 1, Size: 300})
+
+	usage, err := GetUsage(db, owner.ID)
+	if err != nil {
+		t.Fatalf("GetUsage failed: %v", err)
+	}
+	if usage.BytesUsed != 140 || usage.ObjectsUsed != 2 {
+		t.Errorf("Expected 140 bytes in 2 objects, got %+v", usage)
+	}
+	if usage.BytesLimit == nil || *usage.BytesLimit != 1000 || usage.ObjectsLimit != nil {
+		t.Errorf("Expected the default byte limit and no object limit, got %+v", usage)
+	}
+
+	if err := CheckQuota(db, owner.ID, 860, 1); err != nil {
+		t.Errorf("Expected upload up to the limit to be allowed, got %v", err)
+	}
+	if err := CheckQuota(db, owner.ID, 861, 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+}
+
+func TestSetQuota_OverridesDefault(t *testing.T) {
+	previous := *config.Config
+	t.Cleanup(func() { *config.Config = previous })
+	config.Config.StorageQuotaBytes = 1000
+	config.Config.StorageQuotaObjects = 10
+
+	db := setupImageTestDB(t)
+	owner := &user.User{Username: "u", Email: "u@example.com", Password: "x"}
+	db.Create(owner)
+	db.Create(&Image{StorageKey: "a", UserID: owner.ID, Size: 100})
+
+	unlimited, one := int64(0), int64(1)
+	usage, err := SetQuota(db, owner.ID, SetQuotaDto{Bytes: &unlimited, Objects: &one})
+	if err != nil {
+		t.Fatalf("SetQuota failed: %v", err)
+	}
+	if usage.BytesLimit != nil || usage.ObjectsLimit == nil || *usage.ObjectsLimit != 1 {
+		t.Errorf("Expected unlimited bytes and one object, got %+v", usage)
+	}
+	if err := CheckQuota(db, owner.ID, 5000, 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected the object limit to apply, got %v", err)
+	}
+
+	usage, _ = SetQuota(db, owner.ID, SetQuotaDto{})
+	if usage.BytesLimit == nil || *usage.BytesLimit != 1000 || *usage.ObjectsLimit != 10 {
+		t.Errorf("Expected defaults after clearing the override, got %+v", usage)
+	}
+
+	if _, err := SetQuota(db, owner.ID+100, SetQuotaDto{}); err == nil {
+		t.Error("Expected an error for an unknown user")
+	}
+}
+
+func TestSaveImage_RechecksQuota(t *testing.T) {
+	previous := *config.Config
+	t.Cleanup(func() { *config.Config = previous })
+	config.Config.StorageQuotaBytes = 100
+	config.Config.StorageQuotaObjects = 0
+
+	db := setupImageTestDB(t)
+	service := NewImageService(db)
+	owner := &user.User{Username: "u", Email: "u@example.com", Password: "x"}
+	db.Create(owner)
+
+	// Both uploads pass the check made before storing them.
+	for _, key := range []string{"a", "b"} {
+		if err := service.CheckQuota(owner.ID, 60, 1); err != nil {
+			t.Fatalf("Expected %s to fit before either is saved, got %v", key, err)
+		}
+	}
+	if _, err := service.SaveImage(&Image{StorageKey: "a", UserID: owner.ID, Size: 60}); err != nil {
+		t.Fatalf("SaveImage failed: %v", err)
+	}
+	if _, err := service.SaveImage(&Image{StorageKey: "b", UserID: owner.ID, Size: 60}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected the second image to be rejected on save, got %v", err)
+	}
+}
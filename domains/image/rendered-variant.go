@@ -0,0 +1,47 @@
+package image
+
+import (
+	"time"
+	"vixel/config"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RenderedVariant records a cached render so that it counts towards the
+// image owner's storage quota.
+type RenderedVariant struct {
+	Key       string `gorm:"primaryKey"`
+	ImageID   uint   `gorm:"not null;index"`
+	Size      int64
+	CreatedAt time.Time
+}
+
+// RecordRender stores the variant and evicts the oldest renders of the image
+// beyond RENDER_CACHE_LIMIT. It returns the keys of the evicted objects.
+func RecordRender(tx *gorm.DB, variant RenderedVariant) ([]string, error) {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&variant).Error; err != nil {
+		return nil, err
+	}
+
+	limit := config.Config.RenderCacheLimit
+	if limit <= 0 {
+		return nil, nil
+	}
+	var keys []string
+	if err := tx.Model(&RenderedVariant{}).
+		Where("image_id = ?", variant.ImageID).
+		Order("created_at DESC, key DESC").
+		Pluck("key", &keys).Error; err != nil {
+		return nil, err
+	}
+	if len(keys) <= limit {
+		return nil, nil
+	}
+
+	evicted := keys[limit:]
+	if err := tx.Where("key IN ?", evicted).Delete(&RenderedVariant{}).Error; err != nil {
+		return nil, err
+	}
+	return evicted, nil
+}
@@ -0,0 +1,33 @@
+package image
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"time"
+	"vixel/config"
+)
+
+func signedURL(key string, expiry time.Duration) string {
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", sign(key, expires))
+	return PublicURL("", key) + "?" + query.Encode()
+}
+
+func validSignature(key, expires, signature string) bool {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(sign(key, expires)))
+}
+
+func sign(key, expires string) string {
+	mac := hmac.New(sha256.New, []byte(config.Config.StorageSigningKey))
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
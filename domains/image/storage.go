@@ -0,0 +1,94 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"vixel/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+}
+
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, *ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// BucketStorage is implemented by drivers that keep objects in named buckets.
+// Rows migrated from older versions may point at a bucket other than the
+// configured one.
+type BucketStorage interface {
+	InBucket(bucket string) Storage
+}
+
+// StorageFor returns the storage holding objects of the given bucket. Drivers
+// without buckets, and rows without one, use the configured storage.
+func StorageFor(storage Storage, bucket string) Storage {
+	if b, ok := storage.(BucketStorage); ok && bucket != "" {
+		return b.InBucket(bucket)
+	}
+	return storage
+}
+
+func NewStorage() (Storage, error) {
+	switch config.Config.StorageDriver {
+	case "", "minio":
+		return NewMinioStorage()
+	case "local":
+		return NewLocalStorage(config.Config.StorageLocalPath)
+	case "memory":
+		return NewMemoryStorage(), nil
+	}
+	return nil, fmt.Errorf("unknown storage driver %q", config.Config.StorageDriver)
+}
+
+func usesMinio() bool {
+	return config.Config.StorageDriver == "" || config.Config.StorageDriver == "minio"
+}
+
+func ServeStorage(storage Storage) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := strings.TrimPrefix(ctx.Param("key"), "/")
+		if !config.Config.StoragePublicRead && !validSignature(key, ctx.Query("expires"), ctx.Query("signature")) {
+			ctx.Status(http.StatusForbidden)
+			return
+		}
+
+		object, info, err := storage.Get(ctx, key)
+		if err != nil {
+			ctx.Status(http.StatusNotFound)
+			return
+		}
+		defer object.Close()
+
+		ServeObject(ctx, object, info)
+	}
+}
+
+func ServeObject(ctx *gin.Context, object io.ReadSeeker, info *ObjectInfo) {
+	if info.ContentType != "" {
+		ctx.Header("Content-Type", info.ContentType)
+	}
+	if info.ETag != "" {
+		ctx.Header("ETag", `"`+strings.Trim(info.ETag, `"`)+`"`)
+	}
+	http.ServeContent(ctx.Writer, ctx.Request, info.Key, info.LastModified, object)
+}
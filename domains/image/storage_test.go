@@ -0,0 +1,126 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"vixel/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+func testStorageBackend(t *testing.T, storage Storage) {
+	ctx := context.Background()
+	data := createTestImageData()
+
+	if err := storage.Put(ctx, "images/a.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	storage.Put(ctx, "renders/b", bytes.NewReader([]byte("b")), 1, "text/plain")
+
+	object, info, err := storage.Get(ctx, "images/a.jpg")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	got, _ := io.ReadAll(object)
+	object.Close()
+
+	if !bytes.Equal(got, data) {
+		t.Error("Stored data does not match")
+	}
+
+	if info.Size != int64(len(data)) || info.ContentType != "image/jpeg" {
+		t.Errorf("Unexpected object info: %+v", info)
+	}
+
+	stat, err := storage.Stat(ctx, "images/a.jpg")
+	if err != nil || stat.Size != int64(len(data)) {
+		t.Errorf("Stat returned %+v, %v", stat, err)
+	}
+
+	objects, err := storage.List(ctx, "images/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	if len(objects) != 1 || objects[0].Key != "images/a.jpg" {
+		t.Errorf("Expected only images/a.jpg to be listed, got %+v", objects)
+	}
+
+	if url, err := storage.PresignGet(ctx, "images/a.jpg", 0); err != nil || url == "" {
+		t.Errorf("PresignGet returned %q, %v", url, err)
+	}
+
+	if err := storage.Delete(ctx, "images/a.jpg"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	if _, _, err := storage.Get(ctx, "images/a.jpg"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound after delete, got %v", err)
+	}
+
+	if _, err := storage.Stat(ctx, "missing"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound for missing object, got %v", err)
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	testStorageBackend(t, NewMemoryStorage())
+}
+
+func TestLocalStorage(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	testStorageBackend(t, storage)
+}
+
+func TestLocalStorage_KeysStayInsideRoot(t *testing.T) {
+	root := t.TempDir()
+	storage, _ := NewLocalStorage(root)
+
+	path, err := storage.path("../../etc/passwd")
+	if err != nil {
+		t.Fatalf("path failed: %v", err)
+	}
+
+	if !bytes.HasPrefix([]byte(path), []byte(root)) {
+		t.Errorf("Expected %s to stay inside %s", path, root)
+	}
+}
+
+func TestServeStorage_RequiresValidSignature(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.Config.StorageDriver = "memory"
+	config.Config.StorageBaseURL = "http://localhost/storage"
+	config.Config.StorageSigningKey = "secret"
+	config.Config.StoragePublicRead = false
+
+	storage := NewMemoryStorage()
+	storage.Put(context.Background(), "key", bytes.NewReader([]byte("data")), 4, "image/jpeg")
+	router := gin.New()
+	router.GET("/storage/*key", ServeStorage(storage))
+
+	signed, _ := storage.PresignGet(context.Background(), "key", time.Minute)
+	expired, _ := storage.PresignGet(context.Background(), "key", -time.Minute)
+	cases := map[string]int{
+		strings.TrimPrefix(signed, "http://localhost"):  http.StatusOK,
+		strings.TrimPrefix(expired, "http://localhost"): http.StatusForbidden,
+		"/storage/key": http.StatusForbidden,
+		strings.Replace(strings.TrimPrefix(signed, "http://localhost"), "key", "other", 1): http.StatusForbidden,
+	}
+	for target, expected := range cases {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != expected {
+			t.Errorf("%s: expected status %d, got %d", target, expected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package image
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StoredObject counts the references to a content-addressed object. A row
+// whose last reference is released is kept, marked as deleting, until the
+// object is gone; an upload of the same content in the meantime revives it
+// and stores the object again.
+type StoredObject struct {
+	Key         string `gorm:"primaryKey"`
+	ContentHash string `gorm:"not null;index"`
+	Size        int64
+	RefCount    int  `gorm:"not null;default:0"`
+	Deleting    bool `gorm:"not null;default:false"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// RetainObject adds a reference to an object. store is called with the row
+// locked when the object may be missing from storage: when it is recorded
+// for the first time, or when it was released and is waiting to be deleted.
+func RetainObject(tx *gorm.DB, object StoredObject, store func() error) error {
+	for {
+		var existing StoredObject
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", object.Key).Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+		if existing.Key != "" && !existing.Deleting {
+			return tx.Model(&StoredObject{}).Where("key = ?", object.Key).Update("ref_count", gorm.Expr("ref_count + 1")).Error
+		}
+		if existing.Key != "" {
+			if err := tx.Model(&StoredObject{}).Where("key = ?", object.Key).Updates(map[string]interface{}{"ref_count": 1, "deleting": false}).Error; err != nil {
+				return err
+			}
+			return storeObject(store)
+		}
+
+		object.RefCount = 1
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&object)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			return storeObject(store)
+		}
+		// Another request recorded the same object first; retain its row.
+	}
+}
+
+func storeObject(store func() error) error {
+	if store == nil {
+		return nil
+	}
+	return store()
+}
+
+// ReleaseObjects drops a reference to each key and returns the keys that are
+// no longer referenced. Their objects must be removed with
+// DeleteReleasedObject.
+func ReleaseObjects(tx *gorm.DB, keys []string) ([]string, error) {
+	var orphaned []string
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+
+		var object StoredObject
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).Limit(1).Find(&object).Error
+		if err != nil {
+			return nil, err
+		}
+		if object.Key == "" {
+			orphaned = append(orphaned, key)
+			continue
+		}
+
+		if object.RefCount > 1 {
+			if err := tx.Model(&StoredObject{}).Where("key = ?", key).Update("ref_count", gorm.Expr("ref_count - 1")).Error; err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := tx.Model(&StoredObject{}).Where("key = ?", key).Updates(map[string]interface{}{"ref_count": 0, "deleting": true}).Error; err != nil {
+			return nil, err
+		}
+		orphaned = append(orphaned, key)
+	}
+	return orphaned, nil
+}
+
+// DeleteReleasedObject deletes an object released by ReleaseObjects unless it
+// was retained again in the meantime. The row stays locked while delete runs,
+// so an upload of the same content waits for it and then stores the object
+// again.
+func DeleteReleasedObject(db *gorm.DB, key string, delete func() error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var object StoredObject
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).Limit(1).Find(&object).Error; err != nil {
+			return err
+		}
+		if object.Key != "" && !object.Deleting {
+			return nil
+		}
+		if err := delete(); err != nil && !errors.Is(err, ErrObjectNotFound) {
+			return err
+		}
+		if object.Key == "" {
+			return nil
+		}
+		return tx.Where("key = ? AND deleting = ?", key, true).Delete(&StoredObject{}).Error
+	})
+}
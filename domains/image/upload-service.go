@@ -3,169 +3,125 @@ package image
 import (
 	"bytes"
 	"context"
-	"fmt"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
-	"math/rand"
-	"mime/multipart"
-	"time"
-	"vixel/config"
-
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
 type UploadServiceInterface interface {
-	UploadImage(ctx context.Context, file *multipart.FileHeader) (string, error)
+	Upload(ctx context.Context, r io.Reader, size int64, hash, format string) (string, error)
+	OpenCurrentImage(ctx context.Context, image *Image) (io.ReadSeekCloser, *ObjectInfo, error)
 }
 
 type UploadService struct {
-	client *minio.Client
+	storage Storage
 }
 
-func NewUploadService() *UploadService {
-	c, err := minio.New(config.Config.MINIOEndpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(config.Config.MINIOAccessKey, config.Config.MINIOSecretKey, ""),
-		Secure: config.Config.MINIOUseSSL,
-	})
-	if err != nil {
-		panic(err)
-	}
-	return &UploadService{client: c}
+func NewUploadService(storage Storage) *UploadService {
+	return &UploadService{storage: storage}
 }
 
-func (s *UploadService) UploadImage(ctx context.Context, file *multipart.FileHeader) (string, error) {
-	bucketName := config.Config.MINIOBucketName
-	if ok, err := s.client.BucketExists(ctx, bucketName); err != nil || !ok {
-		if err := s.client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{
-			Region: config.Config.MINIORegion,
-		}); err != nil {
-			return "", err
-		}
+var formatExtensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+	"bmp":  ".bmp",
+	"tiff": ".tiff",
+	"webp": ".webp",
+}
 
-		policy := fmt.Sprintf(`{
-			"Version": "2012-10-17",
-			"Statement": [{
-				"Effect": "Allow",
-				"Principal": {"AWS": ["*"]},
-				"Action": ["s3:GetObject"],
-				"Resource": ["arn:aws:s3:::%s/*"]
-			}]
-		}`, bucketName)
-		if err := s.client.SetBucketPolicy(ctx, bucketName, policy); err != nil {
-			return "", err
-		}
-	}
+func ContentKey(hash, format string) string {
+	return "sha256/" + hash + formatExtensions[format]
+}
 
-	imageName := fmt.Sprintf("vixel-%v-%v", rand.Intn(999999), time.Now().UnixNano())
-	f, err := file.Open()
-	if err != nil {
+func HashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *UploadService) Upload(ctx context.Context, r io.Reader, size int64, hash, format string) (string, error) {
+	key := ContentKey(hash, format)
+	_, err := s.storage.Stat(ctx, key)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, ErrObjectNotFound) {
 		return "", err
 	}
-	defer f.Close()
-
-	uploadInfo, err := s.client.PutObject(ctx, bucketName, imageName, f, file.Size, minio.PutObjectOptions{
-		ContentType: file.Header.Get("Content-Type"),
-	})
-	if err != nil {
+	if err := s.storage.Put(ctx, key, r, size, ContentTypeForFormat(format)); err != nil {
 		return "", err
 	}
+	return key, nil
+}
 
-	protocol := "http"
-	if config.Config.MINIOUseSSL {
-		protocol = "https"
-	}
-	imageURL := fmt.Sprintf("%s://%s/%s/%s", protocol, config.Config.MINIOEndpoint, bucketName, uploadInfo.Key)
+func (s *UploadService) UploadImageFromBytes(ctx context.Context, data []byte, format string) (string, error) {
+	return s.Upload(ctx, bytes.NewReader(data), int64(len(data)), HashBytes(data), format)
+}
 
-	return imageURL, nil
+func (s *UploadService) PutObject(ctx context.Context, objectName string, data []byte, contentType string) error {
+	return s.storage.Put(ctx, objectName, bytes.NewReader(data), int64(len(data)), contentType)
 }
 
-func (s *UploadService) UploadImageFromBytes(ctx context.Context, data []byte, contentType string) (string, error) {
-	bucketName := config.Config.MINIOBucketName
-	if ok, err := s.client.BucketExists(ctx, bucketName); err != nil || !ok {
-		if err := s.client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{
-			Region: config.Config.MINIORegion,
-		}); err != nil {
-			return "", err
-		}
+func (s *UploadService) Open(ctx context.Context, objectName string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	return s.storage.Get(ctx, objectName)
+}
 
-		policy := fmt.Sprintf(`{
-			"Version": "2012-10-17",
-			"Statement": [{
-				"Effect": "Allow",
-				"Principal": {"AWS": ["*"]},
-				"Action": ["s3:GetObject"],
-				"Resource": ["arn:aws:s3:::%s/*"]
-			}]
-		}`, bucketName)
-		if err := s.client.SetBucketPolicy(ctx, bucketName, policy); err != nil {
-			return "", err
-		}
-	}
+func (s *UploadService) OpenInBucket(ctx context.Context, bucket, objectName string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	return StorageFor(s.storage, bucket).Get(ctx, objectName)
+}
 
-	imageName := fmt.Sprintf("vixel-%v-%v", rand.Intn(999999), time.Now().UnixNano())
-	reader := bytes.NewReader(data)
+func (s *UploadService) OpenCurrentImage(ctx context.Context, image *Image) (io.ReadSeekCloser, *ObjectInfo, error) {
+	if image.CurrentVersion != nil {
+		return s.OpenInBucket(ctx, image.CurrentVersion.Bucket, image.CurrentVersion.StorageKey)
+	}
+	return s.OpenInBucket(ctx, image.Bucket, image.StorageKey)
+}
 
-	uploadInfo, err := s.client.PutObject(ctx, bucketName, imageName, reader, int64(len(data)), minio.PutObjectOptions{
-		ContentType: contentType,
-	})
+func (s *UploadService) GetObject(ctx context.Context, objectName string) ([]byte, string, error) {
+	object, info, err := s.Open(ctx, objectName)
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
+	defer object.Close()
 
-	protocol := "http"
-	if config.Config.MINIOUseSSL {
-		protocol = "https"
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return nil, "", err
 	}
-	imageURL := fmt.Sprintf("%s://%s/%s/%s", protocol, config.Config.MINIOEndpoint, bucketName, uploadInfo.Key)
 
-	return imageURL, nil
+	return data, info.ContentType, nil
 }
 
-func (s *UploadService) DeleteImage(ctx context.Context, imageURL string) error {
-	bucketName := config.Config.MINIOBucketName
-
-	var objectName string
-	protocol := "http"
-	if config.Config.MINIOUseSSL {
-		protocol = "https"
-	}
-	prefix := fmt.Sprintf("%s://%s/%s/", protocol, config.Config.MINIOEndpoint, bucketName)
-	if len(imageURL) <= len(prefix) || imageURL[:len(prefix)] != prefix {
-		return fmt.Errorf("invalid image URL")
-	}
-	objectName = imageURL[len(prefix):]
-
-	err := s.client.RemoveObject(ctx, bucketName, objectName, minio.RemoveObjectOptions{})
+func (s *UploadService) GetCurrentImage(ctx context.Context, image *Image) ([]byte, error) {
+	object, _, err := s.OpenCurrentImage(ctx, image)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	defer object.Close()
+
+	return io.ReadAll(object)
 }
 
-func (s *UploadService) GetImageByUrl(ctx context.Context, imageURL string) ([]byte, error) {
-	bucketName := config.Config.MINIOBucketName
+func (s *UploadService) DeleteObject(ctx context.Context, objectName string) error {
+	return s.storage.Delete(ctx, objectName)
+}
 
-	var objectName string
-	protocol := "http"
-	if config.Config.MINIOUseSSL {
-		protocol = "https"
-	}
-	prefix := fmt.Sprintf("%s://%s/%s/", protocol, config.Config.MINIOEndpoint, bucketName)
-	if len(imageURL) <= len(prefix) || imageURL[:len(prefix)] != prefix {
-		return nil, fmt.Errorf("invalid image URL")
-	}
-	objectName = imageURL[len(prefix):]
+func (s *UploadService) DeleteInBucket(ctx context.Context, bucket, objectName string) error {
+	return StorageFor(s.storage, bucket).Delete(ctx, objectName)
+}
 
-	object, err := s.client.GetObject(ctx, bucketName, objectName, minio.GetObjectOptions{})
+func (s *UploadService) DeletePrefix(ctx context.Context, prefix string) error {
+	objects, err := s.storage.List(ctx, prefix)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer object.Close()
 
-	data, err := io.ReadAll(object)
-	if err != nil {
-		return nil, err
+	var errs []error
+	for _, object := range objects {
+		if err := s.storage.Delete(ctx, object.Key); err != nil {
+			errs = append(errs, err)
+		}
 	}
-
-	return data, nil
+	return errors.Join(errs...)
 }
@@ -0,0 +1,70 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+	"os"
+)
+
+// spooledFile is an upload copied to a temporary file and hashed on the way,
+// so it can be inspected and stored without being held in memory.
+type spooledFile struct {
+	file *os.File
+	hash string
+	size int64
+}
+
+func spool(r io.Reader) (*spooledFile, error) {
+	file, err := os.CreateTemp("", "vixel-upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(file, io.TeeReader(r, hasher))
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &spooledFile{file: file, hash: hex.EncodeToString(hasher.Sum(nil)), size: size}, nil
+}
+
+func spoolUpload(header *multipart.FileHeader) (*spooledFile, error) {
+	f, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return spool(f)
+}
+
+func (s *spooledFile) reader() *io.SectionReader {
+	return io.NewSectionReader(s.file, 0, s.size)
+}
+
+func (s *spooledFile) Close() error {
+	err := s.file.Close()
+	os.Remove(s.file.Name())
+	return err
+}
+
+// stripSpooled removes metadata from a spooled upload, streaming the kept
+// parts of the file into a second spool. The original spool is closed when a
+// stripped copy replaces it.
+func stripSpooled(s *spooledFile, mode string) (*spooledFile, []string, error) {
+	plan, fields, err := planStrip(s.file, s.size, mode)
+	if err != nil || len(fields) == 0 {
+		return s, nil, err
+	}
+
+	out, err := spool(plan.reader())
+	if err != nil {
+		return s, nil, err
+	}
+	s.Close()
+	return out, fields, nil
+}
@@ -0,0 +1,70 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"vixel/config"
+)
+
+type URLResolver struct {
+	storage Storage
+}
+
+func NewURLResolver(storage Storage) *URLResolver {
+	return &URLResolver{storage: storage}
+}
+
+func (r *URLResolver) Resolve(ctx context.Context, bucket, key, visibility string) (string, error) {
+	if visibility == VisibilityPublic || visibility == VisibilityUnlisted {
+		switch config.Config.StorageURLMode {
+		case "cdn":
+			return strings.TrimSuffix(config.Config.CDNBaseURL, "/") + "/" + key, nil
+		case "public":
+			if config.Config.StoragePublicRead {
+				return PublicURL(bucket, key), nil
+			}
+		}
+	}
+	return StorageFor(r.storage, bucket).PresignGet(ctx, key, config.Config.PresignTTL)
+}
+
+func StorageBucket() string {
+	if usesMinio() {
+		return config.Config.MINIOBucketName
+	}
+	return ""
+}
+
+func PublicURL(bucket, key string) string {
+	if !usesMinio() {
+		return strings.TrimSuffix(config.Config.StorageBaseURL, "/") + "/" + key
+	}
+
+	if bucket == "" {
+		bucket = config.Config.MINIOBucketName
+	}
+	protocol := "http"
+	if config.Config.MINIOUseSSL {
+		protocol = "https"
+	}
+	return fmt.Sprintf("%s://%s/%s/%s", protocol, config.Config.MINIOEndpoint, bucket, key)
+}
+
+func parseLegacyURL(raw string) (string, string, error) {
+	base := strings.TrimSuffix(config.Config.StorageBaseURL, "/") + "/"
+	if !usesMinio() && strings.HasPrefix(raw, base) && len(raw) > len(base) {
+		return "", raw[len(base):], nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("cannot derive bucket and key from %q", raw)
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,131 @@
+package image
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"vixel/config"
+)
+
+func TestURLResolver_Modes(t *testing.T) {
+	config.Config.StorageDriver = "local"
+	config.Config.StorageBaseURL = "http://localhost:8080/storage"
+	config.Config.CDNBaseURL = "https://cdn.example.com/"
+	config.Config.PresignTTL = time.Minute
+	config.Config.StoragePublicRead = true
+	defer func() {
+		config.Config.StorageURLMode = ""
+		config.Config.StoragePublicRead = false
+	}()
+
+	storage := NewMemoryStorage()
+	storage.Put(context.Background(), "key", strings.NewReader("data"), 4, "image/jpeg")
+	resolver := NewURLResolver(storage)
+
+	cases := map[string]string{
+		"public": "http://localhost:8080/storage/key",
+		"cdn":    "https://cdn.example.com/key",
+	}
+	for mode, expected := range cases {
+		config.Config.StorageURLMode = mode
+		url, err := resolver.Resolve(context.Background(), "", "key", VisibilityPublic)
+		if err != nil {
+			t.Fatalf("%s: Resolve failed: %v", mode, err)
+		}
+		if url != expected {
+			t.Errorf("%s: expected %s, got %s", mode, expected, url)
+		}
+	}
+
+	config.Config.StorageURLMode = "presigned"
+	if _, err := resolver.Resolve(context.Background(), "", "missing", VisibilityPublic); err == nil {
+		t.Error("Expected presigning a missing object to fail")
+	}
+}
+
+func TestURLResolver_PrivateImagesArePresigned(t *testing.T) {
+	config.Config.StorageDriver = "local"
+	config.Config.StorageBaseURL = "http://localhost:8080/storage"
+	config.Config.StorageURLMode = "public"
+	config.Config.StoragePublicRead = true
+	config.Config.PresignTTL = time.Minute
+	defer func() {
+		config.Config.StorageURLMode = ""
+		config.Config.StoragePublicRead = false
+	}()
+
+	storage := NewMemoryStorage()
+	storage.Put(context.Background(), "key", strings.NewReader("data"), 4, "image/jpeg")
+	resolver := NewURLResolver(storage)
+
+	for _, visibility := range []string{VisibilityPrivate, VisibilityUnlisted} {
+		url, err := resolver.Resolve(context.Background(), "", "key", visibility)
+		if err != nil {
+			t.Fatalf("%s: Resolve failed: %v", visibility, err)
+		}
+		signed := strings.Contains(url, "signature=")
+		if signed != (visibility == VisibilityPrivate) {
+			t.Errorf("%s: unexpected url %s", visibility, url)
+		}
+	}
+
+	config.Config.StoragePublicRead = false
+	url, _ := resolver.Resolve(context.Background(), "", "key", VisibilityPublic)
+	if !strings.Contains(url, "signature=") {
+		t.Errorf("Expected a signed url when the bucket is not public, got %s", url)
+	}
+}
+
+func TestParseLegacyURL(t *testing.T) {
+	config.Config.StorageDriver = "minio"
+
+	bucket, key, err := parseLegacyURL("https://minio.example.com/images/vixel-1-2")
+	if err != nil || bucket != "images" || key != "vixel-1-2" {
+		t.Errorf("Unexpected result: %q %q %v", bucket, key, err)
+	}
+
+	if _, _, err := parseLegacyURL("https://minio.example.com/"); err == nil {
+		t.Error("Expected error for URL without key")
+	}
+}
+
+// bucketedStorage keeps a memory storage per bucket and presigns as
+// "<bucket>/<key>" so tests can tell which bucket served an object.
+type bucketedStorage struct {
+	*MemoryStorage
+	name    string
+	buckets map[string]*bucketedStorage
+}
+
+func newBucketedStorage(names ...string) *bucketedStorage {
+	buckets := make(map[string]*bucketedStorage, len(names))
+	for _, name := range names {
+		buckets[name] = &bucketedStorage{MemoryStorage: NewMemoryStorage(), name: name, buckets: buckets}
+	}
+	return buckets[names[0]]
+}
+
+func (s *bucketedStorage) InBucket(bucket string) Storage {
+	return s.buckets[bucket]
+}
+
+func (s *bucketedStorage) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return s.name + "/" + key, nil
+}
+
+func TestURLResolver_PresignsFromTheRowBucket(t *testing.T) {
+	config.Config.StorageURLMode = "presigned"
+	defer func() { config.Config.StorageURLMode = "" }()
+
+	resolver := NewURLResolver(newBucketedStorage("vixel", "legacy"))
+	for bucket, expected := range map[string]string{"": "vixel/key", "vixel": "vixel/key", "legacy": "legacy/key"} {
+		url, err := resolver.Resolve(context.Background(), bucket, "key", VisibilityPrivate)
+		if err != nil {
+			t.Fatalf("%q: Resolve failed: %v", bucket, err)
+		}
+		if url != expected {
+			t.Errorf("%q: expected %s, got %s", bucket, expected, url)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package processing
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	vixelImage "vixel/domains/image"
+)
+
+type animation struct {
+	frames    []image.Image
+	delays    []int
+	loopCount int
+}
+
+// decodeAnimation returns the frames of an animated GIF composited onto the
+// full canvas, or nil if the GIF has a single frame. Frames are counted and
+// checked against the pixel budget before any of them is decoded; the GIF
+// decoder rejects frames that do not fit the canvas.
+func decodeAnimation(data []byte) (*animation, error) {
+	cfg, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	frames, err := vixelImage.CountGIFFrames(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if frames < 2 {
+		return nil, nil
+	}
+	if err := vixelImage.CheckFrames(cfg.Width, cfg.Height, frames); err != nil {
+		return nil, err
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := image.Rect(0, 0, cfg.Width, cfg.Height)
+
+	anim := &animation{delays: g.Delay, loopCount: g.LoopCount}
+	canvas := image.NewRGBA(bounds)
+	for i, frame := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		anim.frames = append(anim.frames, cloneRGBA(canvas))
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return anim, nil
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := image.NewRGBA(img.Bounds())
+	copy(clone.Pix, img.Pix)
+	return clone
+}
+
+// encode writes the frames as full-canvas GIF frames that each replace the
+// previous one, keeping the original delays and loop count.
+func (a *animation) encode(w io.Writer) error {
+	g := &gif.GIF{Delay: a.delays, LoopCount: a.loopCount}
+	for _, frame := range a.frames {
+		g.Image = append(g.Image, toPaletted(frame))
+		g.Disposal = append(g.Disposal, gif.DisposalBackground)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+// toPaletted keeps the exact colors of frames with at most 256 of them and
+// dithers anything else to the Plan 9 palette.
+func toPaletted(img image.Image) *image.Paletted {
+	bounds := img.Bounds()
+	if pal := exactPalette(img); pal != nil {
+		dst := image.NewPaletted(bounds, pal)
+		draw.Draw(dst, bounds, img, bounds.Min, draw.Src)
+		return dst
+	}
+
+	pal := color.Palette(palette.Plan9)
+	if !isOpaque(img) {
+		pal = append(pal[:255:255], color.Transparent)
+	}
+	dst := image.NewPaletted(bounds, pal)
+	draw.FloydSteinberg.Draw(dst, bounds, img, bounds.Min)
+	return dst
+}
+
+func exactPalette(img image.Image) color.Palette {
+	bounds := img.Bounds()
+	seen := map[color.RGBA]bool{}
+	var pal color.Palette
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			if c.A != 0xff && c.A != 0 {
+				return nil
+			}
+			if c.A == 0 {
+				c = color.RGBA{}
+			}
+			if !seen[c] {
+				if len(pal) == 256 {
+					return nil
+				}
+				seen[c] = true
+				pal = append(pal, c)
+			}
+		}
+	}
+	return pal
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+package processing
+
+import "time"
+
+type JobResponse struct {
+	ID         uint       `json:"id"`
+	ImageID    uint       `json:"image_id"`
+	Status     string     `json:"status"`
+	Progress   int        `json:"progress"`
+	Error      string     `json:"error,omitempty"`
+	VersionID  *uint      `json:"version_id,omitempty"`
+	ResultURL  string     `json:"result_url,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,56 @@
+package processing
+
+import (
+	"context"
+	"time"
+	"vixel/domains/image"
+
+	"gorm.io/gorm"
+)
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+	JobStatusCanceled  = "canceled"
+)
+
+type Job struct {
+	gorm.Model
+	ImageID       uint   `gorm:"not null;index"`
+	UserID        uint   `gorm:"not null;index"`
+	Status        string `gorm:"not null;index"`
+	Progress      int
+	Error         string
+	BaseVersionID *uint
+	VersionID     *uint
+	Version       *image.ImageVersion `gorm:"foreignKey:VersionID"`
+	Operations    string              `gorm:"type:json"`
+	Attempts      int
+	StartedAt     *time.Time
+	HeartbeatAt   *time.Time `gorm:"index"`
+	FinishedAt    *time.Time
+}
+
+func (j Job) ToResponse(ctx context.Context, urls *image.URLResolver) (JobResponse, error) {
+	response := JobResponse{
+		ID:         j.ID,
+		ImageID:    j.ImageID,
+		Status:     j.Status,
+		Progress:   j.Progress,
+		Error:      j.Error,
+		VersionID:  j.VersionID,
+		CreatedAt:  j.CreatedAt,
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.FinishedAt,
+	}
+	if j.Version != nil {
+		url, err := urls.Resolve(ctx, j.Version.Bucket, j.Version.StorageKey, image.VisibilityPrivate)
+		if err != nil {
+			return JobResponse{}, err
+		}
+		response.ResultURL = url
+	}
+	return response, nil
+}
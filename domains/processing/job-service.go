@@ -0,0 +1,251 @@
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+	"vixel/config"
+	"vixel/domains/image"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrJobNotFound  = errors.New("job not found")
+	ErrJobFinished  = errors.New("job has already finished")
+	errJobCanceled  = errors.New("job was canceled")
+	errJobAbandoned = errors.New("job was abandoned by its worker")
+)
+
+const maxJobAttempts = 3
+
+type JobService struct {
+	db         *gorm.DB
+	processing *ProcessingService
+}
+
+func NewJobService(db *gorm.DB, processing *ProcessingService) *JobService {
+	return &JobService{db: db, processing: processing}
+}
+
+func (s *JobService) EnqueueTransform(ctx context.Context, imageID string, pipeline Pipeline) (*Job, error) {
+	res, err := s.processing.GetImage(imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	operations, err := json.Marshal(pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		ImageID:       res.ID,
+		UserID:        res.UserID,
+		Status:        JobStatusQueued,
+		BaseVersionID: res.CurrentVersionID,
+		Operations:    string(operations),
+	}
+	if err := s.db.WithContext(ctx).Create(job).Error; err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (s *JobService) GetJob(id uint) (*Job, error) {
+	var job Job
+	if err := s.db.Preload("Version").First(&job, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *JobService) CancelJob(id uint) (*Job, error) {
+	result := s.db.Model(&Job{}).
+		Where("id = ? AND status IN ?", id, []string{JobStatusQueued, JobStatusRunning}).
+		Updates(map[string]interface{}{"status": JobStatusCanceled, "finished_at": time.Now()})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	job, err := s.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if result.RowsAffected == 0 {
+		return job, ErrJobFinished
+	}
+	return job, nil
+}
+
+func (s *JobService) ReleaseImages(ctx context.Context, imageIDs []uint) error {
+	return s.db.WithContext(ctx).Unscoped().Where("image_id IN ?", imageIDs).Delete(&Job{}).Error
+}
+
+// ClaimNext claims the oldest queued job. Running jobs whose heartbeat is
+// older than the lease belong to a worker that died and are claimed again,
+// until they run out of attempts.
+func (s *JobService) ClaimNext() (*Job, error) {
+	lease := config.Config.JobLease
+	expired := time.Now().Add(-lease)
+	if lease > 0 {
+		if err := s.db.Model(&Job{}).
+			Where("status = ? AND COALESCE(heartbeat_at, started_at) < ? AND attempts >= ?", JobStatusRunning, expired, maxJobAttempts).
+			Updates(map[string]interface{}{"status": JobStatusFailed, "error": errJobAbandoned.Error(), "finished_at": time.Now()}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var job Job
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		claimable := tx.Where("status = ?", JobStatusQueued)
+		if lease > 0 {
+			claimable = claimable.Or("status = ? AND COALESCE(heartbeat_at, started_at) < ?", JobStatusRunning, expired)
+		}
+
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where(claimable).
+			Order("id").
+			Limit(1).
+			Find(&job)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		now := time.Now()
+		job.Status = JobStatusRunning
+		job.Attempts++
+		job.StartedAt = &now
+		job.HeartbeatAt = &now
+		return tx.Model(&job).Updates(map[string]interface{}{
+			"status":       JobStatusRunning,
+			"attempts":     job.Attempts,
+			"started_at":   now,
+			"heartbeat_at": now,
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *JobService) Process(ctx context.Context, job *Job) error {
+	stop := s.heartbeat(ctx, job)
+	defer stop()
+
+	var pipeline Pipeline
+	if err := json.Unmarshal([]byte(job.Operations), &pipeline); err != nil {
+		return s.fail(job, err)
+	}
+
+	res, err := s.processing.GetImage(strconv.FormatUint(uint64(job.ImageID), 10))
+	if err != nil {
+		return s.fail(job, err)
+	}
+	base, err := s.processing.getVersion(res.ID, job.BaseVersionID)
+	if err != nil {
+		return s.fail(job, err)
+	}
+
+	_, err = s.processing.transform(ctx, res, base, pipeline, func(done, total int) error {
+		return s.updateProgress(job, done*90/total)
+	}, func(tx *gorm.DB, version *image.ImageVersion) error {
+		return s.complete(tx, job, version)
+	})
+	if errors.Is(err, errJobCanceled) {
+		return nil
+	}
+	if err != nil {
+		return s.fail(job, err)
+	}
+	return nil
+}
+
+// complete marks the job as succeeded in the transaction that records its
+// version. The job row is locked and must still belong to this attempt
+// without a version, so a canceled job or a second run of a reclaimed job
+// never moves the image to a new version.
+func (s *JobService) complete(tx *gorm.DB, job *Job, version *image.ImageVersion) error {
+	var current Job
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND status = ? AND attempts = ? AND version_id IS NULL", job.ID, JobStatusRunning, job.Attempts).
+		First(&current).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errJobCanceled
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&current).Updates(map[string]interface{}{
+		"status":      JobStatusSucceeded,
+		"progress":    100,
+		"version_id":  version.ID,
+		"finished_at": time.Now(),
+	}).Error
+}
+
+// running scopes an update to the job while this worker still owns it, so a
+// canceled or reclaimed job is never overwritten.
+func (s *JobService) running(job *Job) *gorm.DB {
+	return s.db.Model(&Job{}).Where("id = ? AND status = ? AND attempts = ?", job.ID, JobStatusRunning, job.Attempts)
+}
+
+// heartbeat keeps the lease on the job alive until the returned function is
+// called.
+func (s *JobService) heartbeat(ctx context.Context, job *Job) func() {
+	lease := config.Config.JobLease
+	if lease <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.running(job).Update("heartbeat_at", time.Now())
+			}
+		}
+	}()
+	return cancel
+}
+
+func (s *JobService) updateProgress(job *Job, progress int) error {
+	result := s.running(job).Updates(map[string]interface{}{"progress": progress, "heartbeat_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errJobCanceled
+	}
+	return nil
+}
+
+func (s *JobService) fail(job *Job, cause error) error {
+	if err := s.running(job).Updates(map[string]interface{}{
+		"status":      JobStatusFailed,
+		"error":       cause.Error(),
+		"finished_at": time.Now(),
+	}).Error; err != nil {
+		return err
+	}
+	return cause
+}
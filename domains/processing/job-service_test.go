@@ -0,0 +1,245 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"vixel/config"
+	"vixel/domains/image"
+	"vixel/domains/user"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupJobTestDB(t *testing.T) (*gorm.DB, *image.Image) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+	db.AutoMigrate(&user.User{}, &image.Image{}, &image.StoredObject{}, &image.RenderedVariant{}, &Job{})
+
+	img := &image.Image{StorageKey: "key", UserID: 7}
+	db.Create(img)
+	return db, img
+}
+
+func TestJobService_EnqueueAndClaim(t *testing.T) {
+	db, img := setupJobTestDB(t)
+	service := NewJobService(db, NewProcessingService(db, nil))
+
+	pipeline := Pipeline{{Op: "flip", Step: &FlipDTO{Direction: "vertical"}}}
+	job, err := service.EnqueueTransform(context.Background(), "1", pipeline)
+	if err != nil {
+		t.Fatalf("EnqueueTransform failed: %v", err)
+	}
+
+	if job.Status != JobStatusQueued || job.ImageID != img.ID || job.UserID != 7 {
+		t.Errorf("Unexpected job: %+v", job)
+	}
+
+	claimed, err := service.ClaimNext()
+	if err != nil {
+		t.Fatalf("ClaimNext failed: %v", err)
+	}
+
+	if claimed == nil || claimed.ID != job.ID || claimed.Status != JobStatusRunning {
+		t.Fatalf("Expected job %d to be claimed, got %+v", job.ID, claimed)
+	}
+
+	next, err := service.ClaimNext()
+	if err != nil || next != nil {
+		t.Errorf("Expected no more jobs, got %+v, %v", next, err)
+	}
+}
+
+func TestJobService_EnqueueUnknownImage(t *testing.T) {
+	db, _ := setupJobTestDB(t)
+	service := NewJobService(db, NewProcessingService(db, nil))
+
+	_, err := service.EnqueueTransform(context.Background(), "999", nil)
+	if !errors.Is(err, ErrImageNotFound) {
+		t.Errorf("Expected ErrImageNotFound, got %v", err)
+	}
+}
+
+func TestJobService_CancelJob(t *testing.T) {
+	db, _ := setupJobTestDB(t)
+	service := NewJobService(db, NewProcessingService(db, nil))
+
+	job, _ := service.EnqueueTransform(context.Background(), "1", nil)
+	claimed, _ := service.ClaimNext()
+
+	canceled, err := service.CancelJob(job.ID)
+	if err != nil {
+		t.Fatalf("CancelJob failed: %v", err)
+	}
+
+	if canceled.Status != JobStatusCanceled {
+		t.Errorf("Expected canceled status, got %s", canceled.Status)
+	}
+
+	if err := service.updateProgress(claimed, 50); !errors.Is(err, errJobCanceled) {
+		t.Errorf("Expected running worker to observe cancellation, got %v", err)
+	}
+
+	if _, err := service.CancelJob(job.ID); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("Expected ErrJobFinished, got %v", err)
+	}
+}
+
+func TestJobService_ProcessResolvesResultURL(t *testing.T) {
+	processing, db, storage, img := setupProcessingService(t)
+	service := NewJobService(db, processing)
+	ctx := context.Background()
+
+	job, err := service.EnqueueTransform(ctx, "1", Pipeline{{Op: "flip", Step: &FlipDTO{Direction: "vertical"}}})
+	if err != nil {
+		t.Fatalf("EnqueueTransform failed: %v", err)
+	}
+	claimed, _ := service.ClaimNext()
+	if err := service.Process(ctx, claimed); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	done, err := service.GetJob(job.ID)
+	if err != nil {
+		t.Fatalf("GetJob failed: %v", err)
+	}
+	if done.Status != JobStatusSucceeded || done.Version == nil || done.Version.ImageID != img.ID {
+		t.Fatalf("Unexpected job: %+v", done)
+	}
+
+	response, err := done.ToResponse(ctx, image.NewURLResolver(storage))
+	if err != nil {
+		t.Fatalf("ToResponse failed: %v", err)
+	}
+	if !strings.HasPrefix(response.ResultURL, image.PublicURL("", done.Version.StorageKey)+"?") {
+		t.Errorf("Unexpected result url %q", response.ResultURL)
+	}
+}
+
+func TestJobService_ProcessKeepsLateCancel(t *testing.T) {
+	processing, db, _, _ := setupProcessingService(t)
+	service := NewJobService(db, processing)
+	ctx := context.Background()
+
+	job, _ := service.EnqueueTransform(ctx, "1", nil)
+	claimed, _ := service.ClaimNext()
+	service.CancelJob(job.ID)
+
+	if err := service.Process(ctx, claimed); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	done, _ := service.GetJob(job.ID)
+	if done.Status != JobStatusCanceled || done.VersionID != nil {
+		t.Errorf("Expected the job to stay canceled without a version, got %s", done.Status)
+	}
+
+	var versions int64
+	db.Model(&image.ImageVersion{}).Count(&versions)
+	res, _ := processing.GetImage("1")
+	if versions != 0 || res.CurrentVersionID != nil {
+		t.Errorf("Expected a canceled job not to create a version, got %d versions", versions)
+	}
+}
+
+func TestJobService_ReclaimedJobAppliesOnce(t *testing.T) {
+	processing, db, _, _ := setupProcessingService(t)
+	service := NewJobService(db, processing)
+	ctx := context.Background()
+	previous := *config.Config
+	t.Cleanup(func() { *config.Config = previous })
+	config.Config.JobLease = time.Minute
+
+	job, _ := service.EnqueueTransform(ctx, "1", Pipeline{{Op: "rotate", Step: &RotateDTO{Angle: 90}}})
+	first, _ := service.ClaimNext()
+	db.Model(&Job{}).Where("id = ?", job.ID).Update("heartbeat_at", time.Now().Add(-2*config.Config.JobLease))
+	second, _ := service.ClaimNext()
+	if second == nil {
+		t.Fatal("Expected the expired job to be reclaimed")
+	}
+
+	if err := service.Process(ctx, second); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if err := service.Process(ctx, first); err != nil {
+		t.Fatalf("Expected the stale worker to stop quietly, got %v", err)
+	}
+
+	var versions []image.ImageVersion
+	db.Find(&versions)
+	if len(versions) != 1 || versions[0].ParentVersionID != nil {
+		t.Fatalf("Expected a single version derived from the original, got %+v", versions)
+	}
+	done, _ := service.GetJob(job.ID)
+	if done.Status != JobStatusSucceeded || done.VersionID == nil || *done.VersionID != versions[0].ID {
+		t.Errorf("Unexpected job: %+v", done)
+	}
+}
+
+func TestJobService_TransformsTheVersionItWasQueuedFor(t *testing.T) {
+	processing, db, _, _ := setupProcessingService(t)
+	service := NewJobService(db, processing)
+	ctx := context.Background()
+
+	job, _ := service.EnqueueTransform(ctx, "1", Pipeline{{Op: "flip", Step: &FlipDTO{Direction: "vertical"}}})
+	other, err := processing.TransformImage(ctx, "1", Pipeline{{Op: "flip", Step: &FlipDTO{Direction: "horizontal"}}})
+	if err != nil {
+		t.Fatalf("TransformImage failed: %v", err)
+	}
+
+	claimed, _ := service.ClaimNext()
+	if err := service.Process(ctx, claimed); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	done, _ := service.GetJob(job.ID)
+	if done.Version == nil || done.Version.ParentVersionID != nil || done.Version.ID == other.ID {
+		t.Errorf("Expected the job to transform the original it was queued for, got %+v", done.Version)
+	}
+}
+
+func TestJobService_ReclaimsExpiredLease(t *testing.T) {
+	db, _ := setupJobTestDB(t)
+	service := NewJobService(db, NewProcessingService(db, nil))
+	previous := *config.Config
+	t.Cleanup(func() { *config.Config = previous })
+	config.Config.JobLease = time.Minute
+
+	job, _ := service.EnqueueTransform(context.Background(), "1", nil)
+	first, _ := service.ClaimNext()
+
+	if next, _ := service.ClaimNext(); next != nil {
+		t.Fatalf("Expected a live lease to be kept, got %+v", next)
+	}
+
+	expire := func() {
+		db.Model(&Job{}).Where("id = ?", job.ID).Update("heartbeat_at", time.Now().Add(-2*time.Minute))
+	}
+	expire()
+	second, err := service.ClaimNext()
+	if err != nil || second == nil || second.ID != job.ID || second.Attempts != 2 {
+		t.Fatalf("Expected job %d to be reclaimed, got %+v, %v", job.ID, second, err)
+	}
+
+	if err := service.updateProgress(first, 50); !errors.Is(err, errJobCanceled) {
+		t.Errorf("Expected the previous worker to lose the job, got %v", err)
+	}
+
+	expire()
+	service.ClaimNext()
+	expire()
+	if next, _ := service.ClaimNext(); next != nil {
+		t.Fatalf("Expected no more attempts, got %+v", next)
+	}
+
+	failed, _ := service.GetJob(job.ID)
+	if failed.Status != JobStatusFailed || failed.Error != errJobAbandoned.Error() {
+		t.Errorf("Expected the abandoned job to fail, got %s (%s)", failed.Status, failed.Error)
+	}
+}
@@ -0,0 +1,54 @@
+package processing
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+type JobWorkerPool struct {
+	jobs     *JobService
+	workers  int
+	interval time.Duration
+}
+
+func NewJobWorkerPool(jobs *JobService, workers int, interval time.Duration) *JobWorkerPool {
+	return &JobWorkerPool{jobs: jobs, workers: workers, interval: interval}
+}
+
+func (p *JobWorkerPool) Start(ctx context.Context) {
+	for i := 0; i < p.workers; i++ {
+		go p.work(ctx)
+	}
+}
+
+func (p *JobWorkerPool) work(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *JobWorkerPool) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := p.jobs.ClaimNext()
+		if err != nil {
+			log.Printf("failed to claim job: %v", err)
+			return
+		}
+		if job == nil {
+			return
+		}
+
+		if err := p.jobs.Process(ctx, job); err != nil {
+			log.Printf("job %d failed: %v", job.ID, err)
+		}
+	}
+}
@@ -2,42 +2,215 @@ package processing
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"vixel/domains/image"
+	"vixel/domains/user"
+	"vixel/shared/middlewares"
 	"vixel/shared/responses"
 
 	"github.com/gin-gonic/gin"
 )
 
 type ProcessingServiceInterface interface {
-	TransformImage(ctx context.Context, imageID string, dto TransformationDTO) (string, error)
+	GetImage(imageID string) (*image.Image, error)
+	TransformImage(ctx context.Context, imageID string, pipeline Pipeline) (*image.ImageVersion, error)
+	RenderImage(ctx context.Context, imageID string, dto RenderDTO) (*RenderResult, error)
+}
+
+type JobServiceInterface interface {
+	EnqueueTransform(ctx context.Context, imageID string, pipeline Pipeline) (*Job, error)
+	GetJob(id uint) (*Job, error)
+	CancelJob(id uint) (*Job, error)
 }
 
 type ProcessingHandler struct {
 	processingService ProcessingServiceInterface
+	jobService        JobServiceInterface
+	urls              *image.URLResolver
 }
 
-func NewProcessingHandler(service ProcessingServiceInterface) *ProcessingHandler {
-	return &ProcessingHandler{processingService: service}
+func NewProcessingHandler(service ProcessingServiceInterface, jobService JobServiceInterface, urls *image.URLResolver) *ProcessingHandler {
+	return &ProcessingHandler{processingService: service, jobService: jobService, urls: urls}
 }
 
 func (h *ProcessingHandler) SetupProcessingRoutes(rg *gin.RouterGroup) {
-	rg.POST("/images/:id/transform", h.TransformImage())
+	rg.POST("/images/:id/transform", middlewares.JWTMiddleware(user.ScopeImagesTransform), middlewares.ProcessingRateLimit(), h.TransformImage())
+	rg.GET("/images/:id/render", middlewares.JWTMiddleware(user.ScopeImagesRead), middlewares.ProcessingRateLimit(), h.RenderImage())
+	rg.GET("/jobs/:id", middlewares.JWTMiddleware(user.ScopeImagesTransform), h.GetJob())
+	rg.POST("/jobs/:id/cancel", middlewares.JWTMiddleware(user.ScopeImagesTransform), h.CancelJob())
 }
 
 func (h *ProcessingHandler) TransformImage() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		var dto TransformationDTO
-		if err := ctx.ShouldBindJSON(&dto); err != nil {
-			ctx.JSON(400, gin.H{"error": "invalid request body"})
+		var req TransformationRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		pipeline, err := req.Pipeline()
+		if err != nil {
+			h.rejectPipeline(ctx, err)
 			return
 		}
 
 		imageID := ctx.Param("id")
-		newImageURL, err := h.processingService.TransformImage(ctx, imageID, dto)
+		if !h.authorize(ctx, imageID, image.CanEdit) {
+			return
+		}
+
+		if ctx.Query("async") == "true" {
+			job, err := h.jobService.EnqueueTransform(ctx, imageID, pipeline)
+			if err != nil {
+				h.handleError(ctx, err)
+				return
+			}
+
+			h.respondJob(ctx, job, responses.Accepted)
+			return
+		}
+
+		version, err := h.processingService.TransformImage(ctx, imageID, pipeline)
+		if err != nil {
+			h.handleError(ctx, err)
+			return
+		}
+
+		response, err := version.ToResponse(ctx, h.urls, image.VisibilityPrivate)
 		if err != nil {
 			responses.InternalServerError(ctx, err)
 			return
 		}
 
-		responses.Ok(ctx, gin.H{"new_image_url": newImageURL})
+		responses.Ok(ctx, gin.H{"new_image_url": response.URL, "version": response})
+	}
+}
+
+func (h *ProcessingHandler) RenderImage() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto RenderDTO
+		if err := ctx.ShouldBindQuery(&dto); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		if _, err := dto.Pipeline(); err != nil {
+			h.rejectPipeline(ctx, err)
+			return
+		}
+
+		if !h.authorize(ctx, ctx.Param("id"), image.CanView) {
+			return
+		}
+
+		result, err := h.processingService.RenderImage(ctx, ctx.Param("id"), dto)
+		if err != nil {
+			h.handleError(ctx, err)
+			return
+		}
+
+		defer result.Content.Close()
+
+		ctx.Header("Cache-Control", "private, max-age=86400")
+		image.ServeObject(ctx, result.Content, &image.ObjectInfo{ContentType: result.ContentType, ETag: result.ETag})
+	}
+}
+
+func (h *ProcessingHandler) GetJob() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		job, ok := h.findOwnJob(ctx)
+		if !ok {
+			return
+		}
+
+		h.respondJob(ctx, job, responses.Ok)
+	}
+}
+
+func (h *ProcessingHandler) CancelJob() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		job, ok := h.findOwnJob(ctx)
+		if !ok {
+			return
+		}
+
+		job, err := h.jobService.CancelJob(job.ID)
+		if err != nil {
+			h.handleError(ctx, err)
+			return
+		}
+
+		h.respondJob(ctx, job, responses.Ok)
+	}
+}
+
+func (h *ProcessingHandler) respondJob(ctx *gin.Context, job *Job, respond func(*gin.Context, interface{})) {
+	response, err := job.ToResponse(ctx, h.urls)
+	if err != nil {
+		responses.InternalServerError(ctx, err)
+		return
+	}
+	respond(ctx, response)
+}
+
+func (h *ProcessingHandler) findOwnJob(ctx *gin.Context) (*Job, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		responses.BadRequest(ctx, errors.New("invalid job id"))
+		return nil, false
+	}
+
+	job, err := h.jobService.GetJob(uint(id))
+	if err != nil {
+		h.handleError(ctx, err)
+		return nil, false
+	}
+
+	if actor := image.ActorFromContext(ctx); !actor.Owns(job.UserID) && !actor.IsAdmin() {
+		responses.Forbidden(ctx, image.ErrForbidden)
+		return nil, false
+	}
+
+	return job, true
+}
+
+func (h *ProcessingHandler) authorize(ctx *gin.Context, imageID string, allowed func(image.Actor, *image.Image) bool) bool {
+	res, err := h.processingService.GetImage(imageID)
+	if err != nil {
+		h.handleError(ctx, err)
+		return false
+	}
+
+	if !allowed(image.ActorFromContext(ctx), res) {
+		responses.Forbidden(ctx, image.ErrForbidden)
+		return false
+	}
+	return true
+}
+
+func (h *ProcessingHandler) rejectPipeline(ctx *gin.Context, err error) {
+	if image.IsLimitError(err) {
+		responses.UnprocessableEntity(ctx, err)
+		return
+	}
+	responses.BadRequest(ctx, err)
+}
+
+func (h *ProcessingHandler) handleError(ctx *gin.Context, err error) {
+	var opErr *OperationError
+	switch {
+	case errors.Is(err, ErrImageNotFound), errors.Is(err, ErrJobNotFound):
+		responses.NotFound(ctx, err)
+	case image.IsLimitError(err):
+		responses.UnprocessableEntity(ctx, err)
+	case errors.Is(err, image.ErrQuotaExceeded):
+		responses.Forbidden(ctx, err)
+	case errors.Is(err, ErrJobFinished):
+		responses.Conflict(ctx, err)
+	case errors.As(err, &opErr):
+		responses.BadRequest(ctx, err)
+	default:
+		responses.InternalServerError(ctx, err)
 	}
 }
@@ -7,31 +7,100 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
+	"vixel/config"
+	"vixel/domains/image"
 
 	"github.com/gin-gonic/gin"
 )
 
+type presignStubStorage struct {
+	*image.MemoryStorage
+}
+
+func (s presignStubStorage) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return "signed:" + key, nil
+}
+
+func newTestURLResolver() *image.URLResolver {
+	return image.NewURLResolver(presignStubStorage{image.NewMemoryStorage()})
+}
+
 type mockProcessingService struct {
 	transformResults map[string]string
+	images           map[string]*image.Image
 }
 
 func newMockProcessingService() *mockProcessingService {
 	return &mockProcessingService{
 		transformResults: make(map[string]string),
+		images:           make(map[string]*image.Image),
 	}
 }
 
-func (m *mockProcessingService) TransformImage(ctx context.Context, imageID string, dto TransformationDTO) (string, error) {
+func (m *mockProcessingService) GetImage(imageID string) (*image.Image, error) {
+	if imageID == "404" {
+		return nil, ErrImageNotFound
+	}
+	if img, ok := m.images[imageID]; ok {
+		return img, nil
+	}
+	return &image.Image{UserID: 1, Visibility: image.VisibilityPrivate}, nil
+}
+
+func (m *mockProcessingService) TransformImage(ctx context.Context, imageID string, pipeline Pipeline) (*image.ImageVersion, error) {
 	// Mock transformation result
-	url := "http://mock.com/transformed/" + imageID
-	m.transformResults[imageID] = url
-	return url, nil
+	version := &image.ImageVersion{StorageKey: "transformed/" + imageID}
+	m.transformResults[imageID] = version.StorageKey
+	return version, nil
+}
+
+func (m *mockProcessingService) RenderImage(ctx context.Context, imageID string, dto RenderDTO) (*RenderResult, error) {
+	if imageID == "404" {
+		return nil, ErrImageNotFound
+	}
+	content := readSeekNopCloser{bytes.NewReader([]byte("rendered-" + imageID))}
+	return &RenderResult{Content: content, ContentType: "image/png", ETag: `"` + imageID + `"`}, nil
+}
+
+type mockJobService struct {
+	jobs map[uint]*Job
+}
+
+func newMockJobService() *mockJobService {
+	return &mockJobService{jobs: make(map[uint]*Job)}
+}
+
+func (m *mockJobService) EnqueueTransform(ctx context.Context, imageID string, pipeline Pipeline) (*Job, error) {
+	job := &Job{ImageID: 123, UserID: 1, Status: JobStatusQueued}
+	job.ID = uint(len(m.jobs) + 1)
+	m.jobs[job.ID] = job
+	return job, nil
+}
+
+func (m *mockJobService) GetJob(id uint) (*Job, error) {
+	if job, ok := m.jobs[id]; ok {
+		return job, nil
+	}
+	return nil, ErrJobNotFound
+}
+
+func (m *mockJobService) CancelJob(id uint) (*Job, error) {
+	job, err := m.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != JobStatusQueued && job.Status != JobStatusRunning {
+		return job, ErrJobFinished
+	}
+	job.Status = JobStatusCanceled
+	return job, nil
 }
 
 func TestProcessingHandler_TransformImage(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	mockService := newMockProcessingService()
-	handler := NewProcessingHandler(mockService)
+	handler := NewProcessingHandler(mockService, newMockJobService(), newTestURLResolver())
 
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
@@ -47,6 +116,7 @@ func TestProcessingHandler_TransformImage(t *testing.T) {
 	c.Request = httptest.NewRequest("POST", "/images/123/transform", bytes.NewBuffer(jsonData))
 	c.Request.Header.Set("Content-Type", "application/json")
 	c.Params = gin.Params{{Key: "id", Value: "123"}}
+	c.Set("user_id", uint(1))
 
 	handler.TransformImage()(c)
 
@@ -69,15 +139,15 @@ func TestProcessingHandler_TransformImage(t *testing.T) {
 		t.Error("New image URL should not be empty")
 	}
 
-	if newURL != "http://mock.com/transformed/123" {
-		t.Errorf("Expected URL 'http://mock.com/transformed/123', got '%s'", newURL)
+	if newURL != "signed:transformed/123" {
+		t.Errorf("Expected URL '%s', got '%s'", "signed:transformed/123", newURL)
 	}
 }
 
 func TestProcessingHandler_TransformImage_InvalidJSON(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	mockService := newMockProcessingService()
-	handler := NewProcessingHandler(mockService)
+	handler := NewProcessingHandler(mockService, newMockJobService(), newTestURLResolver())
 
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
@@ -85,6 +155,7 @@ func TestProcessingHandler_TransformImage_InvalidJSON(t *testing.T) {
 	c.Request = httptest.NewRequest("POST", "/images/123/transform", bytes.NewBufferString("invalid json"))
 	c.Request.Header.Set("Content-Type", "application/json")
 	c.Params = gin.Params{{Key: "id", Value: "123"}}
+	c.Set("user_id", uint(1))
 
 	handler.TransformImage()(c)
 
@@ -92,3 +163,294 @@ func TestProcessingHandler_TransformImage_InvalidJSON(t *testing.T) {
 		t.Errorf("Expected status 400, got %d", w.Code)
 	}
 }
+
+func TestProcessingHandler_TransformImage_OutputTooLarge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	previous := *config.Config
+	config.Config.MaxImageDimension = 1000
+	defer func() { *config.Config = previous }()
+
+	handler := NewProcessingHandler(newMockProcessingService(), newMockJobService(), newTestURLResolver())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	body := `{"operations":[{"op":"resize","width":20000,"height":10}]}`
+	c.Request = httptest.NewRequest("POST", "/images/123/transform", bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: "123"}}
+	c.Set("user_id", uint(1))
+
+	handler.TransformImage()(c)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d", w.Code)
+	}
+}
+
+func TestProcessingHandler_TransformImage_InvalidOperation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService := newMockProcessingService()
+	handler := NewProcessingHandler(mockService, newMockJobService(), newTestURLResolver())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	body := `{"operations":[{"op":"resize","width":100},{"op":"crop","x":0,"y":0,"width":0,"height":10}]}`
+	c.Request = httptest.NewRequest("POST", "/images/123/transform", bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: "123"}}
+	c.Set("user_id", uint(1))
+
+	handler.TransformImage()(c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+
+	if !bytes.Contains(w.Body.Bytes(), []byte("operations[1]")) {
+		t.Errorf("Expected error to reference operations[1], got %s", w.Body.String())
+	}
+}
+
+func TestProcessingHandler_RenderImage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewProcessingHandler(newMockProcessingService(), newMockJobService(), newTestURLResolver())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request = httptest.NewRequest("GET", "/images/123/render?w=400&h=300&fit=cover&fmt=png", nil)
+	c.Params = gin.Params{{Key: "id", Value: "123"}}
+	c.Set("user_id", uint(1))
+
+	handler.RenderImage()(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Expected Content-Type image/png, got %s", ct)
+	}
+
+	if w.Header().Get("ETag") == "" {
+		t.Error("Expected ETag header")
+	}
+
+	if w.Body.String() != "rendered-123" {
+		t.Errorf("Unexpected body %q", w.Body.String())
+	}
+}
+
+func TestProcessingHandler_RenderImage_NotModified(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewProcessingHandler(newMockProcessingService(), newMockJobService(), newTestURLResolver())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request = httptest.NewRequest("GET", "/images/123/render?w=400", nil)
+	c.Request.Header.Set("If-None-Match", `"123"`)
+	c.Params = gin.Params{{Key: "id", Value: "123"}}
+	c.Set("user_id", uint(1))
+
+	handler.RenderImage()(c)
+	c.Writer.WriteHeaderNow()
+
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("Expected empty 304, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestProcessingHandler_RenderImage_InvalidParams(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewProcessingHandler(newMockProcessingService(), newMockJobService(), newTestURLResolver())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request = httptest.NewRequest("GET", "/images/123/render?w=400&fit=cover", nil)
+	c.Params = gin.Params{{Key: "id", Value: "123"}}
+	c.Set("user_id", uint(1))
+
+	handler.RenderImage()(c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestProcessingHandler_RenderImage_NotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewProcessingHandler(newMockProcessingService(), newMockJobService(), newTestURLResolver())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request = httptest.NewRequest("GET", "/images/404/render?w=10", nil)
+	c.Params = gin.Params{{Key: "id", Value: "404"}}
+	c.Set("user_id", uint(1))
+
+	handler.RenderImage()(c)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestProcessingHandler_TransformImage_Async(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jobService := newMockJobService()
+	handler := NewProcessingHandler(newMockProcessingService(), jobService, newTestURLResolver())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	body := `{"operations":[{"op":"resize","width":100}]}`
+	c.Request = httptest.NewRequest("POST", "/images/123/transform?async=true", bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: "123"}}
+	c.Set("user_id", uint(1))
+
+	handler.TransformImage()(c)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", w.Code)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	data, ok := response["data"].(map[string]interface{})
+	if !ok {
+		t.Fatal("Data not found in response")
+	}
+
+	if data["status"] != JobStatusQueued || data["id"].(float64) != 1 {
+		t.Errorf("Unexpected job response: %v", data)
+	}
+}
+
+func TestProcessingHandler_GetJob(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jobService := newMockJobService()
+	jobService.EnqueueTransform(context.Background(), "123", nil)
+	handler := NewProcessingHandler(newMockProcessingService(), jobService, newTestURLResolver())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request = httptest.NewRequest("GET", "/jobs/1", nil)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	c.Set("user_id", uint(1))
+
+	handler.GetJob()(c)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+}
+
+func TestProcessingHandler_GetJob_OtherUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jobService := newMockJobService()
+	jobService.EnqueueTransform(context.Background(), "123", nil)
+	handler := NewProcessingHandler(newMockProcessingService(), jobService, newTestURLResolver())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request = httptest.NewRequest("GET", "/jobs/1", nil)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	c.Set("user_id", uint(2))
+
+	handler.GetJob()(c)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+}
+
+func TestProcessingHandler_CancelJob_Finished(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jobService := newMockJobService()
+	job, _ := jobService.EnqueueTransform(context.Background(), "123", nil)
+	job.Status = JobStatusSucceeded
+	handler := NewProcessingHandler(newMockProcessingService(), jobService, newTestURLResolver())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request = httptest.NewRequest("POST", "/jobs/1/cancel", nil)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	c.Set("user_id", uint(1))
+
+	handler.CancelJob()(c)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", w.Code)
+	}
+}
+
+func TestProcessingHandler_Authorization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService := newMockProcessingService()
+	mockService.images["7"] = &image.Image{UserID: 2, Visibility: image.VisibilityPrivate}
+	mockService.images["8"] = &image.Image{UserID: 2, Visibility: image.VisibilityPublic}
+	handler := NewProcessingHandler(mockService, newMockJobService(), newTestURLResolver())
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		id      string
+		role    string
+		handler gin.HandlerFunc
+		want    int
+	}{
+		{"transform other user's image", "POST", "/images/8/transform", "8", "", handler.TransformImage(), http.StatusForbidden},
+		{"admin cannot transform other user's image", "POST", "/images/8/transform", "8", "admin", handler.TransformImage(), http.StatusForbidden},
+		{"render other user's private image", "GET", "/images/7/render?w=10", "7", "", handler.RenderImage(), http.StatusForbidden},
+		{"render other user's public image", "GET", "/images/8/render?w=10", "8", "", handler.RenderImage(), http.StatusOK},
+		{"admin renders private image", "GET", "/images/7/render?w=10", "7", "admin", handler.RenderImage(), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+
+			c.Request = httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(`{"operations":[{"op":"resize","width":10}]}`))
+			c.Request.Header.Set("Content-Type", "application/json")
+			c.Params = gin.Params{{Key: "id", Value: tt.id}}
+			c.Set("user_id", uint(1))
+			c.Set("role", tt.role)
+
+			tt.handler(c)
+
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestProcessingHandler_TransformRequiresAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewProcessingHandler(newMockProcessingService(), newMockJobService(), newTestURLResolver())
+
+	router := gin.New()
+	handler.SetupProcessingRoutes(router.Group(""))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/images/1/transform", bytes.NewBufferString(`{"operations":[{"op":"resize","width":10}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+}
@@ -0,0 +1,198 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	vixelImage "vixel/domains/image"
+	"vixel/shared/webp"
+
+	"github.com/disintegration/imaging"
+)
+
+type encodeOptions struct {
+	quality  int
+	lossless bool
+}
+
+type encoder func(w io.Writer, img image.Image, o encodeOptions) error
+
+var outputFormats = map[string]encoder{
+	"jpeg": encodeJPEG,
+	"png":  imagingEncoder(imaging.PNG),
+	"tiff": imagingEncoder(imaging.TIFF),
+	"bmp":  imagingEncoder(imaging.BMP),
+	"gif":  imagingEncoder(imaging.GIF),
+	"webp": encodeWebP,
+}
+
+func init() {
+	// Builds without cgo have no WebP encoder.
+	if !webp.Supported {
+		delete(outputFormats, "webp")
+	}
+}
+
+func imagingEncoder(format imaging.Format) encoder {
+	return func(w io.Writer, img image.Image, o encodeOptions) error {
+		return imaging.Encode(w, img, format)
+	}
+}
+
+func encodeJPEG(w io.Writer, img image.Image, o encodeOptions) error {
+	var options []imaging.EncodeOption
+	if o.quality > 0 {
+		options = append(options, imaging.JPEGQuality(o.quality))
+	}
+	return imaging.Encode(w, img, imaging.JPEG, options...)
+}
+
+func encodeWebP(w io.Writer, img image.Image, o encodeOptions) error {
+	return webp.Encode(w, img, &webp.Options{Lossless: o.lossless, Quality: o.quality})
+}
+
+type OperationError struct {
+	Index int
+	Op    string
+	Err   error
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("operations[%d] (%s): %v", e.Index, e.Op, e.Err)
+}
+
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+type Pipeline []Operation
+
+type outputSizer interface {
+	OutputSize(width, height int) (int, int)
+}
+
+type PipelineResult struct {
+	Data        []byte
+	Format      string
+	ContentType string
+	Width       int
+	Height      int
+}
+
+func (p Pipeline) Validate() error {
+	for i, op := range p {
+		if op.Step == nil {
+			return &OperationError{Index: i, Op: op.Op, Err: errors.New("unknown operation")}
+		}
+		if err := op.Step.Validate(); err != nil {
+			return &OperationError{Index: i, Op: op.Op, Err: err}
+		}
+	}
+	return nil
+}
+
+func (p Pipeline) Run(data []byte) (*PipelineResult, error) {
+	return p.RunContext(context.Background(), bytes.NewReader(data), nil)
+}
+
+// RunContext decodes the image read from r and applies the pipeline. The
+// header is checked against the pixel budget before anything else is read;
+// only then is the encoded file buffered for decoding, which keeps that
+// buffer bounded by MAX_UPLOAD_BYTES and the decoded frames by the budget.
+func (p Pipeline) RunContext(ctx context.Context, r io.Reader, onStep func(done, total int) error) (*PipelineResult, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	var header bytes.Buffer
+	if _, _, err := vixelImage.DecodeConfig(io.TeeReader(r, &header)); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.MultiReader(&header, r))
+	if err != nil {
+		return nil, err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	img = vixelImage.Orient(img, vixelImage.ExifOrientation(data))
+	if _, ok := outputFormats[format]; !ok {
+		format = "jpeg"
+	}
+
+	var anim *animation
+	if format == "gif" {
+		if anim, err = decodeAnimation(data); err != nil {
+			return nil, err
+		}
+	}
+	frames := []image.Image{img}
+	if anim != nil {
+		frames = anim.frames
+	}
+
+	var options encodeOptions
+	for i, op := range p {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		switch step := op.Step.(type) {
+		case *FormatConversionDTO:
+			if step.Format != "" {
+				format = step.Format
+			}
+			if step.Quality != nil {
+				options.quality = *step.Quality
+			}
+			options.lossless = step.Lossless
+		case *FrameDTO:
+			if step.Index >= len(frames) {
+				return nil, &OperationError{Index: i, Op: op.Op, Err: fmt.Errorf("index %d is out of range for %d frames", step.Index, len(frames))}
+			}
+			frames = frames[step.Index : step.Index+1]
+		default:
+			if sizer, ok := op.Step.(outputSizer); ok {
+				width, height := sizer.OutputSize(frames[0].Bounds().Dx(), frames[0].Bounds().Dy())
+				if err := vixelImage.CheckFrames(width, height, len(frames)); err != nil {
+					return nil, &OperationError{Index: i, Op: op.Op, Err: err}
+				}
+			}
+			for j, frame := range frames {
+				if frames[j], err = op.Step.Apply(frame); err != nil {
+					return nil, &OperationError{Index: i, Op: op.Op, Err: err}
+				}
+			}
+		}
+
+		if onStep != nil {
+			if err := onStep(i+1, len(p)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	img = frames[0]
+	buf := new(bytes.Buffer)
+	if len(frames) > 1 && format == "gif" {
+		err = (&animation{frames: frames, delays: anim.delays, loopCount: anim.loopCount}).encode(buf)
+	} else {
+		err = outputFormats[format](buf, img, options)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &PipelineResult{
+		Data:        buf.Bytes(),
+		Format:      format,
+		ContentType: vixelImage.ContentTypeForFormat(format),
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+	}, nil
+}
@@ -0,0 +1,318 @@
+package processing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+	"vixel/config"
+	vixelImage "vixel/domains/image"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestPipeline_StepsAreChained(t *testing.T) {
+	originalImg, err := createTestImage(200, 100)
+	if err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+
+	pipeline := Pipeline{
+		{Op: "resize", Step: &ResizeDTO{Width: 100, Height: 50}},
+		{Op: "crop", Step: &CropDTO{X: 0, Y: 0, Width: 80, Height: 80}},
+	}
+
+	result, err := pipeline.Run(originalImg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(result.Data))
+	if err != nil {
+		t.Fatalf("Failed to decode result image: %v", err)
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() != 80 || bounds.Dy() != 50 {
+		t.Errorf("Expected crop of the resized image (80x50), got %dx%d", bounds.Dx(), bounds.Dy())
+	}
+}
+
+func TestPipeline_FormatConversion(t *testing.T) {
+	originalImg, err := createTestImage(10, 10)
+	if err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+
+	result, err := Pipeline{{Op: "format", Step: &FormatConversionDTO{Format: "png"}}}.Run(originalImg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if result.Format != "png" || result.ContentType != "image/png" {
+		t.Errorf("Expected png output, got %s (%s)", result.Format, result.ContentType)
+	}
+}
+
+func TestPipeline_WebPConversion(t *testing.T) {
+	originalImg, err := createTestImage(30, 20)
+	if err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+
+	quality := 80
+	for _, conversion := range []*FormatConversionDTO{{Format: "webp", Quality: &quality}, {Format: "webp", Lossless: true}} {
+		result, err := Pipeline{{Op: "format", Step: conversion}}.Run(originalImg)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if result.Format != "webp" || result.ContentType != "image/webp" {
+			t.Errorf("Expected webp output, got %s (%s)", result.Format, result.ContentType)
+		}
+
+		rerun, err := Pipeline{{Op: "resize", Step: &ResizeDTO{Width: 15}}}.Run(result.Data)
+		if err != nil {
+			t.Fatalf("Failed to process webp output: %v", err)
+		}
+		if rerun.Format != "webp" || rerun.Width != 15 || rerun.Height != 10 {
+			t.Errorf("Expected 15x10 webp, got %dx%d %s", rerun.Width, rerun.Height, rerun.Format)
+		}
+	}
+}
+
+func TestFormatConversion_LosslessRequiresWebP(t *testing.T) {
+	if err := (&FormatConversionDTO{Format: "png", Lossless: true}).Validate(); err == nil {
+		t.Error("Expected lossless png to be rejected")
+	}
+}
+
+func createTestAnimation() []byte {
+	pal := color.Palette{color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}, color.RGBA{0, 255, 0, 255}}
+	background := image.NewPaletted(image.Rect(0, 0, 20, 10), pal)
+	patch := image.NewPaletted(image.Rect(10, 0, 20, 10), pal)
+	for i := range patch.Pix {
+		patch.Pix[i] = 1
+	}
+	last := image.NewPaletted(image.Rect(0, 0, 20, 10), pal)
+	for i := range last.Pix {
+		last.Pix[i] = 2
+	}
+
+	var buf bytes.Buffer
+	gif.EncodeAll(&buf, &gif.GIF{
+		Image:     []*image.Paletted{background, patch, last},
+		Delay:     []int{10, 20, 30},
+		LoopCount: 3,
+	})
+	return buf.Bytes()
+}
+
+func TestPipeline_AnimatedGIFKeepsFramesAndTiming(t *testing.T) {
+	pipeline := Pipeline{
+		{Op: "resize", Step: &ResizeDTO{Width: 10, Height: 5}},
+		{Op: "flip", Step: &FlipDTO{Direction: "horizontal"}},
+	}
+
+	result, err := pipeline.Run(createTestAnimation())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(result.Data))
+	if err != nil {
+		t.Fatalf("Failed to decode result: %v", err)
+	}
+	if len(g.Image) != 3 || g.LoopCount != 3 || g.Delay[0] != 10 || g.Delay[1] != 20 || g.Delay[2] != 30 {
+		t.Fatalf("Expected 3 frames with the original timing, got %d frames, delays %v, loop %d", len(g.Image), g.Delay, g.LoopCount)
+	}
+	if result.Width != 10 || result.Height != 5 || g.Image[1].Bounds().Dx() != 10 {
+		t.Errorf("Expected every frame resized to 10x5, got %dx%d", result.Width, result.Height)
+	}
+
+	// The second frame only covers the right half; after the flip the red
+	// background from the first frame must be on the right.
+	left := color.RGBAModel.Convert(g.Image[1].At(1, 2)).(color.RGBA)
+	right := color.RGBAModel.Convert(g.Image[1].At(8, 2)).(color.RGBA)
+	if left.B < 200 || right.R < 200 {
+		t.Errorf("Expected composited and flipped frame, got left %v right %v", left, right)
+	}
+}
+
+func TestPipeline_ExtractsFrame(t *testing.T) {
+	data := createTestAnimation()
+
+	result, err := Pipeline{{Op: "frame", Step: &FrameDTO{Index: 2}}}.Run(data)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(result.Data))
+	if err != nil || len(g.Image) != 1 {
+		t.Fatalf("Expected a single frame gif, got %v", err)
+	}
+	if c := color.RGBAModel.Convert(g.Image[0].At(0, 0)).(color.RGBA); c.G != 255 {
+		t.Errorf("Expected the third frame, got %v", c)
+	}
+
+	poster, err := Pipeline{{Op: "format", Step: &FormatConversionDTO{Format: "png"}}}.Run(data)
+	if err != nil || poster.Format != "png" {
+		t.Fatalf("Expected a png poster, got %v", err)
+	}
+
+	_, err = Pipeline{{Op: "frame", Step: &FrameDTO{Index: 3}}}.Run(data)
+	var opErr *OperationError
+	if !errors.As(err, &opErr) || opErr.Index != 0 {
+		t.Errorf("Expected an out of range error, got %v", err)
+	}
+}
+
+func TestPipeline_AnimationPixelBudget(t *testing.T) {
+	previous := config.Config.MaxImagePixels
+	config.Config.MaxImagePixels = 500
+	t.Cleanup(func() { config.Config.MaxImagePixels = previous })
+
+	if _, err := (Pipeline{}).Run(createTestAnimation()); !errors.Is(err, vixelImage.ErrPixelBudgetExceeded) {
+		t.Errorf("Expected ErrPixelBudgetExceeded for 3 frames of 200 pixels, got %v", err)
+	}
+}
+
+func TestPipeline_AppliesExifOrientation(t *testing.T) {
+	originalImg, err := createTestImage(20, 10)
+	if err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+
+	tiff := []byte("II*\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x06\x00\x00\x00\x00\x00\x00\x00")
+	segment := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(tiff)+8))
+	segment = append(append(segment, "Exif\x00\x00"...), tiff...)
+	oriented := append(append(append([]byte{}, originalImg[:2]...), segment...), originalImg[2:]...)
+
+	result, err := Pipeline{{Op: "crop", Step: &CropDTO{X: 0, Y: 0, Width: 10, Height: 15}}}.Run(oriented)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.Width != 10 || result.Height != 15 {
+		t.Errorf("Expected the crop to apply to the upright 10x20 image, got %dx%d", result.Width, result.Height)
+	}
+}
+
+func TestPipeline_ValidationErrorPointsAtIndex(t *testing.T) {
+	var req TransformationRequest
+	body := `{"operations":[{"op":"resize","width":10,"height":10},{"op":"flip","direction":"diagonal"}]}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("Failed to unmarshal request: %v", err)
+	}
+
+	_, err := req.Pipeline()
+	var opErr *OperationError
+	if !errors.As(err, &opErr) {
+		t.Fatalf("Expected OperationError, got %v", err)
+	}
+
+	if opErr.Index != 1 || opErr.Op != "flip" {
+		t.Errorf("Expected error at operations[1] (flip), got operations[%d] (%s)", opErr.Index, opErr.Op)
+	}
+}
+
+func TestPipeline_UnknownOperation(t *testing.T) {
+	var req TransformationRequest
+	if err := json.Unmarshal([]byte(`{"operations":[{"op":"blur"}]}`), &req); err != nil {
+		t.Fatalf("Failed to unmarshal request: %v", err)
+	}
+
+	_, err := req.Pipeline()
+	var opErr *OperationError
+	if !errors.As(err, &opErr) || opErr.Index != 0 {
+		t.Errorf("Expected OperationError at index 0, got %v", err)
+	}
+}
+
+func TestPipeline_LegacyRequestIsTranslated(t *testing.T) {
+	var req TransformationRequest
+	if err := json.Unmarshal([]byte(`{"resize":{"width":10,"height":10},"flip":{"direction":"vertical"}}`), &req); err != nil {
+		t.Fatalf("Failed to unmarshal request: %v", err)
+	}
+
+	pipeline, err := req.Pipeline()
+	if err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+
+	if len(pipeline) != 2 || pipeline[0].Op != "resize" || pipeline[1].Op != "flip" {
+		t.Errorf("Unexpected pipeline: %+v", pipeline)
+	}
+}
+
+func TestPipeline_RejectsMixedRequest(t *testing.T) {
+	var req TransformationRequest
+	body := `{"operations":[{"op":"flip","direction":"vertical"}],"resize":{"width":10,"height":10}}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("Failed to unmarshal request: %v", err)
+	}
+
+	if _, err := req.Pipeline(); err == nil {
+		t.Error("Expected error when mixing operations and legacy fields")
+	}
+}
+
+func TestOperation_MarshalRoundTrip(t *testing.T) {
+	op := Operation{Op: "crop", Step: &CropDTO{X: 1, Y: 2, Width: 3, Height: 4}}
+	data, err := json.Marshal(op)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var decoded Operation
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	crop, ok := decoded.Step.(*CropDTO)
+	if decoded.Op != "crop" || !ok || *crop != *op.Step.(*CropDTO) {
+		t.Errorf("Round trip mismatch: %s", data)
+	}
+}
+
+func TestPipeline_OutputDimensionCaps(t *testing.T) {
+	previous := *config.Config
+	config.Config.MaxImageDimension = 500
+	config.Config.MaxImagePixels = 0
+	defer func() { *config.Config = previous }()
+
+	tall, err := createTestImage(10, 400)
+	if err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+
+	cases := map[string]Pipeline{
+		"resize":             {{Op: "resize", Step: &ResizeDTO{Width: 600, Height: 10}}},
+		"resize keeps ratio": {{Op: "resize", Step: &ResizeDTO{Width: 20}}},
+		"rotate":             {{Op: "resize", Step: &ResizeDTO{Width: 400, Height: 400}}, {Op: "rotate", Step: &RotateDTO{Angle: 45}}},
+		"crop":               {{Op: "crop", Step: &CropDTO{Width: 501, Height: 1}}},
+	}
+	for name, pipeline := range cases {
+		_, err := pipeline.Run(tall)
+		if !errors.Is(err, vixelImage.ErrDimensionTooLarge) {
+			t.Errorf("%s: expected ErrDimensionTooLarge, got %v", name, err)
+		}
+	}
+}
+
+func TestPipeline_RejectsInputOverPixelBudget(t *testing.T) {
+	previous := *config.Config
+	config.Config.MaxImagePixels = 100
+	defer func() { *config.Config = previous }()
+
+	data, err := createTestImage(20, 20)
+	if err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+
+	_, err = Pipeline{{Op: "flip", Step: &FlipDTO{Direction: "vertical"}}}.Run(data)
+	if !errors.Is(err, vixelImage.ErrPixelBudgetExceeded) {
+		t.Errorf("Expected ErrPixelBudgetExceeded, got %v", err)
+	}
+}
@@ -3,157 +3,206 @@ package processing
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log"
 	"vixel/domains/image"
 
-	internalImg "image"
-
-	"github.com/disintegration/imaging"
-	"github.com/fogleman/gg"
 	"gorm.io/gorm"
 )
 
+var ErrImageNotFound = errors.New("image not found")
+
+type UploadServiceInterface interface {
+	OpenCurrentImage(ctx context.Context, image *image.Image) (io.ReadSeekCloser, *image.ObjectInfo, error)
+	Open(ctx context.Context, objectName string) (io.ReadSeekCloser, *image.ObjectInfo, error)
+	OpenInBucket(ctx context.Context, bucket, objectName string) (io.ReadSeekCloser, *image.ObjectInfo, error)
+	PutObject(ctx context.Context, objectName string, data []byte, contentType string) error
+	DeleteObject(ctx context.Context, objectName string) error
+}
+
 type ProcessingService struct {
 	db            *gorm.DB
-	uploadService *image.UploadService
+	uploadService UploadServiceInterface
 }
 
-func NewProcessingService(db *gorm.DB, uploadService *image.UploadService) *ProcessingService {
+func NewProcessingService(db *gorm.DB, uploadService UploadServiceInterface) *ProcessingService {
 	return &ProcessingService{db: db, uploadService: uploadService}
 }
 
-func (s *ProcessingService) TransformImage(ctx context.Context, imageID string, dto TransformationDTO) (string, error) {
+func (s *ProcessingService) GetImage(imageID string) (*image.Image, error) {
 	var res image.Image
-	if err := s.db.First(&res, "id = ?", imageID).Error; err != nil {
+	if err := s.db.Preload("CurrentVersion").First(&res, "id = ?", imageID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return "", errors.New("image not found")
+			return nil, ErrImageNotFound
 		}
-		return "", err
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (s *ProcessingService) TransformImage(ctx context.Context, imageID string, pipeline Pipeline) (*image.ImageVersion, error) {
+	res, err := s.GetImage(imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.transform(ctx, res, res.CurrentVersion, pipeline, nil, nil)
+}
+
+// getVersion loads a version of the image, or nil for the original upload.
+func (s *ProcessingService) getVersion(imageID uint, versionID *uint) (*image.ImageVersion, error) {
+	if versionID == nil {
+		return nil, nil
+	}
+	var version image.ImageVersion
+	if err := s.db.Where("id = ? AND image_id = ?", *versionID, imageID).First(&version).Error; err != nil {
+		return nil, err
 	}
+	return &version, nil
+}
 
-	img, err := s.uploadService.GetImageByUrl(ctx, res.URL)
+// transform applies the pipeline to base, or to the original upload when base
+// is nil, and makes the result the current version. commit runs inside the
+// transaction that records the version and can veto it by returning an error.
+func (s *ProcessingService) transform(ctx context.Context, res *image.Image, base *image.ImageVersion, pipeline Pipeline, onStep func(done, total int) error, commit func(tx *gorm.DB, version *image.ImageVersion) error) (*image.ImageVersion, error) {
+	bucket, key := res.Bucket, res.StorageKey
+	var parentID *uint
+	if base != nil {
+		bucket, key = base.Bucket, base.StorageKey
+		parentID = &base.ID
+	}
+	object, _, err := s.uploadService.OpenInBucket(ctx, bucket, key)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer object.Close()
 
-	transformedImg, err := applyTransformations(img, dto)
+	result, err := pipeline.RunContext(ctx, object, onStep)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	uploadedURL, err := s.uploadService.UploadImageFromBytes(ctx, transformedImg, "image/jpeg")
+	operations, err := json.Marshal(pipeline)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if err := s.uploadService.DeleteImage(ctx, res.URL); err != nil {
-		return "", err
+	hash := image.HashBytes(result.Data)
+	version := &image.ImageVersion{
+		ImageID:         res.ID,
+		ParentVersionID: parentID,
+		Operations:      string(operations),
+		Bucket:          image.StorageBucket(),
+		StorageKey:      image.ContentKey(hash, result.Format),
+		Width:           result.Width,
+		Height:          result.Height,
+		Size:            int64(len(result.Data)),
+		Format:          result.Format,
+		ContentType:     result.ContentType,
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := image.ReserveQuota(tx, res.UserID, version.Size, 1); err != nil {
+			return err
+		}
+		if err := tx.Create(version).Error; err != nil {
+			return err
+		}
+		store := func() error {
+			return s.uploadService.PutObject(ctx, version.StorageKey, result.Data, result.ContentType)
+		}
+		if err := image.RetainObject(tx, image.StoredObject{Key: version.StorageKey, ContentHash: hash, Size: version.Size}, store); err != nil {
+			return err
+		}
+		if err := tx.Model(&image.Image{}).Where("id = ?", res.ID).Update("current_version_id", version.ID).Error; err != nil {
+			return err
+		}
+		if commit != nil {
+			return commit(tx, version)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return uploadedURL, nil
+	return version, nil
 }
 
-func applyTransformations(img []byte, dto TransformationDTO) ([]byte, error) {
-	src, err := imaging.Decode(bytes.NewReader(img))
+func (s *ProcessingService) RenderImage(ctx context.Context, imageID string, dto RenderDTO) (*RenderResult, error) {
+	pipeline, err := dto.Pipeline()
 	if err != nil {
 		return nil, err
 	}
 
-	if dto.Resize != nil {
-		resized := imaging.Resize(src, dto.Resize.Width, dto.Resize.Height, imaging.Lanczos)
-		buf := new(bytes.Buffer)
-		err = imaging.Encode(buf, resized, imaging.JPEG)
-		if err != nil {
-			return nil, err
-		}
-		img = buf.Bytes()
+	res, err := s.GetImage(imageID)
+	if err != nil {
+		return nil, err
 	}
 
-	if dto.Crop != nil {
-		cropped := imaging.Crop(src, internalImg.Rect(dto.Crop.X, dto.Crop.Y, dto.Crop.X+dto.Crop.Width, dto.Crop.Y+dto.Crop.Height))
-		buf := new(bytes.Buffer)
-		err = imaging.Encode(buf, cropped, imaging.JPEG)
-		if err != nil {
-			return nil, err
-		}
-		img = buf.Bytes()
+	var versionID uint
+	if res.CurrentVersionID != nil {
+		versionID = *res.CurrentVersionID
 	}
+	key := dto.CacheKey(res.ID, versionID)
+	etag := fmt.Sprintf(`"%d-%d-%s"`, res.ID, versionID, dto.Hash())
 
-	if dto.Rotate != nil {
-		rotated := imaging.Rotate(src, dto.Rotate.Angle, internalImg.Transparent)
-		buf := new(bytes.Buffer)
-		err = imaging.Encode(buf, rotated, imaging.JPEG)
-		if err != nil {
-			return nil, err
-		}
-		img = buf.Bytes()
+	cached, info, err := s.uploadService.Open(ctx, key)
+	if err == nil {
+		return &RenderResult{Content: cached, ContentType: info.ContentType, ETag: etag}, nil
+	}
+	if !errors.Is(err, image.ErrObjectNotFound) {
+		return nil, err
 	}
 
-	if dto.Flip != nil {
-		var flipped *internalImg.NRGBA
-		if dto.Flip.Direction == "horizontal" {
-			flipped = imaging.FlipH(src)
-		} else {
-			flipped = imaging.FlipV(src)
-		}
-		buf := new(bytes.Buffer)
-		err = imaging.Encode(buf, flipped, imaging.JPEG)
-		if err != nil {
-			return nil, err
-		}
-		img = buf.Bytes()
-	}
-
-	if dto.FormatConversion != nil {
-		buf := new(bytes.Buffer)
-		var format imaging.Format
-		switch dto.FormatConversion.Format {
-		case "jpeg":
-			format = imaging.JPEG
-		case "png":
-			format = imaging.PNG
-		case "tiff":
-			format = imaging.TIFF
-		case "bmp":
-			format = imaging.BMP
-		case "gif":
-			format = imaging.GIF
-		default:
-			return nil, errors.New("unsupported format")
-		}
-		err = imaging.Encode(buf, src, format)
-		if err != nil {
-			return nil, err
-		}
-		img = buf.Bytes()
-	}
-
-	if dto.Filter != nil {
-		var filtered *internalImg.NRGBA
-		filtered = imaging.AdjustSaturation(src, float64(dto.Filter.Saturation))
-		filtered = imaging.AdjustBrightness(filtered, float64(dto.Filter.Brightness))
-		filtered = imaging.AdjustContrast(filtered, float64(dto.Filter.Contrast))
-		buf := new(bytes.Buffer)
-		err = imaging.Encode(buf, filtered, imaging.JPEG)
-		if err != nil {
-			return nil, err
-		}
-		img = buf.Bytes()
-	}
-
-	if dto.Watermark != nil {
-		position := internalImg.Pt(dto.Watermark.Position.X, dto.Watermark.Position.Y)
-		buf := new(bytes.Buffer)
-		dc := gg.NewContext(200, 50)
-		dc.SetRGBA(1, 1, 1, 0.5)
-		dc.DrawStringAnchored(dto.Watermark.Text, 100, 25, 0.5, 0.5)
-		watermark := dc.Image().(*internalImg.RGBA)
-		err = imaging.Encode(buf, imaging.Overlay(src, watermark, position, 0.5), imaging.JPEG)
-		if err != nil {
-			return nil, err
+	original, _, err := s.uploadService.OpenCurrentImage(ctx, res)
+	if err != nil {
+		return nil, err
+	}
+	defer original.Close()
+
+	result, err := pipeline.RunContext(ctx, original, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Renders that would take the owner over quota are served without being
+	// cached.
+	if err := s.cacheRender(ctx, res, key, result); err != nil && !errors.Is(err, image.ErrQuotaExceeded) {
+		return nil, err
+	}
+
+	return &RenderResult{Content: readSeekNopCloser{bytes.NewReader(result.Data)}, ContentType: result.ContentType, ETag: etag}, nil
+}
+
+// cacheRender records the render against the owner's quota before storing
+// it, so a render that does not fit is never written.
+func (s *ProcessingService) cacheRender(ctx context.Context, res *image.Image, key string, result *PipelineResult) error {
+	size := int64(len(result.Data))
+	var evicted []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := image.ReserveQuota(tx, res.UserID, size, 1); err != nil {
+			return err
 		}
-		img = buf.Bytes()
+		var err error
+		evicted, err = image.RecordRender(tx, image.RenderedVariant{Key: key, ImageID: res.ID, Size: size})
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
-	return img, nil
+	if err := s.uploadService.PutObject(ctx, key, result.Data, result.ContentType); err != nil {
+		s.db.Where("key = ?", key).Delete(&image.RenderedVariant{})
+		return err
+	}
+
+	for _, key := range evicted {
+		if err := s.uploadService.DeleteObject(ctx, key); err != nil && !errors.Is(err, image.ErrObjectNotFound) {
+			log.Printf("failed to delete render %s: %v", key, err)
+		}
+	}
+	return nil
 }
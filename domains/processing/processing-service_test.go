@@ -2,12 +2,21 @@ package processing
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	internalImg "image"
 	"image/color"
 	"image/jpeg"
+	"io"
 	"testing"
+	"time"
+	"vixel/config"
+	"vixel/domains/image"
+	"vixel/domains/user"
 
 	"github.com/disintegration/imaging"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 func createTestImage(width, height int) ([]byte, error) {
@@ -22,6 +31,20 @@ func createTestImage(width, height int) ([]byte, error) {
 	return buf.Bytes(), err
 }
 
+// applyTransformations runs a legacy transformation request through the
+// pipeline and returns the encoded result.
+func applyTransformations(img []byte, dto TransformationDTO) ([]byte, error) {
+	pipeline, err := TransformationRequest{TransformationDTO: dto}.Pipeline()
+	if err != nil {
+		return nil, err
+	}
+	result, err := pipeline.Run(img)
+	if err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
 func TestApplyTransformations_Resize(t *testing.T) {
 	originalImg, err := createTestImage(100, 100)
 	if err != nil {
@@ -242,6 +265,7 @@ func TestApplyTransformations_Watermark(t *testing.T) {
 		t.Fatalf("Failed to create test image: %v", err)
 	}
 
+	opacity := 50
 	dto := TransformationDTO{
 		Watermark: &WatermarkDTO{
 			Text: "Test",
@@ -249,7 +273,7 @@ func TestApplyTransformations_Watermark(t *testing.T) {
 				X: 10,
 				Y: 10,
 			},
-			Opacity: 50,
+			Opacity: &opacity,
 		},
 	}
 
@@ -262,3 +286,227 @@ func TestApplyTransformations_Watermark(t *testing.T) {
 		t.Error("Expected non-empty result")
 	}
 }
+
+func TestWatermarkDTO_DefaultOpacity(t *testing.T) {
+	img := internalImg.NewNRGBA(internalImg.Rect(0, 0, 200, 50))
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+
+	withDefault, _ := (&WatermarkDTO{Text: "Test"}).Apply(img)
+	half := 50
+	explicit, _ := (&WatermarkDTO{Text: "Test", Opacity: &half}).Apply(img)
+
+	if !bytes.Equal(imaging.Clone(withDefault).Pix, imaging.Clone(explicit).Pix) {
+		t.Error("Expected an unset opacity to default to 50")
+	}
+	if bytes.Equal(imaging.Clone(withDefault).Pix, img.Pix) {
+		t.Error("Expected the watermark to be visible")
+	}
+}
+
+func TestFormatConversion_QualityRange(t *testing.T) {
+	for _, quality := range []int{0, 101} {
+		if err := (&FormatConversionDTO{Format: "jpeg", Quality: &quality}).Validate(); err == nil {
+			t.Errorf("Expected quality %d to be rejected", quality)
+		}
+	}
+}
+
+func TestFormatConversion_QualityRequiresLossyFormat(t *testing.T) {
+	quality := 80
+	for _, format := range []string{"png", "gif", "bmp", "tiff"} {
+		if err := (&FormatConversionDTO{Format: format, Quality: &quality}).Validate(); err == nil {
+			t.Errorf("Expected quality to be rejected for %s", format)
+		}
+	}
+	for _, format := range []string{"jpeg", "webp"} {
+		if err := (&FormatConversionDTO{Format: format, Quality: &quality}).Validate(); err != nil {
+			t.Errorf("Expected quality to be accepted for %s, got %v", format, err)
+		}
+	}
+}
+
+func setupProcessingService(t *testing.T) (*ProcessingService, *gorm.DB, *image.MemoryStorage, *image.Image) {
+	config.Config.StorageDriver = "memory"
+	config.Config.StorageBaseURL = "http://localhost/storage"
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+	db.AutoMigrate(&user.User{}, &image.Image{}, &image.ImageVersion{}, &image.StoredObject{}, &image.RenderedVariant{}, &Job{})
+
+	storage := image.NewMemoryStorage()
+	uploadService := image.NewUploadService(storage)
+
+	data, _ := createTestImage(100, 50)
+	key, err := uploadService.UploadImageFromBytes(context.Background(), data, "jpeg")
+	if err != nil {
+		t.Fatalf("Failed to upload test image: %v", err)
+	}
+
+	img := &image.Image{StorageKey: key, UserID: 1}
+	db.Create(img)
+
+	return NewProcessingService(db, uploadService), db, storage, img
+}
+
+func TestProcessingService_TransformImage_CreatesVersions(t *testing.T) {
+	service, db, storage, img := setupProcessingService(t)
+	ctx := context.Background()
+
+	first, err := service.TransformImage(ctx, "1", Pipeline{{Op: "resize", Step: &ResizeDTO{Width: 50, Height: 25}}})
+	if err != nil {
+		t.Fatalf("TransformImage failed: %v", err)
+	}
+
+	if first.ParentVersionID != nil || first.Width != 50 || first.Height != 25 {
+		t.Errorf("Unexpected first version: %+v", first)
+	}
+
+	second, err := service.TransformImage(ctx, "1", Pipeline{{Op: "rotate", Step: &RotateDTO{Angle: 90}}})
+	if err != nil {
+		t.Fatalf("TransformImage failed: %v", err)
+	}
+
+	if second.ParentVersionID == nil || *second.ParentVersionID != first.ID {
+		t.Error("Second version should be derived from the first")
+	}
+
+	if second.Width != 25 || second.Height != 50 {
+		t.Errorf("Expected rotated 25x50 version, got %dx%d", second.Width, second.Height)
+	}
+
+	var updated image.Image
+	db.First(&updated, img.ID)
+	if updated.CurrentVersionID == nil || *updated.CurrentVersionID != second.ID {
+		t.Error("Image should point at the latest version")
+	}
+
+	if updated.StorageKey != img.StorageKey {
+		t.Error("Original object key should not change")
+	}
+
+	objects, _ := storage.List(ctx, "")
+	if len(objects) != 3 {
+		t.Errorf("Expected the original and two versions to be stored, got %d objects", len(objects))
+	}
+}
+
+func TestProcessingService_TransformImage_QuotaExceeded(t *testing.T) {
+	service, db, storage, _ := setupProcessingService(t)
+	previous := *config.Config
+	t.Cleanup(func() { *config.Config = previous })
+	config.Config.StorageQuotaObjects = 1
+	ctx := context.Background()
+
+	_, err := service.TransformImage(ctx, "1", Pipeline{{Op: "flip", Step: &FlipDTO{Direction: "vertical"}}})
+	if !errors.Is(err, image.ErrQuotaExceeded) {
+		t.Fatalf("Expected ErrQuotaExceeded, got %v", err)
+	}
+
+	var count int64
+	db.Model(&image.ImageVersion{}).Count(&count)
+	objects, _ := storage.List(ctx, "")
+	if count != 0 || len(objects) != 1 {
+		t.Errorf("Nothing should be stored over quota, got %d versions and %d objects", count, len(objects))
+	}
+}
+
+func TestProcessingService_RenderImage_UsesCache(t *testing.T) {
+	service, _, storage, _ := setupProcessingService(t)
+	ctx := context.Background()
+	dto := RenderDTO{Width: 20, Height: 20, Fit: "cover", Format: "png"}
+
+	first, err := service.RenderImage(ctx, "1", dto)
+	if err != nil {
+		t.Fatalf("RenderImage failed: %v", err)
+	}
+
+	if first.ContentType != "image/png" {
+		t.Errorf("Expected image/png, got %s", first.ContentType)
+	}
+
+	if _, err := storage.Stat(ctx, dto.CacheKey(1, 0)); err != nil {
+		t.Fatalf("Expected rendered variant to be cached: %v", err)
+	}
+
+	storage.Put(ctx, dto.CacheKey(1, 0), bytes.NewReader([]byte("cached")), 6, "image/png")
+
+	second, err := service.RenderImage(ctx, "1", dto)
+	if err != nil {
+		t.Fatalf("RenderImage failed: %v", err)
+	}
+
+	cached, _ := io.ReadAll(second.Content)
+	if string(cached) != "cached" || second.ETag != first.ETag {
+		t.Error("Expected second render to be served from the cache")
+	}
+}
+
+func TestProcessingService_RenderImage_NotFound(t *testing.T) {
+	service, _, _, _ := setupProcessingService(t)
+
+	if _, err := service.RenderImage(context.Background(), "42", RenderDTO{Width: 10}); err != ErrImageNotFound {
+		t.Errorf("Expected ErrImageNotFound, got %v", err)
+	}
+}
+
+func TestProcessingService_RenderImage_CountsTowardsQuota(t *testing.T) {
+	service, db, storage, img := setupProcessingService(t)
+	previous := *config.Config
+	t.Cleanup(func() { *config.Config = previous })
+	ctx := context.Background()
+	db.Create(&user.User{Model: gorm.Model{ID: img.UserID}, Username: "owner", Email: "owner@example.com"})
+
+	if _, err := service.RenderImage(ctx, "1", RenderDTO{Width: 20}); err != nil {
+		t.Fatalf("RenderImage failed: %v", err)
+	}
+	usage, err := image.GetUsage(db, img.UserID)
+	if err != nil {
+		t.Fatalf("GetUsage failed: %v", err)
+	}
+	if usage.ObjectsUsed != 2 {
+		t.Errorf("Expected the original and the cached render to count, got %d objects", usage.ObjectsUsed)
+	}
+
+	config.Config.StorageQuotaObjects = 2
+	result, err := service.RenderImage(ctx, "1", RenderDTO{Width: 30})
+	if err != nil {
+		t.Fatalf("Expected renders over quota to still be served, got %v", err)
+	}
+	if result.ContentType == "" {
+		t.Error("Expected a rendered image")
+	}
+	if _, err := storage.Stat(ctx, RenderDTO{Width: 30}.CacheKey(1, 0)); !errors.Is(err, image.ErrObjectNotFound) {
+		t.Errorf("Expected the render over quota not to be cached, got %v", err)
+	}
+}
+
+func TestProcessingService_RenderImage_EvictsOldestRenders(t *testing.T) {
+	service, db, storage, _ := setupProcessingService(t)
+	previous := *config.Config
+	t.Cleanup(func() { *config.Config = previous })
+	config.Config.RenderCacheLimit = 2
+	ctx := context.Background()
+
+	for _, width := range []int{10, 20, 30} {
+		if _, err := service.RenderImage(ctx, "1", RenderDTO{Width: width}); err != nil {
+			t.Fatalf("RenderImage failed: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var count int64
+	db.Model(&image.RenderedVariant{}).Count(&count)
+	if count != 2 {
+		t.Errorf("Expected 2 cached renders, got %d", count)
+	}
+	if _, err := storage.Stat(ctx, RenderDTO{Width: 10}.CacheKey(1, 0)); !errors.Is(err, image.ErrObjectNotFound) {
+		t.Errorf("Expected the oldest render to be evicted, got %v", err)
+	}
+	if _, err := storage.Stat(ctx, RenderDTO{Width: 30}.CacheKey(1, 0)); err != nil {
+		t.Errorf("Expected the newest render to be cached: %v", err)
+	}
+}
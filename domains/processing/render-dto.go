@@ -0,0 +1,87 @@
+package processing
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+)
+
+type RenderDTO struct {
+	Width   int    `form:"w" binding:"min=0"`
+	Height  int    `form:"h" binding:"min=0"`
+	Fit     string `form:"fit" binding:"omitempty,oneof=fill cover contain"`
+	Format  string `form:"fmt"`
+	Quality int    `form:"q" binding:"min=0,max=100"`
+	Frame   *int   `form:"frame" binding:"omitempty,min=0"`
+}
+
+func (d RenderDTO) Pipeline() (Pipeline, error) {
+	var pipeline Pipeline
+	if d.Frame != nil {
+		pipeline = append(pipeline, Operation{Op: "frame", Step: &FrameDTO{Index: *d.Frame}})
+	}
+	if d.Width > 0 || d.Height > 0 {
+		pipeline = append(pipeline, Operation{Op: "resize", Step: &ResizeDTO{Width: d.Width, Height: d.Height, Fit: d.Fit}})
+	}
+	if d.Format != "" || d.Quality > 0 {
+		conversion := &FormatConversionDTO{Format: d.Format}
+		if d.Quality > 0 {
+			conversion.Quality = &d.Quality
+		}
+		pipeline = append(pipeline, Operation{Op: "format", Step: conversion})
+	}
+	if err := pipeline.Validate(); err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+func (d RenderDTO) canonical() string {
+	values := url.Values{}
+	if d.Frame != nil {
+		values.Set("frame", strconv.Itoa(*d.Frame))
+	}
+	if d.Width > 0 {
+		values.Set("w", strconv.Itoa(d.Width))
+	}
+	if d.Height > 0 {
+		values.Set("h", strconv.Itoa(d.Height))
+	}
+	if d.Fit != "" && d.Fit != "fill" && (d.Width > 0 || d.Height > 0) {
+		values.Set("fit", d.Fit)
+	}
+	if d.Format != "" {
+		values.Set("fmt", d.Format)
+	}
+	if d.Quality > 0 {
+		values.Set("q", strconv.Itoa(d.Quality))
+	}
+	return values.Encode()
+}
+
+func (d RenderDTO) Hash() string {
+	sum := sha256.Sum256([]byte(d.canonical()))
+	return hex.EncodeToString(sum[:])
+}
+
+func (d RenderDTO) CacheKey(imageID, versionID uint) string {
+	return fmt.Sprintf("renders/%d/v%d/%s", imageID, versionID, d.Hash())
+}
+
+type RenderResult struct {
+	Content     io.ReadSeekCloser
+	ContentType string
+	ETag        string
+}
+
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekNopCloser) Close() error {
+	return nil
+}
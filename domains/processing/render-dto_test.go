@@ -0,0 +1,58 @@
+package processing
+
+import "testing"
+
+func TestRenderDTO_CacheKeyIsCanonical(t *testing.T) {
+	a := RenderDTO{Width: 400, Height: 300, Fit: "fill", Format: "png"}
+	b := RenderDTO{Format: "png", Height: 300, Width: 400}
+
+	if a.CacheKey(1, 0) != b.CacheKey(1, 0) {
+		t.Error("Equivalent parameters should produce the same cache key")
+	}
+
+	if a.CacheKey(1, 0) == a.CacheKey(2, 0) {
+		t.Error("Different images should produce different cache keys")
+	}
+
+	if a.CacheKey(1, 0) == a.CacheKey(1, 3) {
+		t.Error("Different versions should produce different cache keys")
+	}
+
+	c := RenderDTO{Width: 400, Height: 300, Fit: "cover", Format: "png"}
+	if a.CacheKey(1, 0) == c.CacheKey(1, 0) {
+		t.Error("Different parameters should produce different cache keys")
+	}
+}
+
+func TestRenderDTO_Pipeline(t *testing.T) {
+	pipeline, err := RenderDTO{Width: 100, Fit: "contain"}.Pipeline()
+	if err == nil {
+		t.Errorf("Expected error for contain without height, got pipeline %+v", pipeline)
+	}
+
+	pipeline, err = RenderDTO{Width: 100, Height: 50, Quality: 80}.Pipeline()
+	if err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+
+	if len(pipeline) != 2 || pipeline[0].Op != "resize" || pipeline[1].Op != "format" {
+		t.Errorf("Unexpected pipeline: %+v", pipeline)
+	}
+}
+
+func TestRenderDTO_Frame(t *testing.T) {
+	poster := 0
+	d := RenderDTO{Width: 100, Frame: &poster}
+
+	pipeline, err := d.Pipeline()
+	if err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+	if len(pipeline) != 2 || pipeline[0].Op != "frame" || pipeline[1].Op != "resize" {
+		t.Errorf("Expected the frame to be selected before resizing, got %+v", pipeline)
+	}
+
+	if d.CacheKey(1, 0) == (RenderDTO{Width: 100}).CacheKey(1, 0) {
+		t.Error("Selecting a frame should change the cache key")
+	}
+}
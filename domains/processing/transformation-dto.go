@@ -1,5 +1,101 @@
 package processing
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	vixelImage "vixel/domains/image"
+
+	"github.com/disintegration/imaging"
+	"github.com/fogleman/gg"
+)
+
+type Step interface {
+	Validate() error
+	Apply(img image.Image) (image.Image, error)
+}
+
+type Operation struct {
+	Op   string
+	Step Step
+}
+
+func newStep(op string) Step {
+	switch op {
+	case "resize":
+		return &ResizeDTO{}
+	case "crop":
+		return &CropDTO{}
+	case "rotate":
+		return &RotateDTO{}
+	case "flip":
+		return &FlipDTO{}
+	case "watermark":
+		return &WatermarkDTO{}
+	case "format":
+		return &FormatConversionDTO{}
+	case "filter":
+		return &FilterDTO{}
+	case "frame":
+		return &FrameDTO{}
+	}
+	return nil
+}
+
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	var head struct {
+		Op string `json:"op"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return err
+	}
+
+	o.Op = head.Op
+	o.Step = newStep(head.Op)
+	if o.Step == nil {
+		return nil
+	}
+	return json.Unmarshal(data, o.Step)
+}
+
+func (o Operation) MarshalJSON() ([]byte, error) {
+	fields := map[string]interface{}{}
+	if o.Step != nil {
+		raw, err := json.Marshal(o.Step)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, err
+		}
+	}
+	fields["op"] = o.Op
+	return json.Marshal(fields)
+}
+
+type TransformationRequest struct {
+	Operations []Operation `json:"operations,omitempty"`
+	TransformationDTO
+}
+
+func (r TransformationRequest) Pipeline() (Pipeline, error) {
+	legacy := r.TransformationDTO.ToOperations()
+	if len(r.Operations) > 0 && len(legacy) > 0 {
+		return nil, errors.New("operations cannot be combined with legacy transformation fields")
+	}
+
+	pipeline := Pipeline(r.Operations)
+	if len(r.Operations) == 0 {
+		pipeline = Pipeline(legacy)
+	}
+	if err := pipeline.Validate(); err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
 type TransformationDTO struct {
 	Resize           *ResizeDTO           `json:"resize,omitempty"`
 	Crop             *CropDTO             `json:"crop,omitempty"`
@@ -10,43 +106,243 @@ type TransformationDTO struct {
 	Filter           *FilterDTO           `json:"filter,omitempty"`
 }
 
+func (d TransformationDTO) ToOperations() []Operation {
+	var ops []Operation
+	if d.Resize != nil {
+		ops = append(ops, Operation{Op: "resize", Step: d.Resize})
+	}
+	if d.Crop != nil {
+		ops = append(ops, Operation{Op: "crop", Step: d.Crop})
+	}
+	if d.Rotate != nil {
+		ops = append(ops, Operation{Op: "rotate", Step: d.Rotate})
+	}
+	if d.Flip != nil {
+		ops = append(ops, Operation{Op: "flip", Step: d.Flip})
+	}
+	if d.FormatConversion != nil {
+		ops = append(ops, Operation{Op: "format", Step: d.FormatConversion})
+	}
+	if d.Filter != nil {
+		ops = append(ops, Operation{Op: "filter", Step: d.Filter})
+	}
+	if d.Watermark != nil {
+		ops = append(ops, Operation{Op: "watermark", Step: d.Watermark})
+	}
+	return ops
+}
+
 type ResizeDTO struct {
-	Width  int `json:"width" binding:"required,min=1"`
-	Height int `json:"height" binding:"required,min=1"`
+	Width  int    `json:"width" binding:"min=0"`
+	Height int    `json:"height" binding:"min=0"`
+	Fit    string `json:"fit,omitempty" binding:"omitempty,oneof=fill cover contain"`
+}
+
+func (d *ResizeDTO) Validate() error {
+	if d.Width < 0 || d.Height < 0 {
+		return errors.New("width and height must not be negative")
+	}
+	if d.Width == 0 && d.Height == 0 {
+		return errors.New("width or height is required")
+	}
+	if err := vixelImage.CheckDimensions(d.Width, d.Height); err != nil {
+		return err
+	}
+	switch d.Fit {
+	case "", "fill":
+	case "cover", "contain":
+		if d.Width == 0 || d.Height == 0 {
+			return fmt.Errorf("fit %q requires both width and height", d.Fit)
+		}
+	default:
+		return errors.New("fit must be fill, cover or contain")
+	}
+	return nil
+}
+
+func (d *ResizeDTO) OutputSize(width, height int) (int, int) {
+	switch {
+	case d.Fit == "cover" || d.Fit == "contain":
+		return d.Width, d.Height
+	case d.Width == 0:
+		return int(math.Max(1, math.Floor(float64(d.Height)*float64(width)/float64(height)+0.5))), d.Height
+	case d.Height == 0:
+		return d.Width, int(math.Max(1, math.Floor(float64(d.Width)*float64(height)/float64(width)+0.5)))
+	}
+	return d.Width, d.Height
+}
+
+func (d *ResizeDTO) Apply(img image.Image) (image.Image, error) {
+	switch d.Fit {
+	case "cover":
+		return imaging.Fill(img, d.Width, d.Height, imaging.Center, imaging.Lanczos), nil
+	case "contain":
+		return imaging.Fit(img, d.Width, d.Height, imaging.Lanczos), nil
+	}
+	return imaging.Resize(img, d.Width, d.Height, imaging.Lanczos), nil
 }
 
 type CropDTO struct {
-	X      int `json:"x" binding:"required,min=0"`
-	Y      int `json:"y" binding:"required,min=0"`
+	X      int `json:"x" binding:"min=0"`
+	Y      int `json:"y" binding:"min=0"`
 	Width  int `json:"width" binding:"required,min=1"`
 	Height int `json:"height" binding:"required,min=1"`
 }
 
+func (d *CropDTO) Validate() error {
+	if d.X < 0 || d.Y < 0 {
+		return errors.New("x and y must not be negative")
+	}
+	if d.Width < 1 || d.Height < 1 {
+		return errors.New("width and height must be at least 1")
+	}
+	return vixelImage.CheckDimensions(d.Width, d.Height)
+}
+
+func (d *CropDTO) Apply(img image.Image) (image.Image, error) {
+	bounds := img.Bounds()
+	rect := image.Rect(d.X, d.Y, d.X+d.Width, d.Y+d.Height).Add(bounds.Min)
+	if rect.Intersect(bounds).Empty() {
+		return nil, fmt.Errorf("crop area is outside the %dx%d image", bounds.Dx(), bounds.Dy())
+	}
+	return imaging.Crop(img, rect), nil
+}
+
 type RotateDTO struct {
-	Angle float64 `json:"angle" binding:"required"`
+	Angle float64 `json:"angle"`
+}
+
+func (d *RotateDTO) Validate() error {
+	if math.IsNaN(d.Angle) || math.IsInf(d.Angle, 0) {
+		return errors.New("angle must be a finite number")
+	}
+	return nil
+}
+
+func (d *RotateDTO) OutputSize(width, height int) (int, int) {
+	angle := d.Angle * math.Pi / 180
+	sin, cos := math.Abs(math.Sin(angle)), math.Abs(math.Cos(angle))
+	return int(math.Ceil(float64(width)*cos + float64(height)*sin)), int(math.Ceil(float64(width)*sin + float64(height)*cos))
+}
+
+func (d *RotateDTO) Apply(img image.Image) (image.Image, error) {
+	return imaging.Rotate(img, d.Angle, image.Transparent), nil
 }
 
 type FlipDTO struct {
 	Direction string `json:"direction" binding:"required,oneof=horizontal vertical"`
 }
 
+func (d *FlipDTO) Validate() error {
+	if d.Direction != "horizontal" && d.Direction != "vertical" {
+		return errors.New("direction must be horizontal or vertical")
+	}
+	return nil
+}
+
+func (d *FlipDTO) Apply(img image.Image) (image.Image, error) {
+	if d.Direction == "horizontal" {
+		return imaging.FlipH(img), nil
+	}
+	return imaging.FlipV(img), nil
+}
+
 type WatermarkDTO struct {
 	Text     string `json:"text" binding:"required"`
-	Position Point  `json:"position" binding:"required,oneof=top-left top-right bottom-left bottom-right center"`
-	Opacity  int    `json:"opacity" binding:"required,min=0,max=100"`
+	Position Point  `json:"position"`
+	Opacity  *int   `json:"opacity,omitempty" binding:"omitempty,min=0,max=100"`
 }
 
+const defaultOpacity = 50
+
 type Point struct {
-	X int `json:"x" binding:"required"`
-	Y int `json:"y" binding:"required"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+func (d *WatermarkDTO) Validate() error {
+	if d.Text == "" {
+		return errors.New("text is required")
+	}
+	if d.Opacity != nil && (*d.Opacity < 0 || *d.Opacity > 100) {
+		return errors.New("opacity must be between 0 and 100")
+	}
+	return nil
+}
+
+func (d *WatermarkDTO) Apply(img image.Image) (image.Image, error) {
+	dc := gg.NewContext(200, 50)
+	dc.SetRGBA(1, 1, 1, 1)
+	dc.DrawStringAnchored(d.Text, 100, 25, 0.5, 0.5)
+	opacity := defaultOpacity
+	if d.Opacity != nil {
+		opacity = *d.Opacity
+	}
+	position := image.Pt(d.Position.X, d.Position.Y)
+	return imaging.Overlay(img, dc.Image(), position, float64(opacity)/100), nil
 }
 
 type FormatConversionDTO struct {
-	Format string `json:"format" binding:"required,oneof=jpeg png webp tiff bmp gif"`
+	Format   string `json:"format" binding:"required,oneof=jpeg png webp tiff bmp gif"`
+	Quality  *int   `json:"quality,omitempty" binding:"omitempty,min=1,max=100"`
+	Lossless bool   `json:"lossless,omitempty"`
+}
+
+func (d *FormatConversionDTO) Validate() error {
+	if d.Quality != nil && (*d.Quality < 1 || *d.Quality > 100) {
+		return errors.New("quality must be between 1 and 100")
+	}
+	if d.Lossless && d.Format != "webp" {
+		return errors.New("lossless is only supported for webp")
+	}
+	if d.Quality != nil && d.Format != "" && d.Format != "jpeg" && d.Format != "webp" {
+		return errors.New("quality is only supported for jpeg and webp")
+	}
+	if d.Format == "" && d.Quality != nil {
+		return nil
+	}
+	if _, ok := outputFormats[d.Format]; !ok {
+		return fmt.Errorf("unsupported format %q", d.Format)
+	}
+	return nil
+}
+
+func (d *FormatConversionDTO) Apply(img image.Image) (image.Image, error) {
+	return img, nil
+}
+
+type FrameDTO struct {
+	Index int `json:"index" binding:"min=0"`
+}
+
+func (d *FrameDTO) Validate() error {
+	if d.Index < 0 {
+		return errors.New("index must be at least 0")
+	}
+	return nil
+}
+
+func (d *FrameDTO) Apply(img image.Image) (image.Image, error) {
+	return img, nil
 }
 
 type FilterDTO struct {
-	Saturation int `json:"saturation" binding:"required,min=-100,max=100"`
-	Brightness int `json:"brightness" binding:"required,min=-100,max=100"`
-	Contrast   int `json:"contrast" binding:"required,min=-100,max=100"`
+	Saturation int `json:"saturation" binding:"min=-100,max=100"`
+	Brightness int `json:"brightness" binding:"min=-100,max=100"`
+	Contrast   int `json:"contrast" binding:"min=-100,max=100"`
+}
+
+func (d *FilterDTO) Validate() error {
+	for _, v := range []int{d.Saturation, d.Brightness, d.Contrast} {
+		if v < -100 || v > 100 {
+			return errors.New("saturation, brightness and contrast must be between -100 and 100")
+		}
+	}
+	return nil
+}
+
+func (d *FilterDTO) Apply(img image.Image) (image.Image, error) {
+	filtered := imaging.AdjustSaturation(img, float64(d.Saturation))
+	filtered = imaging.AdjustBrightness(filtered, float64(d.Brightness))
+	return imaging.AdjustContrast(filtered, float64(d.Contrast)), nil
 }
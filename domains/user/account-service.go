@@ -0,0 +1,160 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"vixel/config"
+	"vixel/shared/mailer"
+
+	"gorm.io/gorm"
+)
+
+const (
+	verificationTokenTTL = 48 * time.Hour
+	resetTokenTTL        = time.Hour
+)
+
+var (
+	ErrInvalidUserToken     = errors.New("invalid or expired token")
+	ErrEmailAlreadyVerified = errors.New("email address is already verified")
+)
+
+func (s *UserService) SendVerificationEmail(ctx context.Context, userID uint) error {
+	var user User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+
+	token, err := s.createUserToken(&user, TokenPurposeVerifyEmail, verificationTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Confirm your email address by opening the link below within %s:\n\n%s\n\nToken: %s",
+			verificationTokenTTL, appLink("/verify-email", token), token),
+	})
+}
+
+func (s *UserService) VerifyEmail(token string) (*User, error) {
+	var user User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		userToken, err := consumeUserToken(tx, token, TokenPurposeVerifyEmail)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.First(&user, userToken.UserID).Error; err != nil {
+			return err
+		}
+		if user.EmailVerifiedAt != nil {
+			return nil
+		}
+		now := time.Now()
+		updates := map[string]interface{}{"email_verified_at": now}
+		user.EmailVerifiedAt = &now
+		if isAdminEmail(user.Email) {
+			updates["role"] = RoleAdmin
+			user.Role = RoleAdmin
+		}
+		return tx.Model(&user).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *UserService) ForgotPassword(ctx context.Context, email string) error {
+	var user User
+	if err := s.db.Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	token, err := s.createUserToken(&user, TokenPurposeResetPassword, resetTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password for this account. Open the link below within %s to choose a new one:\n\n%s\n\nToken: %s\n\nIf this wasn't you, you can ignore this email.",
+			resetTokenTTL, appLink("/reset-password", token), token),
+	})
+}
+
+func (s *UserService) ResetPassword(token, password string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		userToken, err := consumeUserToken(tx, token, TokenPurposeResetPassword)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&User{}).Where("id = ?", userToken.UserID).Update("password", hashPassword(password)).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userToken.UserID).
+			Update("revoked_at", time.Now()).Error
+	})
+}
+
+func (s *UserService) createUserToken(user *User, purpose string, ttl time.Duration) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.db.Create(&UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func consumeUserToken(tx *gorm.DB, token, purpose string) (*UserToken, error) {
+	var userToken UserToken
+	if err := tx.Where("token_hash = ? AND purpose = ?", hashToken(token), purpose).First(&userToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidUserToken
+		}
+		return nil, err
+	}
+	if userToken.UsedAt != nil || time.Now().After(userToken.ExpiresAt) {
+		return nil, ErrInvalidUserToken
+	}
+
+	result := tx.Model(&UserToken{}).
+		Where("id = ? AND used_at IS NULL", userToken.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidUserToken
+	}
+	return &userToken, nil
+}
+
+func appLink(path, token string) string {
+	return strings.TrimSuffix(config.Config.AppBaseURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,139 @@
+package user
+
+import (
+	"errors"
+	"strconv"
+	"vixel/shared/middlewares"
+	"vixel/shared/responses"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AdminHandler struct {
+	service *UserService
+}
+
+func NewAdminHandler(service *UserService) *AdminHandler {
+	return &AdminHandler{service: service}
+}
+
+func (h *AdminHandler) SetupAdminRoutes(rg *gin.RouterGroup) {
+	admin := rg.Group("/admin", middlewares.JWTMiddleware(), middlewares.RequireRole(RoleAdmin))
+	admin.GET("/users", h.ListUsers())
+	admin.POST("/users/:id/suspend", h.SuspendUser())
+	admin.POST("/users/:id/unsuspend", h.UnsuspendUser())
+	admin.PUT("/users/:id/role", h.UpdateRole())
+	admin.DELETE("/users/:id/content", h.DeleteUserContent())
+}
+
+func (h *AdminHandler) ListUsers() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		users, err := h.service.ListUsers()
+		if err != nil {
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		userResponses := make([]UserResponse, 0, len(users))
+		for _, u := range users {
+			userResponses = append(userResponses, u.ToResponse())
+		}
+
+		responses.Ok(ctx, userResponses)
+	}
+}
+
+func (h *AdminHandler) SuspendUser() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, ok := h.targetUserID(ctx, true)
+		if !ok {
+			return
+		}
+
+		user, err := h.service.SuspendUser(id)
+		if err != nil {
+			h.handleError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, user.ToResponse())
+	}
+}
+
+func (h *AdminHandler) UnsuspendUser() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, ok := h.targetUserID(ctx, false)
+		if !ok {
+			return
+		}
+
+		user, err := h.service.UnsuspendUser(id)
+		if err != nil {
+			h.handleError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, user.ToResponse())
+	}
+}
+
+func (h *AdminHandler) UpdateRole() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto UpdateRoleDto
+		if err := ctx.ShouldBindJSON(&dto); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		id, ok := h.targetUserID(ctx, dto.Role != RoleAdmin)
+		if !ok {
+			return
+		}
+
+		user, err := h.service.SetRole(id, dto.Role)
+		if err != nil {
+			h.handleError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, user.ToResponse())
+	}
+}
+
+func (h *AdminHandler) DeleteUserContent() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, ok := h.targetUserID(ctx, false)
+		if !ok {
+			return
+		}
+
+		if err := h.service.DeleteUserContent(ctx, id); err != nil {
+			h.handleError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, gin.H{"message": "user content deleted"})
+	}
+}
+
+func (h *AdminHandler) targetUserID(ctx *gin.Context, rejectSelf bool) (uint, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		responses.BadRequest(ctx, errors.New("invalid user id"))
+		return 0, false
+	}
+	if rejectSelf && uint(id) == ctx.Value("user_id").(uint) {
+		responses.BadRequest(ctx, ErrCannotModifySelf)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func (h *AdminHandler) handleError(ctx *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		responses.NotFound(ctx, errors.New("user not found"))
+		return
+	}
+	responses.InternalServerError(ctx, err)
+}
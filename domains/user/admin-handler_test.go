@@ -0,0 +1,121 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"vixel/config"
+	"vixel/shared/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminHandler_RequiresAdminRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, db := setupTestUserHandler(t)
+	service := handler.service
+
+	previous := *config.Config
+	t.Cleanup(func() { *config.Config = previous })
+	config.Config.AdminEmails = []string{"Admin@example.com"}
+
+	router := gin.New()
+	NewAdminHandler(service).SetupAdminRoutes(router.Group(""))
+
+	if _, err := service.Register(context.Background(), &User{Username: "admin", Email: "admin@example.com", Password: "password123"}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	db.Model(&User{}).Where("email = ?", "admin@example.com").Update("email_verified_at", time.Now())
+	if err := service.PromoteAdmins(config.Config.AdminEmails); err != nil {
+		t.Fatalf("PromoteAdmins failed: %v", err)
+	}
+	adminTokens, err := service.Login("admin@example.com", "password123")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	userTokens, _ := service.Register(context.Background(), &User{Username: "u", Email: "u@example.com", Password: "password123"})
+
+	var member User
+	db.Where("email = ?", "u@example.com").First(&member)
+
+	do := func(token, method, path string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(nil))
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := do(userTokens.AccessToken, "GET", "/admin/users"); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for regular user, got %d", code)
+	}
+	if code := do(adminTokens.AccessToken, "GET", "/admin/users"); code != http.StatusOK {
+		t.Errorf("Expected status 200 for admin, got %d", code)
+	}
+	if code := do(adminTokens.AccessToken, "POST", "/admin/users/1/suspend"); code != http.StatusBadRequest {
+		t.Errorf("Expected admins not to suspend themselves, got %d", code)
+	}
+	if code := do(adminTokens.AccessToken, "POST", "/admin/users/99/suspend"); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown user, got %d", code)
+	}
+	if code := do(adminTokens.AccessToken, "POST", "/admin/users/2/suspend"); code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", code)
+	}
+
+	middlewares.SetTokenDenylist(service)
+	defer middlewares.SetTokenDenylist(nil)
+	handler.SetupUserRoutes(router.Group(""))
+	if code := do(userTokens.AccessToken, "GET", "/users/me"); code != http.StatusUnauthorized {
+		t.Errorf("Expected suspended user's access token to be rejected, got %d", code)
+	}
+	if _, err := service.Login(member.Email, "password123"); err != ErrAccountSuspended {
+		t.Errorf("Expected ErrAccountSuspended, got %v", err)
+	}
+	if _, err := service.Refresh(userTokens.RefreshToken); err == nil {
+		t.Error("Suspended user's refresh tokens should be revoked")
+	}
+
+	if _, err := service.UnsuspendUser(member.ID); err != nil {
+		t.Fatalf("UnsuspendUser failed: %v", err)
+	}
+	if _, err := service.Login(member.Email, "password123"); err != nil {
+		t.Errorf("Login after unsuspend failed: %v", err)
+	}
+}
+
+func TestAdminHandler_DemotionAppliesImmediately(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, db := setupTestUserHandler(t)
+	service := handler.service
+
+	router := gin.New()
+	NewAdminHandler(service).SetupAdminRoutes(router.Group(""))
+	middlewares.SetTokenDenylist(service)
+	defer middlewares.SetTokenDenylist(nil)
+
+	service.Register(context.Background(), &User{Username: "admin", Email: "admin@example.com", Password: "password123"})
+	db.Model(&User{}).Where("email = ?", "admin@example.com").Update("role", RoleAdmin)
+	tokens, _ := service.Login("admin@example.com", "password123")
+
+	do := func() int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/admin/users", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := do(); code != http.StatusOK {
+		t.Fatalf("Expected status 200 for admin, got %d", code)
+	}
+	if _, err := service.SetRole(1, RoleUser); err != nil {
+		t.Fatalf("SetRole failed: %v", err)
+	}
+	if code := do(); code != http.StatusForbidden {
+		t.Errorf("Expected demoted admin's token to lose access, got %d", code)
+	}
+}
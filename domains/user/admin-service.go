@@ -0,0 +1,82 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrCannotModifySelf = errors.New("admins cannot suspend or demote themselves")
+
+func (s *UserService) ListUsers() ([]User, error) {
+	var users []User
+	if err := s.db.Order("id").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *UserService) SuspendUser(id uint) (*User, error) {
+	user, err := s.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsSuspended() {
+		return user, nil
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("suspended_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Model(&RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", time.Now()).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUser(id)
+}
+
+func (s *UserService) UnsuspendUser(id uint) (*User, error) {
+	if err := s.db.Model(&User{}).Where("id = ?", id).Update("suspended_at", nil).Error; err != nil {
+		return nil, err
+	}
+	return s.GetUser(id)
+}
+
+func (s *UserService) SetRole(id uint, role string) (*User, error) {
+	if err := s.db.Model(&User{}).Where("id = ?", id).Update("role", role).Error; err != nil {
+		return nil, err
+	}
+	return s.GetUser(id)
+}
+
+func (s *UserService) DeleteUserContent(ctx context.Context, id uint) error {
+	if _, err := s.GetUser(id); err != nil {
+		return err
+	}
+	for _, cleaner := range s.cleaners {
+		if err := cleaner.DeleteUserContent(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *UserService) PromoteAdmins(emails []string) error {
+	if len(emails) == 0 {
+		return nil
+	}
+	lowered := make([]string, 0, len(emails))
+	for _, email := range emails {
+		lowered = append(lowered, strings.ToLower(email))
+	}
+	return s.db.Model(&User{}).
+		Where("LOWER(email) IN ? AND email_verified_at IS NOT NULL", lowered).
+		Update("role", RoleAdmin).Error
+}
@@ -0,0 +1,138 @@
+package user
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+	"vixel/shared/middlewares"
+
+	"gorm.io/gorm"
+)
+
+const (
+	apiKeyPrefix         = "vx_"
+	apiKeyLastUsedWindow = time.Minute
+)
+
+var (
+	ErrAPIKeyNotFound     = errors.New("api key not found")
+	ErrAPIKeyExpiryInPast = errors.New("expires_at must be in the future")
+	errMalformedAPIKey    = errors.New("malformed api key")
+)
+
+func (s *UserService) CreateAPIKey(userID uint, dto CreateAPIKeyDto) (*CreatedAPIKeyResponse, error) {
+	if dto.ExpiresAt != nil && !dto.ExpiresAt.After(time.Now()) {
+		return nil, ErrAPIKeyExpiryInPast
+	}
+
+	scopes := dto.Scopes
+	if len(scopes) == 0 {
+		scopes = APIKeyScopes
+	}
+
+	prefix, err := randomToken(6)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &APIKey{
+		UserID:    userID,
+		Name:      dto.Name,
+		Prefix:    apiKeyPrefix + prefix,
+		KeyHash:   hashToken(secret),
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: dto.ExpiresAt,
+	}
+	if err := s.db.Create(key).Error; err != nil {
+		return nil, err
+	}
+
+	return &CreatedAPIKeyResponse{
+		APIKeyResponse: key.ToResponse(),
+		Key:            key.Prefix + "." + secret,
+	}, nil
+}
+
+func (s *UserService) ListAPIKeys(userID uint) ([]APIKey, error) {
+	var keys []APIKey
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *UserService) RevokeAPIKey(userID, id uint) (*APIKey, error) {
+	var key APIKey
+	if err := s.db.Where("id = ? AND user_id = ?", id, userID).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+
+	if key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+		if err := s.db.Model(&key).Update("revoked_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &key, nil
+}
+
+func (s *UserService) AuthenticateAPIKey(raw string) (*middlewares.APIKeyPrincipal, error) {
+	prefix, secret, err := splitAPIKey(raw)
+	if err != nil {
+		return nil, middlewares.ErrInvalidAPIKey
+	}
+
+	var key APIKey
+	if err := s.db.Where("prefix = ?", prefix).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, middlewares.ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashToken(secret))) != 1 || !key.IsActive(now) {
+		return nil, middlewares.ErrInvalidAPIKey
+	}
+
+	var user User
+	if err := s.db.First(&user, key.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, middlewares.ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+	if user.IsSuspended() {
+		return nil, middlewares.ErrInvalidAPIKey
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyLastUsedWindow {
+		if err := s.db.Model(&key).Update("last_used_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &middlewares.APIKeyPrincipal{
+		UserID:        user.ID,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		Scopes:        key.ScopeList(),
+	}, nil
+}
+
+func splitAPIKey(raw string) (string, string, error) {
+	prefix, secret, ok := strings.Cut(strings.TrimSpace(raw), ".")
+	if !ok || !strings.HasPrefix(prefix, apiKeyPrefix) || secret == "" {
+		return "", "", errMalformedAPIKey
+	}
+	return prefix, secret, nil
+}
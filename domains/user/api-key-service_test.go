@@ -0,0 +1,126 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"vixel/shared/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUserService_APIKeyLifecycle(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewUserService(db, &recordingMailer{})
+
+	usr := &User{Username: "u", Email: "u@example.com", Password: "password123"}
+	service.Register(context.Background(), usr)
+
+	created, err := service.CreateAPIKey(usr.ID, CreateAPIKeyDto{Name: "backend", Scopes: []string{ScopeImagesRead}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+
+	var stored APIKey
+	db.First(&stored, created.ID)
+	if stored.KeyHash == created.Key || stored.Prefix != created.Prefix {
+		t.Error("Only the hash of the key should be stored, next to its visible prefix")
+	}
+
+	principal, err := service.AuthenticateAPIKey(created.Key)
+	if err != nil {
+		t.Fatalf("AuthenticateAPIKey failed: %v", err)
+	}
+	if principal.UserID != usr.ID || len(principal.Scopes) != 1 || principal.Scopes[0] != ScopeImagesRead {
+		t.Errorf("Unexpected principal %+v", principal)
+	}
+
+	db.First(&stored, created.ID)
+	if stored.LastUsedAt == nil {
+		t.Error("Last used time should be recorded")
+	}
+
+	if _, err := service.AuthenticateAPIKey(created.Prefix + ".wrong"); !errors.Is(err, middlewares.ErrInvalidAPIKey) {
+		t.Errorf("Expected ErrInvalidAPIKey for a wrong secret, got %v", err)
+	}
+
+	if _, err := service.RevokeAPIKey(usr.ID+1, created.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected other users not to revoke the key, got %v", err)
+	}
+	if _, err := service.RevokeAPIKey(usr.ID, created.ID); err != nil {
+		t.Fatalf("RevokeAPIKey failed: %v", err)
+	}
+	if _, err := service.AuthenticateAPIKey(created.Key); !errors.Is(err, middlewares.ErrInvalidAPIKey) {
+		t.Errorf("Expected revoked key to be rejected, got %v", err)
+	}
+}
+
+func TestUserService_APIKeyExpiry(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewUserService(db, &recordingMailer{})
+
+	usr := &User{Username: "u", Email: "u@example.com", Password: "password123"}
+	service.Register(context.Background(), usr)
+
+	past := time.Now().Add(-time.Minute)
+	if _, err := service.CreateAPIKey(usr.ID, CreateAPIKeyDto{Name: "old", ExpiresAt: &past}); !errors.Is(err, ErrAPIKeyExpiryInPast) {
+		t.Errorf("Expected ErrAPIKeyExpiryInPast, got %v", err)
+	}
+
+	future := time.Now().Add(time.Hour)
+	created, err := service.CreateAPIKey(usr.ID, CreateAPIKeyDto{Name: "backend", ExpiresAt: &future})
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+	if len(created.Scopes) != len(APIKeyScopes) {
+		t.Errorf("Keys without scopes should get every scope, got %v", created.Scopes)
+	}
+
+	db.Model(&APIKey{}).Where("id = ?", created.ID).Update("expires_at", past)
+	if _, err := service.AuthenticateAPIKey(created.Key); !errors.Is(err, middlewares.ErrInvalidAPIKey) {
+		t.Errorf("Expected expired key to be rejected, got %v", err)
+	}
+}
+
+func TestJWTMiddleware_APIKeyScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	service := NewUserService(setupTestDB(t), &recordingMailer{})
+	middlewares.SetAPIKeyAuthenticator(service)
+	defer middlewares.SetAPIKeyAuthenticator(nil)
+
+	usr := &User{Username: "u", Email: "u@example.com", Password: "password123"}
+	service.Register(context.Background(), usr)
+	created, _ := service.CreateAPIKey(usr.ID, CreateAPIKeyDto{Name: "reader", Scopes: []string{ScopeImagesRead}})
+
+	router := gin.New()
+	ok := func(ctx *gin.Context) { ctx.JSON(http.StatusOK, gin.H{"user_id": ctx.Value("user_id")}) }
+	router.GET("/read", middlewares.JWTMiddleware(ScopeImagesRead), ok)
+	router.GET("/upload", middlewares.JWTMiddleware(ScopeImagesUpload), ok)
+	router.GET("/account", middlewares.JWTMiddleware(), ok)
+
+	tests := []struct {
+		path   string
+		header string
+		want   int
+	}{
+		{"/read", "ApiKey " + created.Key, http.StatusOK},
+		{"/upload", "ApiKey " + created.Key, http.StatusForbidden},
+		{"/account", "ApiKey " + created.Key, http.StatusForbidden},
+		{"/read", "ApiKey vx_unknown.secret", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Authorization", tt.header)
+		router.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("%s with %q: expected status %d, got %d", tt.path, tt.header, tt.want, w.Code)
+		}
+	}
+}
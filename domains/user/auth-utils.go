@@ -1,17 +1,43 @@
 package user
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"time"
 	"vixel/config"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+func accessTokenTTL() time.Duration {
+	if config.Config.AccessTokenTTL > 0 {
+		return config.Config.AccessTokenTTL
+	}
+	return time.Hour
+}
+
+func refreshTokenTTL() time.Duration {
+	if config.Config.RefreshTokenTTL > 0 {
+		return config.Config.RefreshTokenTTL
+	}
+	return 30 * 24 * time.Hour
+}
+
 func generateAccessToken(user *User) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub":   user.ID,
-		"email": user.Email,
-		"exp":   jwt.NewNumericDate(time.Now().Local().Add(time.Hour)),
+		"sub":            user.ID,
+		"email":          user.Email,
+		"email_verified": user.EmailVerifiedAt != nil,
+		"role":           user.Role,
+		"jti":            jti,
+		"exp":            jwt.NewNumericDate(time.Now().Local().Add(accessTokenTTL())),
 	})
 
 	return token.SignedString([]byte(config.Config.JWTSecret))
@@ -33,3 +59,16 @@ func extractUserIDFromToken(tokenStr string) (uint, error) {
 
 	return 0, nil
 }
+
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
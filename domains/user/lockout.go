@@ -0,0 +1,66 @@
+package user
+
+import (
+	"fmt"
+	"time"
+	"vixel/config"
+
+	"gorm.io/gorm"
+)
+
+type AccountLockedError struct {
+	Until time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, try again after %s", e.Until.Format(time.RFC3339))
+}
+
+func (e *AccountLockedError) RetryAfter() time.Duration {
+	return time.Until(e.Until)
+}
+
+func checkLockout(user *User) error {
+	if user.IsLocked(time.Now()) {
+		return &AccountLockedError{Until: *user.LockedUntil}
+	}
+	return nil
+}
+
+func recordFailedLogin(db *gorm.DB, user *User) error {
+	maxAttempts := config.Config.LoginMaxAttempts
+	if maxAttempts <= 0 {
+		return nil
+	}
+
+	if err := db.Model(&User{}).Where("id = ?", user.ID).Update("failed_logins", gorm.Expr("failed_logins + 1")).Error; err != nil {
+		return err
+	}
+	if err := db.Select("failed_logins").First(user, user.ID).Error; err != nil {
+		return err
+	}
+	if user.FailedLogins < maxAttempts {
+		return nil
+	}
+
+	until := time.Now().Add(config.Config.LoginLockoutDuration)
+	if err := db.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"failed_logins": 0,
+		"locked_until":  until,
+	}).Error; err != nil {
+		return err
+	}
+	user.FailedLogins = 0
+	user.LockedUntil = &until
+	return nil
+}
+
+func resetFailedLogins(db *gorm.DB, user *User) error {
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return nil
+	}
+	return db.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"failed_logins": 0,
+		"locked_until":  nil,
+	}).Error
+}
@@ -0,0 +1,61 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"vixel/config"
+)
+
+func TestUserService_Login_LocksAfterFailedAttempts(t *testing.T) {
+	previous := *config.Config
+	t.Cleanup(func() { *config.Config = previous })
+	config.Config.LoginMaxAttempts = 3
+	config.Config.LoginLockoutDuration = 15 * time.Minute
+
+	db := setupTestDB(t)
+	service := NewUserService(db, &recordingMailer{})
+	service.Register(context.Background(), &User{Username: "u", Email: "u@example.com", Password: "password123"})
+
+	for i := 0; i < 2; i++ {
+		if _, err := service.Login("u@example.com", "wrong"); err == nil {
+			t.Fatal("Expected wrong password to fail")
+		}
+	}
+	var locked *AccountLockedError
+	if _, err := service.Login("u@example.com", "wrong"); !errors.As(err, &locked) {
+		t.Fatalf("Expected the third failure to lock the account, got %v", err)
+	}
+	if _, err := service.Login("u@example.com", "password123"); !errors.As(err, &locked) {
+		t.Fatalf("Expected the correct password to be rejected while locked, got %v", err)
+	}
+
+	db.Model(&User{}).Where("email = ?", "u@example.com").Update("locked_until", time.Now().Add(-time.Second))
+	if _, err := service.Login("u@example.com", "password123"); err != nil {
+		t.Fatalf("Expected login after the lockout expired, got %v", err)
+	}
+
+	var usr User
+	db.Where("email = ?", "u@example.com").First(&usr)
+	if usr.FailedLogins != 0 || usr.LockedUntil != nil {
+		t.Errorf("Expected a successful login to reset the lockout, got %d %v", usr.FailedLogins, usr.LockedUntil)
+	}
+}
+
+func TestUserService_Login_LockoutDisabled(t *testing.T) {
+	previous := *config.Config
+	t.Cleanup(func() { *config.Config = previous })
+	config.Config.LoginMaxAttempts = 0
+
+	service := NewUserService(setupTestDB(t), &recordingMailer{})
+	service.Register(context.Background(), &User{Username: "u", Email: "u@example.com", Password: "password123"})
+
+	for i := 0; i < 10; i++ {
+		service.Login("u@example.com", "wrong")
+	}
+	if _, err := service.Login("u@example.com", "password123"); err != nil {
+		t.Errorf("Expected no lockout when disabled, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"vixel/config"
+	"vixel/shared/middlewares"
+	"vixel/shared/responses"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OIDCHandler struct {
+	service *OIDCService
+}
+
+func NewOIDCHandler(service *OIDCService) *OIDCHandler {
+	return &OIDCHandler{service: service}
+}
+
+func (h *OIDCHandler) SetupOIDCRoutes(rg *gin.RouterGroup) {
+	rg.GET("/users/oidc/providers", h.ListProviders())
+	rg.GET("/users/oidc/:provider/login", middlewares.AuthRateLimit(middlewares.AuthRouteOIDCLogin), h.Login())
+	rg.GET("/users/oidc/:provider/callback", middlewares.AuthRateLimit(middlewares.AuthRouteOIDCCallback), h.Callback())
+}
+
+func (h *OIDCHandler) ListProviders() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		providers := h.service.Providers()
+		sort.Strings(providers)
+		responses.Ok(ctx, gin.H{"providers": providers})
+	}
+}
+
+func (h *OIDCHandler) Login() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		authURL, binding, err := h.service.StartLogin(ctx, ctx.Param("provider"))
+		if err != nil {
+			h.handleError(ctx, err)
+			return
+		}
+		setStateCookie(ctx, binding, "/login", int(oidcStateTTL.Seconds()))
+
+		if ctx.Query("redirect") == "false" {
+			responses.Ok(ctx, gin.H{"authorization_url": authURL})
+			return
+		}
+		ctx.Redirect(http.StatusFound, authURL)
+	}
+}
+
+func (h *OIDCHandler) Callback() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if providerErr := ctx.Query("error"); providerErr != "" {
+			responses.BadRequest(ctx, errors.New("identity provider returned an error: "+providerErr))
+			return
+		}
+
+		state, code := ctx.Query("state"), ctx.Query("code")
+		if state == "" || code == "" {
+			responses.BadRequest(ctx, errors.New("state and code are required"))
+			return
+		}
+
+		binding, _ := ctx.Cookie(oidcStateCookie)
+		setStateCookie(ctx, "", "/callback", -1)
+
+		tokens, err := h.service.Callback(ctx, ctx.Param("provider"), state, binding, code)
+		var challenge *TwoFactorRequiredError
+		if errors.As(err, &challenge) {
+			responses.Ok(ctx, challenge.Challenge)
+			return
+		}
+		if err != nil {
+			h.handleError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, tokens)
+	}
+}
+
+const oidcStateCookie = "vixel_oidc_state"
+
+// setStateCookie scopes the login binding to the provider's routes. It is
+// sent on the provider's redirect back, which is a top-level navigation.
+func setStateCookie(ctx *gin.Context, value, route string, maxAge int) {
+	path := strings.TrimSuffix(ctx.Request.URL.Path, route)
+	secure := strings.HasPrefix(config.Config.AppBaseURL, "https://")
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(oidcStateCookie, value, maxAge, path, "", secure, true)
+}
+
+func (h *OIDCHandler) handleError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownProvider):
+		responses.NotFound(ctx, err)
+	case errors.Is(err, ErrInvalidOIDCState):
+		responses.BadRequest(ctx, err)
+	case errors.Is(err, ErrInvalidIDToken):
+		responses.Unauthorized(ctx, err)
+	case errors.Is(err, ErrAccountSuspended):
+		responses.Forbidden(ctx, err)
+	case errors.Is(err, ErrEmailTaken):
+		responses.Conflict(ctx, err)
+	default:
+		responses.InternalServerError(ctx, err)
+	}
+}
@@ -0,0 +1,26 @@
+package user
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type UserIdentity struct {
+	gorm.Model
+	UserID   uint   `gorm:"not null;index"`
+	Provider string `gorm:"not null;uniqueIndex:idx_user_identities_provider_subject"`
+	Subject  string `gorm:"not null;uniqueIndex:idx_user_identities_provider_subject"`
+	Email    string
+}
+
+type OIDCState struct {
+	ID           uint      `gorm:"primaryKey"`
+	StateHash    string    `gorm:"not null;uniqueIndex"`
+	BindingHash  string    `gorm:"not null"`
+	Provider     string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"`
+	Nonce        string    `gorm:"not null"`
+	ExpiresAt    time.Time `gorm:"not null;index"`
+	CreatedAt    time.Time
+}
@@ -0,0 +1,262 @@
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"vixel/config"
+
+	"gorm.io/gorm"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+var (
+	ErrUnknownProvider  = errors.New("unknown identity provider")
+	ErrInvalidOIDCState = errors.New("invalid or expired login state")
+)
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+type OIDCService struct {
+	users     *UserService
+	providers map[string]*oidcProvider
+}
+
+func NewOIDCService(users *UserService, providers []config.OIDCProviderConfig, client *http.Client) *OIDCService {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	s := &OIDCService{users: users, providers: make(map[string]*oidcProvider)}
+	for _, cfg := range providers {
+		s.providers[cfg.Name] = newOIDCProvider(cfg, client)
+	}
+	return s
+}
+
+func (s *OIDCService) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	return names
+}
+
+// StartLogin returns the provider's authorization URL and a binding token for
+// the browser that started the login. The callback is only accepted with the
+// same binding, so a login started by someone else cannot be completed in the
+// user's browser.
+func (s *OIDCService) StartLogin(ctx context.Context, providerName string) (string, string, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	state, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	binding, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := provider.authCodeURL(ctx, state, nonce, codeChallenge(verifier))
+	if err != nil {
+		return "", "", err
+	}
+
+	db := s.users.db
+	if err := db.Where("expires_at < ?", time.Now()).Delete(&OIDCState{}).Error; err != nil {
+		return "", "", err
+	}
+	err = db.Create(&OIDCState{
+		StateHash:    hashToken(state),
+		BindingHash:  hashToken(binding),
+		Provider:     providerName,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}).Error
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, binding, nil
+}
+
+func (s *OIDCService) Callback(ctx context.Context, providerName, state, binding, code string) (*TokenResponse, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	loginState, err := s.consumeState(providerName, state, binding)
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, err := provider.exchange(ctx, code, loginState.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := provider.verify(ctx, rawIDToken, loginState.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.resolveUser(providerName, claims)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsSuspended() {
+		return nil, ErrAccountSuspended
+	}
+	if user.HasTwoFactor() {
+		return nil, s.users.twoFactorChallenge(user)
+	}
+
+	tokens, _, err := s.users.issueTokens(s.users.db, user, "")
+	return tokens, err
+}
+
+func (s *OIDCService) consumeState(providerName, state, binding string) (*OIDCState, error) {
+	var loginState OIDCState
+	err := s.users.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("state_hash = ? AND provider = ?", hashToken(state), providerName).First(&loginState).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidOIDCState
+			}
+			return err
+		}
+
+		result := tx.Delete(&OIDCState{}, loginState.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 || time.Now().After(loginState.ExpiresAt) {
+			return ErrInvalidOIDCState
+		}
+		if subtle.ConstantTimeCompare([]byte(hashToken(binding)), []byte(loginState.BindingHash)) != 1 {
+			return ErrInvalidOIDCState
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &loginState, nil
+}
+
+func (s *OIDCService) resolveUser(providerName string, claims *oidcClaims) (*User, error) {
+	var user User
+	err := s.users.db.Transaction(func(tx *gorm.DB) error {
+		var identity UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", providerName, claims.Subject).First(&identity).Error
+		if err == nil {
+			return tx.First(&user, identity.UserID).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if claims.Email == "" {
+			return fmt.Errorf("%w: missing email claim", ErrInvalidIDToken)
+		}
+
+		err = tx.Where("LOWER(email) = ?", strings.ToLower(claims.Email)).First(&user).Error
+		switch {
+		case err == nil:
+			// Both sides must have proven the address, otherwise whoever
+			// registered it first could be handed the other's account.
+			if !claims.EmailVerified || user.EmailVerifiedAt == nil {
+				return ErrEmailTaken
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := s.provisionUser(tx, &user, claims); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		return tx.Create(&UserIdentity{
+			UserID:   user.ID,
+			Provider: providerName,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *OIDCService) provisionUser(tx *gorm.DB, user *User, claims *oidcClaims) error {
+	password, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	username, err := availableUsername(tx, claims)
+	if err != nil {
+		return err
+	}
+
+	*user = User{
+		Username: username,
+		Email:    claims.Email,
+		Password: hashPassword(password),
+		Role:     RoleUser,
+	}
+	if claims.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		if isAdminEmail(claims.Email) {
+			user.Role = RoleAdmin
+		}
+	}
+	return tx.Create(user).Error
+}
+
+func availableUsername(tx *gorm.DB, claims *oidcClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = strings.Trim(usernameInvalidChars.ReplaceAllString(base, "-"), "-")
+	if base == "" {
+		base = "user"
+	}
+
+	candidate := base
+	for i := 2; ; i++ {
+		var count int64
+		if err := tx.Model(&User{}).Unscoped().Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,331 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"vixel/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type stubIssuer struct {
+	*httptest.Server
+	t   *testing.T
+	key *rsa.PrivateKey
+
+	mu         sync.Mutex
+	challenges map[string]string
+	nonces     map[string]string
+	claims     jwt.MapClaims
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	issuer := &stubIssuer{t: t, key: key, challenges: map[string]string{}, nonces: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.URL,
+			"authorization_endpoint": issuer.URL + "/authorize",
+			"token_endpoint":         issuer.URL + "/token",
+			"jwks_uri":               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "test",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		code := r.PostForm.Get("code")
+
+		issuer.mu.Lock()
+		challenge, nonce := issuer.challenges[code], issuer.nonces[code]
+		claims := jwt.MapClaims{}
+		for k, v := range issuer.claims {
+			claims[k] = v
+		}
+		issuer.mu.Unlock()
+
+		if challenge == "" || codeChallenge(r.PostForm.Get("code_verifier")) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims["iss"] = issuer.URL
+		claims["aud"] = "vixel"
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		claims["nonce"] = nonce
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func (s *stubIssuer) authorize(authURL string, claims jwt.MapClaims) (state, code string) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		s.t.Fatalf("Invalid authorization URL: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		s.t.Fatalf("Expected PKCE S256, got %q", query.Get("code_challenge_method"))
+	}
+
+	code = "code-" + query.Get("state")[:8]
+	s.mu.Lock()
+	s.challenges[code] = query.Get("code_challenge")
+	s.nonces[code] = query.Get("nonce")
+	s.claims = claims
+	s.mu.Unlock()
+	return query.Get("state"), code
+}
+
+func setupOIDCService(t *testing.T) (*OIDCService, *stubIssuer) {
+	issuer := newStubIssuer(t)
+	users := NewUserService(setupTestDB(t), &recordingMailer{})
+	service := NewOIDCService(users, []config.OIDCProviderConfig{{
+		Name:        "stub",
+		Issuer:      issuer.URL,
+		ClientID:    "vixel",
+		RedirectURL: "http://localhost/api/v1/users/oidc/stub/callback",
+		Scopes:      []string{"openid", "email"},
+	}}, issuer.Client())
+	return service, issuer
+}
+
+func TestOIDCService_ProvisionsAndLinksUser(t *testing.T) {
+	service, issuer := setupOIDCService(t)
+	ctx := context.Background()
+	claims := jwt.MapClaims{"sub": "subject-1", "email": "sso@example.com", "email_verified": true, "preferred_username": "sso user"}
+
+	login := func() *TokenResponse {
+		authURL, binding, err := service.StartLogin(ctx, "stub")
+		if err != nil {
+			t.Fatalf("StartLogin failed: %v", err)
+		}
+		state, code := issuer.authorize(authURL, claims)
+		tokens, err := service.Callback(ctx, "stub", state, binding, code)
+		if err != nil {
+			t.Fatalf("Callback failed: %v", err)
+		}
+		return tokens
+	}
+
+	first := login()
+	if first.AccessToken == "" || first.RefreshToken == "" {
+		t.Error("Expected Vixel tokens after SSO login")
+	}
+	second := login()
+
+	firstID, _ := extractUserIDFromToken(first.AccessToken)
+	secondID, _ := extractUserIDFromToken(second.AccessToken)
+	if firstID == 0 || firstID != secondID {
+		t.Errorf("Expected both logins to resolve to the same user, got %d and %d", firstID, secondID)
+	}
+
+	user, _ := service.users.GetUser(firstID)
+	if user.Username != "sso-user" || user.EmailVerifiedAt == nil {
+		t.Errorf("Unexpected provisioned user %+v", user)
+	}
+
+	var identities int64
+	service.users.db.Model(&UserIdentity{}).Count(&identities)
+	if identities != 1 {
+		t.Errorf("Expected one linked identity, got %d", identities)
+	}
+}
+
+func TestOIDCService_LinksExistingUserByVerifiedEmail(t *testing.T) {
+	service, issuer := setupOIDCService(t)
+	ctx := context.Background()
+
+	existing := &User{Username: "local", Email: "local@example.com", Password: "password123"}
+	service.users.Register(ctx, existing)
+
+	authURL, binding, _ := service.StartLogin(ctx, "stub")
+	state, code := issuer.authorize(authURL, jwt.MapClaims{"sub": "s", "email": "local@example.com", "email_verified": false})
+	if _, err := service.Callback(ctx, "stub", state, binding, code); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("Expected unverified provider email not to take over an account, got %v", err)
+	}
+
+	authURL, binding, _ = service.StartLogin(ctx, "stub")
+	state, code = issuer.authorize(authURL, jwt.MapClaims{"sub": "s", "email": "local@example.com", "email_verified": true})
+	if _, err := service.Callback(ctx, "stub", state, binding, code); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("Expected an unverified local account not to be linked, got %v", err)
+	}
+
+	service.users.db.Model(existing).Update("email_verified_at", time.Now())
+	authURL, binding, _ = service.StartLogin(ctx, "stub")
+	state, code = issuer.authorize(authURL, jwt.MapClaims{"sub": "s", "email": "local@example.com", "email_verified": true})
+	tokens, err := service.Callback(ctx, "stub", state, binding, code)
+	if err != nil {
+		t.Fatalf("Callback failed: %v", err)
+	}
+	if id, _ := extractUserIDFromToken(tokens.AccessToken); id != existing.ID {
+		t.Errorf("Expected identity to be linked to user %d, got %d", existing.ID, id)
+	}
+}
+
+func TestOIDCService_RejectsReplayedState(t *testing.T) {
+	service, issuer := setupOIDCService(t)
+	ctx := context.Background()
+
+	authURL, binding, _ := service.StartLogin(ctx, "stub")
+	state, code := issuer.authorize(authURL, jwt.MapClaims{"sub": "s", "email": "a@example.com", "email_verified": true})
+	if _, err := service.Callback(ctx, "stub", state, binding, code); err != nil {
+		t.Fatalf("Callback failed: %v", err)
+	}
+	if _, err := service.Callback(ctx, "stub", state, binding, code); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Errorf("Expected ErrInvalidOIDCState on replay, got %v", err)
+	}
+	if _, _, err := service.StartLogin(ctx, "unknown"); !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("Expected ErrUnknownProvider, got %v", err)
+	}
+}
+
+func TestOIDCService_RejectsStateFromAnotherBrowser(t *testing.T) {
+	service, issuer := setupOIDCService(t)
+	ctx := context.Background()
+
+	authURL, _, _ := service.StartLogin(ctx, "stub")
+	_, otherBinding, _ := service.StartLogin(ctx, "stub")
+	state, code := issuer.authorize(authURL, jwt.MapClaims{"sub": "s", "email": "a@example.com", "email_verified": true})
+	for _, binding := range []string{"", otherBinding} {
+		if _, err := service.Callback(ctx, "stub", state, binding, code); !errors.Is(err, ErrInvalidOIDCState) {
+			t.Errorf("Expected ErrInvalidOIDCState for binding %q, got %v", binding, err)
+		}
+	}
+}
+
+func TestOIDCHandler_BindsStateToTheBrowser(t *testing.T) {
+	service, issuer := setupOIDCService(t)
+	router := gin.New()
+	NewOIDCHandler(service).SetupOIDCRoutes(router.Group("/api/v1"))
+
+	login := func() (string, string, *http.Cookie) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/users/oidc/stub/login", nil))
+		cookies := w.Result().Cookies()
+		if w.Code != http.StatusFound || len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].Path != "/api/v1/users/oidc/stub" {
+			t.Fatalf("Expected a redirect with a binding cookie, got %d and %+v", w.Code, cookies)
+		}
+		state, code := issuer.authorize(w.Header().Get("Location"), jwt.MapClaims{"sub": "s", "email": "a@example.com", "email_verified": true})
+		return state, code, cookies[0]
+	}
+	callback := func(state, code string, cookie *http.Cookie) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users/oidc/stub/callback?"+url.Values{"state": {state}, "code": {code}}.Encode(), nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	state, code, _ := login()
+	if status := callback(state, code, nil); status != http.StatusBadRequest {
+		t.Errorf("Expected a callback without the cookie to be rejected, got %d", status)
+	}
+	state, code, cookie := login()
+	if status := callback(state, code, cookie); status != http.StatusOK {
+		t.Errorf("Expected the callback from the same browser to succeed, got %d", status)
+	}
+}
+
+func TestOIDCService_RejectsTokenSignedWithUnknownKey(t *testing.T) {
+	service, issuer := setupOIDCService(t)
+	ctx := context.Background()
+
+	authURL, binding, _ := service.StartLogin(ctx, "stub")
+	state, code := issuer.authorize(authURL, jwt.MapClaims{"sub": "s", "email": "a@example.com"})
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	service.providers["stub"].keys = map[string]interface{}{"test": &other.PublicKey}
+
+	if _, err := service.Callback(ctx, "stub", state, binding, code); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("Expected ErrInvalidIDToken, got %v", err)
+	}
+}
+
+func TestOIDCService_AdminEmailRequiresVerification(t *testing.T) {
+	previous := *config.Config
+	t.Cleanup(func() { *config.Config = previous })
+	config.Config.AdminEmails = []string{"admin@example.com", "boss@example.com"}
+
+	service, issuer := setupOIDCService(t)
+	ctx := context.Background()
+
+	login := func(claims jwt.MapClaims) *User {
+		authURL, binding, _ := service.StartLogin(ctx, "stub")
+		state, code := issuer.authorize(authURL, claims)
+		tokens, err := service.Callback(ctx, "stub", state, binding, code)
+		if err != nil {
+			t.Fatalf("Callback failed: %v", err)
+		}
+		id, _ := extractUserIDFromToken(tokens.AccessToken)
+		user, _ := service.users.GetUser(id)
+		return user
+	}
+
+	if user := login(jwt.MapClaims{"sub": "a", "email": "admin@example.com", "email_verified": false}); user.Role != RoleUser {
+		t.Errorf("Expected an unverified admin email to be provisioned as a user, got %s", user.Role)
+	}
+	if user := login(jwt.MapClaims{"sub": "b", "email": "boss@example.com", "email_verified": true}); user.Role != RoleAdmin {
+		t.Errorf("Expected a verified admin email to be provisioned as an admin, got %s", user.Role)
+	}
+}
+
+func TestOIDCService_RequiresTwoFactor(t *testing.T) {
+	service, issuer := setupOIDCService(t)
+	ctx := context.Background()
+
+	existing := &User{Username: "local", Email: "local@example.com", Password: "password123"}
+	service.users.Register(ctx, existing)
+	service.users.db.Model(existing).Update("email_verified_at", time.Now())
+	secret, _ := enableTwoFactor(t, service.users, existing.ID)
+
+	authURL, binding, _ := service.StartLogin(ctx, "stub")
+	state, code := issuer.authorize(authURL, jwt.MapClaims{"sub": "s", "email": "local@example.com", "email_verified": true})
+	tokens, err := service.Callback(ctx, "stub", state, binding, code)
+	var challenge *TwoFactorRequiredError
+	if !errors.As(err, &challenge) {
+		t.Fatalf("Expected a two-factor challenge, got %+v, %v", tokens, err)
+	}
+
+	totp, _ := totpCode(secret, totpCounter(time.Now()))
+	tokens, err = service.users.CompleteTwoFactorLogin(challenge.Challenge.ChallengeToken, totp)
+	if err != nil {
+		t.Fatalf("CompleteTwoFactorLogin failed: %v", err)
+	}
+	if id, _ := extractUserIDFromToken(tokens.AccessToken); id != existing.ID {
+		t.Errorf("Expected tokens for user %d, got %d", existing.ID, id)
+	}
+}
@@ -0,0 +1,140 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidPassword = errors.New("current password is incorrect")
+	ErrEmailTaken      = errors.New("email address is already in use")
+	ErrUsernameTaken   = errors.New("username is already in use")
+)
+
+func (s *UserService) GetUser(id uint) (*User, error) {
+	var user User
+	if err := s.db.First(&user, id).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *UserService) UpdateProfile(ctx context.Context, id uint, dto UpdateProfileDto) (*User, error) {
+	user, err := s.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	emailChanged := false
+	if dto.Username != nil && *dto.Username != user.Username {
+		if taken, err := s.isTaken("username", *dto.Username, id); err != nil {
+			return nil, err
+		} else if taken {
+			return nil, ErrUsernameTaken
+		}
+		updates["username"] = *dto.Username
+	}
+	if dto.Email != nil && !strings.EqualFold(*dto.Email, user.Email) {
+		if taken, err := s.isTaken("email", *dto.Email, id); err != nil {
+			return nil, err
+		} else if taken {
+			return nil, ErrEmailTaken
+		}
+		updates["email"] = *dto.Email
+		updates["email_verified_at"] = nil
+		emailChanged = true
+	}
+	if dto.StripMetadata != nil && *dto.StripMetadata != user.StripMetadata {
+		updates["strip_metadata"] = *dto.StripMetadata
+	}
+	if len(updates) == 0 {
+		return user, nil
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		if !emailChanged {
+			return nil
+		}
+		return tx.Model(&UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", id, TokenPurposeVerifyEmail).
+			Update("used_at", time.Now()).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if emailChanged {
+		if err := s.SendVerificationEmail(ctx, user.ID); err != nil {
+			log.Printf("failed to send verification email to user %d: %v", user.ID, err)
+		}
+	}
+	return s.GetUser(id)
+}
+
+func (s *UserService) ChangePassword(id uint, currentPassword, newPassword string) error {
+	user, err := s.GetUser(id)
+	if err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)); err != nil {
+		return ErrInvalidPassword
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("password", hashPassword(newPassword)).Error; err != nil {
+			return err
+		}
+		return tx.Model(&RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", time.Now()).Error
+	})
+}
+
+func (s *UserService) DeleteAccount(ctx context.Context, id uint, jti string, expiresAt time.Time) error {
+	if err := s.DeleteUserContent(ctx, id); err != nil {
+		return err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", id).Delete(&RefreshToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", id).Delete(&UserToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", id).Delete(&APIKey{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", id).Delete(&UserIdentity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", id).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&User{}, id).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if jti == "" {
+		return nil
+	}
+	return s.db.Save(&RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+}
+
+func (s *UserService) isTaken(column, value string, exceptID uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&User{}).Unscoped().Where(column+" = ? AND id <> ?", value, exceptID).Count(&count).Error
+	return count > 0, err
+}
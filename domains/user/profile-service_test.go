@@ -0,0 +1,149 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type recordingCleaner struct {
+	userIDs []uint
+}
+
+func (c *recordingCleaner) DeleteUserContent(ctx context.Context, userID uint) error {
+	c.userIDs = append(c.userIDs, userID)
+	return nil
+}
+
+func TestUserService_UpdateProfile_EmailChangeResetsVerification(t *testing.T) {
+	db := setupTestDB(t)
+	mail := &recordingMailer{}
+	service := NewUserService(db, mail)
+
+	user := &User{Username: "u", Email: "u@example.com", Password: "password123"}
+	service.Register(context.Background(), user)
+	if _, err := service.VerifyEmail(mail.lastToken(t)); err != nil {
+		t.Fatalf("VerifyEmail failed: %v", err)
+	}
+
+	email := "new@example.com"
+	updated, err := service.UpdateProfile(context.Background(), user.ID, UpdateProfileDto{Email: &email})
+	if err != nil {
+		t.Fatalf("UpdateProfile failed: %v", err)
+	}
+	if updated.Email != email || updated.EmailVerifiedAt != nil {
+		t.Errorf("Expected unverified new email, got %+v", updated)
+	}
+	if to := mail.sent[len(mail.sent)-1].To; to != email {
+		t.Errorf("Expected verification email to %s, got %s", email, to)
+	}
+}
+
+func TestUserService_UpdateProfile_EmailChangeInvalidatesPendingVerification(t *testing.T) {
+	db := setupTestDB(t)
+	mail := &recordingMailer{}
+	service := NewUserService(db, mail)
+
+	user := &User{Username: "u", Email: "u@example.com", Password: "password123"}
+	service.Register(context.Background(), user)
+	stale := mail.lastToken(t)
+
+	email := "new@example.com"
+	if _, err := service.UpdateProfile(context.Background(), user.ID, UpdateProfileDto{Email: &email}); err != nil {
+		t.Fatalf("UpdateProfile failed: %v", err)
+	}
+	if _, err := service.VerifyEmail(stale); !errors.Is(err, ErrInvalidUserToken) {
+		t.Errorf("Expected a token sent to the old address to be rejected, got %v", err)
+	}
+}
+
+func TestUserService_UpdateProfile_Conflict(t *testing.T) {
+	service := NewUserService(setupTestDB(t), &recordingMailer{})
+	ctx := context.Background()
+
+	first := &User{Username: "first", Email: "first@example.com", Password: "password123"}
+	service.Register(ctx, first)
+	service.Register(ctx, &User{Username: "second", Email: "second@example.com", Password: "password123"})
+
+	username := "second"
+	if _, err := service.UpdateProfile(ctx, first.ID, UpdateProfileDto{Username: &username}); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("Expected ErrUsernameTaken, got %v", err)
+	}
+	email := "second@example.com"
+	if _, err := service.UpdateProfile(ctx, first.ID, UpdateProfileDto{Email: &email}); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("Expected ErrEmailTaken, got %v", err)
+	}
+}
+
+func TestUserService_ChangePassword(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewUserService(db, &recordingMailer{})
+
+	user := &User{Username: "u", Email: "u@example.com", Password: "password123"}
+	tokens, _ := service.Register(context.Background(), user)
+
+	if err := service.ChangePassword(user.ID, "wrong", "newpassword"); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("Expected ErrInvalidPassword, got %v", err)
+	}
+	if err := service.ChangePassword(user.ID, "password123", "newpassword"); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+
+	if _, err := service.Login("u@example.com", "newpassword"); err != nil {
+		t.Errorf("Login with new password failed: %v", err)
+	}
+	if _, err := service.Refresh(tokens.RefreshToken); err == nil {
+		t.Error("Existing refresh tokens should be revoked")
+	}
+}
+
+func TestUserService_DeleteAccount(t *testing.T) {
+	db := setupTestDB(t)
+	cleaner := &recordingCleaner{}
+	service := NewUserService(db, &recordingMailer{}, cleaner)
+
+	user := &User{Username: "u", Email: "u@example.com", Password: "password123"}
+	service.Register(context.Background(), user)
+
+	if err := service.DeleteAccount(context.Background(), user.ID, "jti-1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("DeleteAccount failed: %v", err)
+	}
+
+	if len(cleaner.userIDs) != 1 || cleaner.userIDs[0] != user.ID {
+		t.Errorf("Expected content of user %d to be cleaned, got %v", user.ID, cleaner.userIDs)
+	}
+	var count int64
+	db.Unscoped().Model(&User{}).Count(&count)
+	if count != 0 {
+		t.Error("User row should be deleted")
+	}
+	db.Unscoped().Model(&RefreshToken{}).Count(&count)
+	if count != 0 {
+		t.Error("Refresh tokens should be deleted")
+	}
+	if revoked, _, _ := service.IsRevoked(user.ID, "jti-1"); !revoked {
+		t.Error("Current access token should be revoked")
+	}
+
+	if _, err := service.Register(context.Background(), &User{Username: "u", Email: "u@example.com", Password: "password123"}); err != nil {
+		t.Errorf("Username and email should be free again: %v", err)
+	}
+}
+
+func TestUserService_UpdateProfile_StripMetadata(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewUserService(db, &recordingMailer{})
+
+	user := &User{Username: "u", Email: "u@example.com", Password: "password123"}
+	service.Register(context.Background(), user)
+
+	mode := StripMetadataAll
+	updated, err := service.UpdateProfile(context.Background(), user.ID, UpdateProfileDto{StripMetadata: &mode})
+	if err != nil {
+		t.Fatalf("UpdateProfile failed: %v", err)
+	}
+	if updated.StripMetadata != StripMetadataAll || updated.ToResponse().StripMetadata != StripMetadataAll {
+		t.Errorf("Expected strip_metadata to be all, got %q", updated.StripMetadata)
+	}
+}
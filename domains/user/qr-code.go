@@ -0,0 +1,42 @@
+package user
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+
+	"github.com/fogleman/gg"
+	"rsc.io/qr"
+)
+
+const (
+	qrModuleSize = 6
+	qrQuietZone  = 4
+)
+
+func renderQRCode(text string) ([]byte, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return nil, err
+	}
+
+	size := (code.Size + 2*qrQuietZone) * qrModuleSize
+	dc := gg.NewContext(size, size)
+	dc.SetColor(color.White)
+	dc.Clear()
+	dc.SetColor(color.Black)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				dc.DrawRectangle(float64((x+qrQuietZone)*qrModuleSize), float64((y+qrQuietZone)*qrModuleSize), qrModuleSize, qrModuleSize)
+			}
+		}
+	}
+	dc.Fill()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dc.Image()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
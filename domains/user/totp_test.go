@@ -0,0 +1,51 @@
+package user
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode_RFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := totpCode(secret, totpCounter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("totpCode failed: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("At %d expected %s, got %s", tt.unix, tt.want, got)
+		}
+	}
+}
+
+func TestMatchTOTP_AllowsOneStepOfSkew(t *testing.T) {
+	secret, _ := generateTOTPSecret()
+	now := time.Now()
+
+	previous, _ := totpCode(secret, totpCounter(now)-1)
+	if _, ok := matchTOTP(secret, previous, now); !ok {
+		t.Error("Expected the previous code to be accepted")
+	}
+
+	stale, _ := totpCode(secret, totpCounter(now)-3)
+	if _, ok := matchTOTP(secret, stale, now); ok {
+		t.Error("Expected a stale code to be rejected")
+	}
+
+	if !strings.HasPrefix(totpURI("Vixel", "u@example.com", secret), "otpauth://totp/Vixel:u@example.com?") {
+		t.Error("Unexpected otpauth URI")
+	}
+}
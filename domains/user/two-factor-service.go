@@ -0,0 +1,263 @@
+package user
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer         = "Vixel"
+	loginChallengeTTL  = 5 * time.Minute
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled    = errors.New("two-factor authentication has not been enrolled")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+)
+
+type TwoFactorRequiredError struct {
+	Challenge TwoFactorChallengeResponse
+}
+
+func (e *TwoFactorRequiredError) Error() string {
+	return "two-factor authentication required"
+}
+
+func (s *UserService) EnrollTwoFactor(id uint) (*TwoFactorEnrollmentResponse, error) {
+	user, err := s.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.HasTwoFactor() {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(user).Update("totp_secret", secret).Error; err != nil {
+		return nil, err
+	}
+
+	uri := totpURI(totpIssuer, user.Email, secret)
+	qrCode, err := renderQRCode(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorEnrollmentResponse{
+		Secret:     secret,
+		OTPAuthURI: uri,
+		QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(qrCode),
+	}, nil
+}
+
+func (s *UserService) ConfirmTwoFactor(id uint, code string) (*RecoveryCodesResponse, error) {
+	user, err := s.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.HasTwoFactor() {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	counter, ok := matchTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	var codes []string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(user).Updates(map[string]interface{}{
+			"totp_enabled_at":   time.Now(),
+			"totp_last_counter": counter,
+		}).Error
+		if err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+func (s *UserService) RegenerateRecoveryCodes(id uint, code string) (*RecoveryCodesResponse, error) {
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := verifySecondFactor(tx, id, code); err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+func (s *UserService) DisableTwoFactor(id uint, password, code string) error {
+	user, err := s.GetUser(id)
+	if err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return ErrInvalidPassword
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := verifySecondFactor(tx, id, code); err != nil {
+			return err
+		}
+		err := tx.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"totp_secret":       "",
+			"totp_enabled_at":   nil,
+			"totp_last_counter": 0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id = ?", id).Delete(&RecoveryCode{}).Error
+	})
+}
+
+func (s *UserService) CompleteTwoFactorLogin(challengeToken, code string) (*TokenResponse, error) {
+	var challenge UserToken
+	err := s.db.Where("token_hash = ? AND purpose = ?", hashToken(challengeToken), TokenPurposeLoginChallenge).First(&challenge).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidUserToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if challenge.UsedAt != nil || time.Now().After(challenge.ExpiresAt) {
+		return nil, ErrInvalidUserToken
+	}
+
+	var user User
+	if err := s.db.First(&user, challenge.UserID).Error; err != nil {
+		return nil, err
+	}
+	if err := checkLockout(&user); err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := verifySecondFactor(tx, user.ID, code); err != nil {
+			return err
+		}
+		if _, err := consumeUserToken(tx, challengeToken, TokenPurposeLoginChallenge); err != nil {
+			return err
+		}
+		return tx.First(&user, user.ID).Error
+	})
+	if errors.Is(err, ErrInvalidTwoFactorCode) {
+		if recordErr := recordFailedLogin(s.db, &user); recordErr != nil {
+			return nil, recordErr
+		}
+		if lockErr := checkLockout(&user); lockErr != nil {
+			return nil, lockErr
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := resetFailedLogins(s.db, &user); err != nil {
+		return nil, err
+	}
+	if user.IsSuspended() {
+		return nil, ErrAccountSuspended
+	}
+
+	tokens, _, err := s.issueTokens(s.db, &user, "")
+	return tokens, err
+}
+
+func (s *UserService) twoFactorChallenge(user *User) error {
+	token, err := s.createUserToken(user, TokenPurposeLoginChallenge, loginChallengeTTL)
+	if err != nil {
+		return err
+	}
+	return &TwoFactorRequiredError{Challenge: TwoFactorChallengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresIn:         int64(loginChallengeTTL.Seconds()),
+	}}
+}
+
+func verifySecondFactor(tx *gorm.DB, userID uint, code string) error {
+	var user User
+	if err := tx.First(&user, userID).Error; err != nil {
+		return err
+	}
+	if !user.HasTwoFactor() {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if counter, ok := matchTOTP(user.TOTPSecret, code, time.Now()); ok {
+		result := tx.Model(&User{}).
+			Where("id = ? AND totp_last_counter < ?", userID, counter).
+			Update("totp_last_counter", counter)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	result := tx.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		secret, err := generateTOTPSecret()
+		if err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(secret[:recoveryCodeLength])
+		code := raw[:recoveryCodeLength/2] + "-" + raw[recoveryCodeLength/2:]
+		if err := tx.Create(&RecoveryCode{UserID: userID, CodeHash: hashToken(raw)}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
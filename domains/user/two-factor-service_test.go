@@ -0,0 +1,106 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+)
+
+func enableTwoFactor(t *testing.T, service *UserService, userID uint) (string, []string) {
+	enrollment, err := service.EnrollTwoFactor(userID)
+	if err != nil {
+		t.Fatalf("EnrollTwoFactor failed: %v", err)
+	}
+
+	qr, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(enrollment.QRCode, "data:image/png;base64,"))
+	if err != nil {
+		t.Fatalf("QR code is not base64: %v", err)
+	}
+	if _, err := png.Decode(bytes.NewReader(qr)); err != nil {
+		t.Fatalf("QR code is not a PNG: %v", err)
+	}
+
+	if _, err := service.ConfirmTwoFactor(userID, "000000"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("Expected ErrInvalidTwoFactorCode, got %v", err)
+	}
+
+	code, _ := totpCode(enrollment.Secret, totpCounter(time.Now())-1)
+	codes, err := service.ConfirmTwoFactor(userID, code)
+	if err != nil {
+		t.Fatalf("ConfirmTwoFactor failed: %v", err)
+	}
+	if len(codes.RecoveryCodes) != recoveryCodeCount {
+		t.Errorf("Expected %d recovery codes, got %d", recoveryCodeCount, len(codes.RecoveryCodes))
+	}
+	return enrollment.Secret, codes.RecoveryCodes
+}
+
+func loginChallenge(t *testing.T, service *UserService, email string) string {
+	_, err := service.Login(email, "password123")
+	var challenge *TwoFactorRequiredError
+	if !errors.As(err, &challenge) {
+		t.Fatalf("Expected a two-factor challenge, got %v", err)
+	}
+	return challenge.Challenge.ChallengeToken
+}
+
+func TestUserService_TwoFactorLogin(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewUserService(db, &recordingMailer{})
+
+	usr := &User{Username: "u", Email: "u@example.com", Password: "password123"}
+	service.Register(context.Background(), usr)
+	secret, _ := enableTwoFactor(t, service, usr.ID)
+
+	challenge := loginChallenge(t, service, usr.Email)
+	if _, err := service.CompleteTwoFactorLogin(challenge, "000000"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("Expected ErrInvalidTwoFactorCode, got %v", err)
+	}
+
+	code, _ := totpCode(secret, totpCounter(time.Now()))
+	tokens, err := service.CompleteTwoFactorLogin(challenge, code)
+	if err != nil {
+		t.Fatalf("CompleteTwoFactorLogin failed: %v", err)
+	}
+	if tokens.AccessToken == "" {
+		t.Error("Expected an access token")
+	}
+
+	if _, err := service.CompleteTwoFactorLogin(challenge, code); !errors.Is(err, ErrInvalidUserToken) {
+		t.Errorf("Expected the challenge to be single-use, got %v", err)
+	}
+	if _, err := service.CompleteTwoFactorLogin(loginChallenge(t, service, usr.Email), code); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("Expected a used TOTP code to be rejected, got %v", err)
+	}
+}
+
+func TestUserService_TwoFactorRecoveryCodes(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewUserService(db, &recordingMailer{})
+
+	usr := &User{Username: "u", Email: "u@example.com", Password: "password123"}
+	service.Register(context.Background(), usr)
+	_, recovery := enableTwoFactor(t, service, usr.ID)
+
+	if _, err := service.CompleteTwoFactorLogin(loginChallenge(t, service, usr.Email), strings.ToUpper(recovery[0])); err != nil {
+		t.Fatalf("Login with recovery code failed: %v", err)
+	}
+	if _, err := service.CompleteTwoFactorLogin(loginChallenge(t, service, usr.Email), recovery[0]); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("Expected a recovery code to work only once, got %v", err)
+	}
+
+	if err := service.DisableTwoFactor(usr.ID, "wrong", recovery[1]); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("Expected ErrInvalidPassword, got %v", err)
+	}
+	if err := service.DisableTwoFactor(usr.ID, "password123", recovery[1]); err != nil {
+		t.Fatalf("DisableTwoFactor failed: %v", err)
+	}
+	if _, err := service.Login(usr.Email, "password123"); err != nil {
+		t.Errorf("Expected plain login after disabling 2FA, got %v", err)
+	}
+}
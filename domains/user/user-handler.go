@@ -1,9 +1,14 @@
 package user
 
 import (
+	"errors"
+	"strconv"
+	"time"
+	"vixel/shared/middlewares"
 	"vixel/shared/responses"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type UserHandler struct {
@@ -15,8 +20,26 @@ func NewUserHandler(service *UserService) *UserHandler {
 }
 
 func (h *UserHandler) SetupUserRoutes(rg *gin.RouterGroup) {
-	rg.POST("/users", h.Register())
-	rg.POST("/users/login", h.Login())
+	rg.POST("/users", middlewares.AuthRateLimit(middlewares.AuthRouteRegister), h.Register())
+	rg.POST("/users/login", middlewares.AuthRateLimit(middlewares.AuthRouteLogin), h.Login())
+	rg.POST("/users/login/2fa", middlewares.AuthRateLimit(middlewares.AuthRouteTwoFactor), h.CompleteTwoFactorLogin())
+	rg.POST("/users/token/refresh", middlewares.AuthRateLimit(middlewares.AuthRouteRefresh), h.RefreshToken())
+	rg.POST("/users/logout", middlewares.JWTMiddleware(), h.Logout())
+	rg.POST("/users/email/verify", middlewares.AuthRateLimit(middlewares.AuthRouteVerifyEmail), h.VerifyEmail())
+	rg.POST("/users/email/verify/resend", middlewares.JWTMiddleware(), middlewares.AuthRateLimit(middlewares.AuthRouteResendVerification), h.ResendVerification())
+	rg.POST("/users/password/forgot", middlewares.AuthRateLimit(middlewares.AuthRouteForgotPassword), h.ForgotPassword())
+	rg.POST("/users/password/reset", middlewares.AuthRateLimit(middlewares.AuthRouteResetPassword), h.ResetPassword())
+	rg.GET("/users/me", middlewares.JWTMiddleware(), h.GetMe())
+	rg.PATCH("/users/me", middlewares.JWTMiddleware(), h.UpdateMe())
+	rg.DELETE("/users/me", middlewares.JWTMiddleware(), h.DeleteMe())
+	rg.POST("/users/me/password", middlewares.JWTMiddleware(), h.ChangePassword())
+	rg.POST("/users/me/api-keys", middlewares.JWTMiddleware(), h.CreateAPIKey())
+	rg.GET("/users/me/api-keys", middlewares.JWTMiddleware(), h.ListAPIKeys())
+	rg.DELETE("/users/me/api-keys/:id", middlewares.JWTMiddleware(), h.RevokeAPIKey())
+	rg.POST("/users/me/2fa/enroll", middlewares.JWTMiddleware(), h.EnrollTwoFactor())
+	rg.POST("/users/me/2fa/confirm", middlewares.JWTMiddleware(), h.ConfirmTwoFactor())
+	rg.POST("/users/me/2fa/recovery-codes", middlewares.JWTMiddleware(), h.RegenerateRecoveryCodes())
+	rg.POST("/users/me/2fa/disable", middlewares.JWTMiddleware(), h.DisableTwoFactor())
 }
 
 func (h *UserHandler) Register() gin.HandlerFunc {
@@ -27,7 +50,7 @@ func (h *UserHandler) Register() gin.HandlerFunc {
 			return
 		}
 
-		token, err := h.service.Register(dto.ToUser())
+		token, err := h.service.Register(ctx, dto.ToUser())
 		if err != nil {
 			responses.InternalServerError(ctx, err)
 			return
@@ -38,14 +61,32 @@ func (h *UserHandler) Register() gin.HandlerFunc {
 }
 
 func (h *UserHandler) Login() gin.HandlerFunc {
+	limitUser := middlewares.LoginUserRateLimit()
 	return func(ctx *gin.Context) {
 		var dto LoginDto
 		if err := ctx.ShouldBindJSON(&dto); err != nil {
 			responses.BadRequest(ctx, err)
 			return
 		}
+		if !limitUser(ctx, dto.Email) {
+			return
+		}
 
 		token, err := h.service.Login(dto.Email, dto.Password)
+		var challenge *TwoFactorRequiredError
+		if errors.As(err, &challenge) {
+			responses.Ok(ctx, challenge.Challenge)
+			return
+		}
+		var locked *AccountLockedError
+		if errors.As(err, &locked) {
+			middlewares.AbortTooManyRequests(ctx, locked.RetryAfter(), locked)
+			return
+		}
+		if errors.Is(err, ErrAccountSuspended) {
+			responses.Forbidden(ctx, err)
+			return
+		}
 		if err != nil {
 			responses.Unauthorized(ctx, err)
 			return
@@ -53,4 +94,362 @@ func (h *UserHandler) Login() gin.HandlerFunc {
 
 		responses.Ok(ctx, token)
 	}
-}
\ No newline at end of file
+}
+
+func (h *UserHandler) CompleteTwoFactorLogin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto TwoFactorLoginDto
+		if err := ctx.ShouldBindJSON(&dto); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		token, err := h.service.CompleteTwoFactorLogin(dto.ChallengeToken, dto.Code)
+		if err != nil {
+			h.handleTwoFactorError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, token)
+	}
+}
+
+func (h *UserHandler) RefreshToken() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto RefreshTokenDto
+		if err := ctx.ShouldBindJSON(&dto); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		token, err := h.service.Refresh(dto.RefreshToken)
+		if err != nil {
+			if errors.Is(err, ErrAccountSuspended) {
+				responses.Forbidden(ctx, err)
+				return
+			}
+			if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrRefreshTokenReused) {
+				responses.Unauthorized(ctx, err)
+				return
+			}
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, token)
+	}
+}
+
+func (h *UserHandler) Logout() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto LogoutDto
+		if ctx.Request.ContentLength > 0 {
+			if err := ctx.ShouldBindJSON(&dto); err != nil {
+				responses.BadRequest(ctx, err)
+				return
+			}
+		}
+
+		expiresAt, _ := ctx.Value("token_expires_at").(time.Time)
+		jti, _ := ctx.Value("jti").(string)
+		if err := h.service.Logout(ctx.Value("user_id").(uint), jti, expiresAt, dto.RefreshToken); err != nil {
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, gin.H{"message": "logged out"})
+	}
+}
+
+func (h *UserHandler) VerifyEmail() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto VerifyEmailDto
+		if err := ctx.ShouldBindJSON(&dto); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		user, err := h.service.VerifyEmail(dto.Token)
+		if err != nil {
+			h.handleTokenError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, user.ToResponse())
+	}
+}
+
+func (h *UserHandler) ResendVerification() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		err := h.service.SendVerificationEmail(ctx, ctx.Value("user_id").(uint))
+		if errors.Is(err, ErrEmailAlreadyVerified) {
+			responses.Conflict(ctx, err)
+			return
+		}
+		if err != nil {
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		responses.Accepted(ctx, gin.H{"message": "verification email sent"})
+	}
+}
+
+func (h *UserHandler) ForgotPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto ForgotPasswordDto
+		if err := ctx.ShouldBindJSON(&dto); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		if err := h.service.ForgotPassword(ctx, dto.Email); err != nil {
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		responses.Accepted(ctx, gin.H{"message": "if the address is registered, a reset link has been sent"})
+	}
+}
+
+func (h *UserHandler) ResetPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto ResetPasswordDto
+		if err := ctx.ShouldBindJSON(&dto); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		if err := h.service.ResetPassword(dto.Token, dto.Password); err != nil {
+			h.handleTokenError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, gin.H{"message": "password updated"})
+	}
+}
+
+func (h *UserHandler) GetMe() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user, err := h.service.GetUser(ctx.Value("user_id").(uint))
+		if err != nil {
+			h.handleProfileError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, user.ToResponse())
+	}
+}
+
+func (h *UserHandler) UpdateMe() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto UpdateProfileDto
+		if err := ctx.ShouldBindJSON(&dto); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		user, err := h.service.UpdateProfile(ctx, ctx.Value("user_id").(uint), dto)
+		if err != nil {
+			h.handleProfileError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, user.ToResponse())
+	}
+}
+
+func (h *UserHandler) ChangePassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto ChangePasswordDto
+		if err := ctx.ShouldBindJSON(&dto); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		if err := h.service.ChangePassword(ctx.Value("user_id").(uint), dto.CurrentPassword, dto.NewPassword); err != nil {
+			h.handleProfileError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, gin.H{"message": "password updated"})
+	}
+}
+
+func (h *UserHandler) DeleteMe() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		expiresAt, _ := ctx.Value("token_expires_at").(time.Time)
+		jti, _ := ctx.Value("jti").(string)
+		if err := h.service.DeleteAccount(ctx, ctx.Value("user_id").(uint), jti, expiresAt); err != nil {
+			h.handleProfileError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, gin.H{"message": "account deleted"})
+	}
+}
+
+func (h *UserHandler) CreateAPIKey() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto CreateAPIKeyDto
+		if err := ctx.ShouldBindJSON(&dto); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		key, err := h.service.CreateAPIKey(ctx.Value("user_id").(uint), dto)
+		if errors.Is(err, ErrAPIKeyExpiryInPast) {
+			responses.BadRequest(ctx, err)
+			return
+		}
+		if err != nil {
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		responses.Created(ctx, key)
+	}
+}
+
+func (h *UserHandler) ListAPIKeys() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		keys, err := h.service.ListAPIKeys(ctx.Value("user_id").(uint))
+		if err != nil {
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		keyResponses := make([]APIKeyResponse, 0, len(keys))
+		for _, key := range keys {
+			keyResponses = append(keyResponses, key.ToResponse())
+		}
+
+		responses.Ok(ctx, keyResponses)
+	}
+}
+
+func (h *UserHandler) RevokeAPIKey() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+		if err != nil {
+			responses.BadRequest(ctx, errors.New("invalid api key id"))
+			return
+		}
+
+		key, err := h.service.RevokeAPIKey(ctx.Value("user_id").(uint), uint(id))
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			responses.NotFound(ctx, err)
+			return
+		}
+		if err != nil {
+			responses.InternalServerError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, key.ToResponse())
+	}
+}
+
+func (h *UserHandler) EnrollTwoFactor() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		enrollment, err := h.service.EnrollTwoFactor(ctx.Value("user_id").(uint))
+		if err != nil {
+			h.handleTwoFactorError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, enrollment)
+	}
+}
+
+func (h *UserHandler) ConfirmTwoFactor() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto TwoFactorCodeDto
+		if err := ctx.ShouldBindJSON(&dto); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		codes, err := h.service.ConfirmTwoFactor(ctx.Value("user_id").(uint), dto.Code)
+		if err != nil {
+			h.handleTwoFactorError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, codes)
+	}
+}
+
+func (h *UserHandler) RegenerateRecoveryCodes() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto TwoFactorCodeDto
+		if err := ctx.ShouldBindJSON(&dto); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		codes, err := h.service.RegenerateRecoveryCodes(ctx.Value("user_id").(uint), dto.Code)
+		if err != nil {
+			h.handleTwoFactorError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, codes)
+	}
+}
+
+func (h *UserHandler) DisableTwoFactor() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto DisableTwoFactorDto
+		if err := ctx.ShouldBindJSON(&dto); err != nil {
+			responses.BadRequest(ctx, err)
+			return
+		}
+
+		if err := h.service.DisableTwoFactor(ctx.Value("user_id").(uint), dto.Password, dto.Code); err != nil {
+			h.handleTwoFactorError(ctx, err)
+			return
+		}
+
+		responses.Ok(ctx, gin.H{"message": "two-factor authentication disabled"})
+	}
+}
+
+func (h *UserHandler) handleTwoFactorError(ctx *gin.Context, err error) {
+	var locked *AccountLockedError
+	switch {
+	case errors.As(err, &locked):
+		middlewares.AbortTooManyRequests(ctx, locked.RetryAfter(), locked)
+	case errors.Is(err, ErrInvalidUserToken), errors.Is(err, ErrInvalidTwoFactorCode), errors.Is(err, ErrInvalidPassword):
+		responses.Unauthorized(ctx, err)
+	case errors.Is(err, ErrTwoFactorAlreadyEnabled):
+		responses.Conflict(ctx, err)
+	case errors.Is(err, ErrTwoFactorNotEnrolled), errors.Is(err, ErrTwoFactorNotEnabled):
+		responses.BadRequest(ctx, err)
+	case errors.Is(err, ErrAccountSuspended):
+		responses.Forbidden(ctx, err)
+	default:
+		h.handleProfileError(ctx, err)
+	}
+}
+
+func (h *UserHandler) handleProfileError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		responses.NotFound(ctx, errors.New("user not found"))
+	case errors.Is(err, ErrInvalidPassword):
+		responses.Unauthorized(ctx, err)
+	case errors.Is(err, ErrEmailTaken), errors.Is(err, ErrUsernameTaken):
+		responses.Conflict(ctx, err)
+	default:
+		responses.InternalServerError(ctx, err)
+	}
+}
+
+func (h *UserHandler) handleTokenError(ctx *gin.Context, err error) {
+	if errors.Is(err, ErrInvalidUserToken) {
+		responses.BadRequest(ctx, err)
+		return
+	}
+	responses.InternalServerError(ctx, err)
+}
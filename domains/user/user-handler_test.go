@@ -2,12 +2,15 @@ package user
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"vixel/config"
+	"vixel/shared/middlewares"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/sqlite"
@@ -20,8 +23,8 @@ func setupTestUserHandler(t *testing.T) (*UserHandler, *gorm.DB) {
 	if err != nil {
 		t.Fatalf("Failed to open test DB: %v", err)
 	}
-	db.AutoMigrate(&User{})
-	service := NewUserService(db)
+	db.AutoMigrate(&User{}, &RefreshToken{}, &RevokedToken{}, &UserToken{}, &APIKey{}, &UserIdentity{}, &OIDCState{}, &RecoveryCode{})
+	service := NewUserService(db, &recordingMailer{})
 	handler := NewUserHandler(service)
 	return handler, db
 }
@@ -75,8 +78,8 @@ func TestUserHandler_Login_Success(t *testing.T) {
 		Email:    "test@example.com",
 		Password: "password123",
 	}
-	service := NewUserService(db)
-	_, err := service.Register(user)
+	service := NewUserService(db, &recordingMailer{})
+	_, err := service.Register(context.Background(), user)
 	if err != nil {
 		t.Fatalf("Register failed: %v", err)
 	}
@@ -137,3 +140,133 @@ func TestUserHandler_Login_InvalidCredentials(t *testing.T) {
 		t.Errorf("Expected status 401, got %d", w.Code)
 	}
 }
+
+func TestUserHandler_Login_LimitsAttemptsPerAccount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, db := setupTestUserHandler(t)
+	config.Config.RateLimitLoginUser = "2/1m"
+	config.Config.LoginMaxAttempts = 3
+	middlewares.SetRateLimitStore(middlewares.NewMemoryRateLimitStore())
+	t.Cleanup(func() {
+		config.Config.RateLimitLoginUser = ""
+		config.Config.LoginMaxAttempts = 0
+	})
+
+	router := gin.New()
+	handler.SetupUserRoutes(router.Group(""))
+	user := &User{Username: "u", Email: "u@example.com", Password: "password123"}
+	handler.service.Register(context.Background(), user)
+
+	codes := make([]int, 3)
+	for i := range codes {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/users/login", bytes.NewBufferString(`{"email":"u@example.com","password":"wrong-password"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = fmt.Sprintf("10.0.0.%d:1234", i+1)
+		router.ServeHTTP(w, req)
+		codes[i] = w.Code
+	}
+	if codes[0] != http.StatusUnauthorized || codes[1] != http.StatusUnauthorized || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("Expected the third attempt from another IP to be throttled, got %v", codes)
+	}
+
+	db.First(user, user.ID)
+	if user.FailedLogins != 2 || user.LockedUntil != nil {
+		t.Errorf("Throttled attempts should not count towards the lockout, got %d failures", user.FailedLogins)
+	}
+}
+
+func TestUserHandler_RefreshToken_Invalid(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _ := setupTestUserHandler(t)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request = httptest.NewRequest("POST", "/users/token/refresh", bytes.NewBufferString(`{"refresh_token":"bogus"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	handler.RefreshToken()(c)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+}
+
+func TestUserHandler_Logout_RevokesAccessToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, db := setupTestUserHandler(t)
+	middlewares.SetTokenDenylist(handler.service)
+	defer middlewares.SetTokenDenylist(nil)
+
+	router := gin.New()
+	handler.SetupUserRoutes(router.Group(""))
+
+	tokens, err := NewUserService(db, &recordingMailer{}).Register(context.Background(), &User{Username: "u", Email: "u@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	logout := func() int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/users/logout", bytes.NewBufferString(`{"refresh_token":"`+tokens.RefreshToken+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := logout(); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if code := logout(); code != http.StatusUnauthorized {
+		t.Errorf("Expected revoked token to be rejected with 401, got %d", code)
+	}
+}
+
+func TestUserHandler_Me(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _ := setupTestUserHandler(t)
+
+	router := gin.New()
+	handler.SetupUserRoutes(router.Group(""))
+
+	tokens, err := handler.service.Register(context.Background(), &User{Username: "u", Email: "u@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("PATCH", "/users/me", `{"username":"renamed"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	w = do("GET", "/users/me", "")
+	var response struct {
+		Data UserResponse `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response.Data.Username != "renamed" {
+		t.Errorf("Expected renamed profile, got %d %s", w.Code, w.Body.String())
+	}
+
+	if w := do("POST", "/users/me/password", `{"current_password":"wrong","new_password":"newpassword"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for wrong current password, got %d", w.Code)
+	}
+
+	if w := do("DELETE", "/users/me", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if w := do("GET", "/users/me", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after deletion, got %d", w.Code)
+	}
+}
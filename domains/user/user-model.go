@@ -1,17 +1,74 @@
 package user
 
-import "gorm.io/gorm"
+import (
+	"slices"
+	"strings"
+	"time"
+	"vixel/config"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+func IsValidRole(role string) bool {
+	return role == RoleUser || role == RoleAdmin
+}
+
+const (
+	StripMetadataNone = "none"
+	StripMetadataGPS  = "gps"
+	StripMetadataAll  = "all"
+)
 
 type User struct {
 	gorm.Model
-	Username string `gorm:"uniqueIndex;not null"`
-	Email    string `gorm:"uniqueIndex;not null"`
-	Password string `gorm:"not null"`
+	Username        string `gorm:"uniqueIndex;not null"`
+	Email           string `gorm:"uniqueIndex;not null"`
+	Password        string `gorm:"not null"`
+	Role            string `gorm:"not null;default:user"`
+	EmailVerifiedAt *time.Time
+	SuspendedAt     *time.Time
+	TOTPSecret      string
+	TOTPEnabledAt   *time.Time
+	TOTPLastCounter int64
+	FailedLogins    int `gorm:"not null;default:0"`
+	LockedUntil     *time.Time
+	QuotaBytes      *int64
+	QuotaObjects    *int64
+	StripMetadata   string `gorm:"not null;default:none"`
+}
+
+func (u User) HasTwoFactor() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+func (u User) IsLocked(now time.Time) bool {
+	return u.LockedUntil != nil && now.Before(*u.LockedUntil)
+}
+
+func (u User) IsSuspended() bool {
+	return u.SuspendedAt != nil
 }
 
 func (u User) ToResponse() UserResponse {
 	return UserResponse{
-		ID:    u.ID,
-		Email: u.Email,
+		ID:            u.ID,
+		Username:      u.Username,
+		Email:         u.Email,
+		Role:          u.Role,
+		EmailVerified: u.EmailVerifiedAt != nil,
+		Suspended:     u.IsSuspended(),
+		TwoFactor:     u.HasTwoFactor(),
+		StripMetadata: u.StripMetadata,
 	}
 }
+
+func isAdminEmail(email string) bool {
+	return slices.ContainsFunc(config.Config.AdminEmails, func(admin string) bool {
+		return strings.EqualFold(admin, email)
+	})
+}
@@ -1,16 +1,34 @@
 package user
 
 import (
+	"context"
+	"errors"
+	"log"
+	"time"
+	"vixel/shared/mailer"
+
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+	ErrAccountSuspended    = errors.New("account is suspended")
+)
+
+type ContentCleaner interface {
+	DeleteUserContent(ctx context.Context, userID uint) error
+}
+
 type UserService struct {
-	db *gorm.DB
+	db       *gorm.DB
+	mailer   mailer.Mailer
+	cleaners []ContentCleaner
 }
 
-func NewUserService(db *gorm.DB) *UserService {
-	return &UserService{db: db}
+func NewUserService(db *gorm.DB, mailer mailer.Mailer, cleaners ...ContentCleaner) *UserService {
+	return &UserService{db: db, mailer: mailer, cleaners: cleaners}
 }
 
 func hashPassword(password string) string {
@@ -18,19 +36,19 @@ func hashPassword(password string) string {
 	return string(hashed)
 }
 
-func (s *UserService) Register(user *User) (*TokenResponse, error) {
+func (s *UserService) Register(ctx context.Context, user *User) (*TokenResponse, error) {
 	user.Password = hashPassword(user.Password)
+	user.Role = RoleUser
 	if err := s.db.Create(user).Error; err != nil {
 		return nil, err
 	}
 
-	token, err := generateAccessToken(user)
-	if err != nil {
-		return nil, err
+	if err := s.SendVerificationEmail(ctx, user.ID); err != nil {
+		log.Printf("failed to send verification email to user %d: %v", user.ID, err)
 	}
-	return &TokenResponse{
-		AccessToken: token,
-	}, nil
+
+	tokens, _, err := s.issueTokens(s.db, user, "")
+	return tokens, err
 }
 
 func (s *UserService) Login(email, password string) (*TokenResponse, error) {
@@ -39,15 +57,177 @@ func (s *UserService) Login(email, password string) (*TokenResponse, error) {
 		return nil, err
 	}
 
+	if err := checkLockout(&user); err != nil {
+		return nil, err
+	}
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		if recordErr := recordFailedLogin(s.db, &user); recordErr != nil {
+			return nil, recordErr
+		}
+		if lockErr := checkLockout(&user); lockErr != nil {
+			return nil, lockErr
+		}
 		return nil, err
 	}
+	if user.IsSuspended() {
+		return nil, ErrAccountSuspended
+	}
+	if user.HasTwoFactor() {
+		return nil, s.twoFactorChallenge(&user)
+	}
+	if err := resetFailedLogins(s.db, &user); err != nil {
+		return nil, err
+	}
+
+	tokens, _, err := s.issueTokens(s.db, &user, "")
+	return tokens, err
+}
+
+func (s *UserService) Refresh(refreshToken string) (*TokenResponse, error) {
+	var tokens *TokenResponse
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var current RefreshToken
+		if err := tx.Where("token_hash = ?", hashToken(refreshToken)).First(&current).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+		if current.RevokedAt != nil {
+			return ErrRefreshTokenReused
+		}
+		if time.Now().After(current.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", current.ID).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+
+		var user User
+		if err := tx.First(&user, current.UserID).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+		if user.IsSuspended() {
+			return ErrAccountSuspended
+		}
+
+		issued, next, err := s.issueTokens(tx, &user, current.FamilyID)
+		if err != nil {
+			return err
+		}
+		tokens = issued
 
-	token, err := generateAccessToken(&user)
+		return tx.Model(&current).Update("replaced_by_id", next.ID).Error
+	})
+	if errors.Is(err, ErrRefreshTokenReused) {
+		if revokeErr := s.revokeFamilyOf(refreshToken); revokeErr != nil {
+			return nil, revokeErr
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
+	return tokens, nil
+}
+
+func (s *UserService) Logout(userID uint, jti string, expiresAt time.Time, refreshToken string) error {
+	if refreshToken != "" {
+		var token RefreshToken
+		err := s.db.Where("token_hash = ? AND user_id = ?", hashToken(refreshToken), userID).First(&token).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil {
+			if err := s.revokeFamily(token.FamilyID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if jti == "" {
+		return nil
+	}
+	if err := s.db.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return s.db.Save(&RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+}
+
+// IsRevoked reports whether the access token has been revoked or its user
+// suspended, and returns the user's current role so that role changes apply
+// without waiting for the token to expire.
+func (s *UserService) IsRevoked(userID uint, jti string) (bool, string, error) {
+	if jti != "" {
+		var count int64
+		if err := s.db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+			return false, "", err
+		}
+		if count > 0 {
+			return true, "", nil
+		}
+	}
+
+	var user User
+	err := s.db.Select("role").Where("id = ? AND suspended_at IS NULL", userID).Take(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	return false, user.Role, nil
+}
+
+func (s *UserService) issueTokens(db *gorm.DB, user *User, familyID string) (*TokenResponse, *RefreshToken, error) {
+	accessToken, err := generateAccessToken(user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	if familyID == "" {
+		if familyID, err = randomToken(16); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	stored := &RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(refreshTokenTTL()),
+	}
+	if err := db.Create(stored).Error; err != nil {
+		return nil, nil, err
+	}
+
 	return &TokenResponse{
-		AccessToken: token,
-	}, nil
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL().Seconds()),
+	}, stored, nil
+}
+
+func (s *UserService) revokeFamilyOf(refreshToken string) error {
+	var token RefreshToken
+	if err := s.db.Where("token_hash = ?", hashToken(refreshToken)).First(&token).Error; err != nil {
+		return err
+	}
+	return s.revokeFamily(token.FamilyID)
+}
+
+func (s *UserService) revokeFamily(familyID string) error {
+	return s.db.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
 }
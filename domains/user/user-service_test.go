@@ -1,22 +1,48 @@
 package user
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"vixel/config"
+	"vixel/shared/mailer"
 
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+type recordingMailer struct {
+	sent []mailer.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *recordingMailer) lastToken(t *testing.T) string {
+	if len(m.sent) == 0 {
+		t.Fatal("No email was sent")
+	}
+	body := m.sent[len(m.sent)-1].Body
+	i := strings.Index(body, "Token: ")
+	if i < 0 {
+		t.Fatalf("No token in email: %s", body)
+	}
+	return strings.Fields(body[i+len("Token: "):])[0]
+}
+
 func setupTestDB(t *testing.T) *gorm.DB {
 	config.Config.JWTSecret = "test_secret"
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	if err != nil {
 		t.Fatalf("Failed to open test DB: %v", err)
 	}
-	db.AutoMigrate(&User{})
+	db.AutoMigrate(&User{}, &RefreshToken{}, &RevokedToken{}, &UserToken{}, &APIKey{}, &UserIdentity{}, &OIDCState{}, &RecoveryCode{})
 	return db
 }
 
@@ -36,7 +62,7 @@ func TestHashPassword(t *testing.T) {
 
 func TestUserService_Register(t *testing.T) {
 	db := setupTestDB(t)
-	service := NewUserService(db)
+	service := NewUserService(db, &recordingMailer{})
 
 	user := &User{
 		Username: "testuser",
@@ -44,7 +70,7 @@ func TestUserService_Register(t *testing.T) {
 		Password: "password123",
 	}
 
-	token, err := service.Register(user)
+	token, err := service.Register(context.Background(), user)
 	if err != nil {
 		t.Fatalf("Register failed: %v", err)
 	}
@@ -69,14 +95,14 @@ func TestUserService_Register(t *testing.T) {
 
 func TestUserService_Login_Success(t *testing.T) {
 	db := setupTestDB(t)
-	service := NewUserService(db)
+	service := NewUserService(db, &recordingMailer{})
 
 	user := &User{
 		Username: "testuser",
 		Email:    "test@example.com",
 		Password: "password123",
 	}
-	_, err := service.Register(user)
+	_, err := service.Register(context.Background(), user)
 	if err != nil {
 		t.Fatalf("Register failed: %v", err)
 	}
@@ -93,7 +119,7 @@ func TestUserService_Login_Success(t *testing.T) {
 
 func TestUserService_Login_InvalidEmail(t *testing.T) {
 	db := setupTestDB(t)
-	service := NewUserService(db)
+	service := NewUserService(db, &recordingMailer{})
 
 	_, err := service.Login("nonexistent@example.com", "password")
 	if err == nil {
@@ -103,14 +129,14 @@ func TestUserService_Login_InvalidEmail(t *testing.T) {
 
 func TestUserService_Login_InvalidPassword(t *testing.T) {
 	db := setupTestDB(t)
-	service := NewUserService(db)
+	service := NewUserService(db, &recordingMailer{})
 
 	user := &User{
 		Username: "testuser",
 		Email:    "test@example.com",
 		Password: "password123",
 	}
-	_, err := service.Register(user)
+	_, err := service.Register(context.Background(), user)
 	if err != nil {
 		t.Fatalf("Register failed: %v", err)
 	}
@@ -119,4 +145,194 @@ func TestUserService_Login_InvalidPassword(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error for invalid password")
 	}
-}
\ No newline at end of file
+}
+
+func TestUserService_Refresh_RotatesToken(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewUserService(db, &recordingMailer{})
+
+	issued, err := service.Register(context.Background(), &User{Username: "u", Email: "u@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	refreshed, err := service.Refresh(issued.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	if refreshed.RefreshToken == issued.RefreshToken || refreshed.AccessToken == "" {
+		t.Error("Expected a new token pair")
+	}
+
+	var old RefreshToken
+	db.Where("token_hash = ?", hashToken(issued.RefreshToken)).First(&old)
+	if old.RevokedAt == nil || old.ReplacedByID == nil {
+		t.Error("Old refresh token should be revoked and linked to its replacement")
+	}
+}
+
+func TestUserService_Refresh_ReuseRevokesFamily(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewUserService(db, &recordingMailer{})
+
+	issued, _ := service.Register(context.Background(), &User{Username: "u", Email: "u@example.com", Password: "password123"})
+	refreshed, _ := service.Refresh(issued.RefreshToken)
+
+	if _, err := service.Refresh(issued.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Expected ErrRefreshTokenReused, got %v", err)
+	}
+
+	if _, err := service.Refresh(refreshed.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("Expected the whole family to be revoked, got %v", err)
+	}
+}
+
+func TestUserService_Refresh_Invalid(t *testing.T) {
+	service := NewUserService(setupTestDB(t), &recordingMailer{})
+
+	if _, err := service.Refresh("unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Expected ErrInvalidRefreshToken, got %v", err)
+	}
+}
+
+func TestUserService_Logout(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewUserService(db, &recordingMailer{})
+
+	usr := &User{Username: "u", Email: "u@example.com", Password: "password123"}
+	issued, _ := service.Register(context.Background(), usr)
+
+	if err := service.Logout(usr.ID, "jti-1", time.Now().Add(time.Hour), issued.RefreshToken); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+
+	if _, err := service.Refresh(issued.RefreshToken); err == nil {
+		t.Error("Refresh token should be revoked after logout")
+	}
+
+	revoked, _, err := service.IsRevoked(usr.ID, "jti-1")
+	if err != nil || !revoked {
+		t.Errorf("Access token should be denylisted, got %v %v", revoked, err)
+	}
+}
+
+func TestUserService_VerifyEmail(t *testing.T) {
+	db := setupTestDB(t)
+	mail := &recordingMailer{}
+	service := NewUserService(db, mail)
+
+	usr := &User{Username: "u", Email: "u@example.com", Password: "password123"}
+	if _, err := service.Register(context.Background(), usr); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	if len(mail.sent) != 1 || mail.sent[0].To != "u@example.com" {
+		t.Fatalf("Expected a verification email, got %+v", mail.sent)
+	}
+
+	token := mail.lastToken(t)
+	verified, err := service.VerifyEmail(token)
+	if err != nil {
+		t.Fatalf("VerifyEmail failed: %v", err)
+	}
+	if verified.EmailVerifiedAt == nil {
+		t.Error("Email should be marked as verified")
+	}
+
+	if _, err := service.VerifyEmail(token); !errors.Is(err, ErrInvalidUserToken) {
+		t.Errorf("Expected token to be single-use, got %v", err)
+	}
+
+	if err := service.SendVerificationEmail(context.Background(), usr.ID); !errors.Is(err, ErrEmailAlreadyVerified) {
+		t.Errorf("Expected ErrEmailAlreadyVerified, got %v", err)
+	}
+}
+
+func TestUserService_ResetPassword(t *testing.T) {
+	db := setupTestDB(t)
+	mail := &recordingMailer{}
+	service := NewUserService(db, mail)
+
+	issued, _ := service.Register(context.Background(), &User{Username: "u", Email: "u@example.com", Password: "password123"})
+
+	if err := service.ForgotPassword(context.Background(), "u@example.com"); err != nil {
+		t.Fatalf("ForgotPassword failed: %v", err)
+	}
+	token := mail.lastToken(t)
+
+	if err := service.ResetPassword(token, "newpassword"); err != nil {
+		t.Fatalf("ResetPassword failed: %v", err)
+	}
+
+	if _, err := service.Login("u@example.com", "password123"); err == nil {
+		t.Error("Old password should no longer work")
+	}
+	if _, err := service.Login("u@example.com", "newpassword"); err != nil {
+		t.Errorf("New password should work: %v", err)
+	}
+	if _, err := service.Refresh(issued.RefreshToken); err == nil {
+		t.Error("Existing sessions should be revoked after a password reset")
+	}
+	if err := service.ResetPassword(token, "another"); !errors.Is(err, ErrInvalidUserToken) {
+		t.Errorf("Expected reset token to be single-use, got %v", err)
+	}
+}
+
+func TestUserService_ForgotPassword_UnknownEmail(t *testing.T) {
+	mail := &recordingMailer{}
+	service := NewUserService(setupTestDB(t), mail)
+
+	if err := service.ForgotPassword(context.Background(), "nobody@example.com"); err != nil {
+		t.Errorf("Expected no error for unknown email, got %v", err)
+	}
+	if len(mail.sent) != 0 {
+		t.Error("No email should be sent for unknown addresses")
+	}
+}
+
+func TestUserService_ResetPassword_ExpiredToken(t *testing.T) {
+	db := setupTestDB(t)
+	mail := &recordingMailer{}
+	service := NewUserService(db, mail)
+
+	service.Register(context.Background(), &User{Username: "u", Email: "u@example.com", Password: "password123"})
+	service.ForgotPassword(context.Background(), "u@example.com")
+	token := mail.lastToken(t)
+	db.Model(&UserToken{}).Where("purpose = ?", TokenPurposeResetPassword).Update("expires_at", time.Now().Add(-time.Minute))
+
+	if err := service.ResetPassword(token, "newpassword"); !errors.Is(err, ErrInvalidUserToken) {
+		t.Errorf("Expected ErrInvalidUserToken, got %v", err)
+	}
+}
+
+func TestUserService_AdminEmailsRequireVerification(t *testing.T) {
+	db := setupTestDB(t)
+	mail := &recordingMailer{}
+	service := NewUserService(db, mail)
+
+	previous := *config.Config
+	t.Cleanup(func() { *config.Config = previous })
+	config.Config.AdminEmails = []string{"admin@example.com"}
+
+	usr := &User{Username: "admin", Email: "admin@example.com", Password: "password123"}
+	if _, err := service.Register(context.Background(), usr); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := service.PromoteAdmins(config.Config.AdminEmails); err != nil {
+		t.Fatalf("PromoteAdmins failed: %v", err)
+	}
+
+	registered, _ := service.GetUser(usr.ID)
+	if registered.Role != RoleUser {
+		t.Fatalf("Expected an unverified admin email to stay a user, got %s", registered.Role)
+	}
+
+	verified, err := service.VerifyEmail(mail.lastToken(t))
+	if err != nil {
+		t.Fatalf("VerifyEmail failed: %v", err)
+	}
+	if verified.Role != RoleAdmin {
+		t.Errorf("Expected verifying an admin email to promote the user, got %s", verified.Role)
+	}
+}
@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"vixel/config"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+func New() (Mailer, error) {
+	switch config.Config.MailerDriver {
+	case "smtp":
+		return NewSMTPMailer(), nil
+	case "", "log":
+		if config.Config.MailerLogPath == "" {
+			return NewLogMailer(os.Stdout), nil
+		}
+		f, err := os.OpenFile(config.Config.MailerLogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		return NewLogMailer(f), nil
+	}
+	return nil, fmt.Errorf("unknown mailer driver %q", config.Config.MailerDriver)
+}
@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLogMailer_Send(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(&buf)
+
+	if err := m.Send(context.Background(), Message{To: "u@example.com", Subject: "Hello", Body: "World"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	for _, expected := range []string{"To: u@example.com", "Subject: Hello", "World"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected %q in output %q", expected, buf.String())
+		}
+	}
+}
+
+func TestSMTPMailer_RejectsHeaderInjection(t *testing.T) {
+	m := &SMTPMailer{addr: "localhost:0", from: "no-reply@example.com"}
+
+	err := m.Send(context.Background(), Message{To: "u@example.com\r\nBcc: x@example.com", Subject: "Hi"})
+	if err == nil || !strings.Contains(err.Error(), "invalid header") {
+		t.Errorf("Expected invalid header error, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrInvalidAPIKey = errors.New("invalid, expired or revoked API key")
+
+type APIKeyPrincipal struct {
+	UserID        uint
+	Role          string
+	EmailVerified bool
+	Scopes        []string
+}
+
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(key string) (*APIKeyPrincipal, error)
+}
+
+var apiKeys APIKeyAuthenticator
+
+func SetAPIKeyAuthenticator(a APIKeyAuthenticator) {
+	apiKeys = a
+}
+
+func authenticateAPIKey(ctx *gin.Context, key string, scopes []string) {
+	if len(scopes) == 0 {
+		ctx.AbortWithStatusJSON(403, gin.H{"error": "API keys cannot access this endpoint"})
+		return
+	}
+	if apiKeys == nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": "API keys are not supported"})
+		return
+	}
+
+	principal, err := apiKeys.AuthenticateAPIKey(key)
+	if errors.Is(err, ErrInvalidAPIKey) {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": "Invalid, expired or revoked API key"})
+		return
+	}
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Failed to check API key"})
+		return
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(principal.Scopes, scope) {
+			ctx.AbortWithStatusJSON(403, gin.H{"error": "API key is missing scope " + scope})
+			return
+		}
+	}
+
+	ctx.Set("user_id", principal.UserID)
+	ctx.Set("role", principal.Role)
+	ctx.Set("email_verified", principal.EmailVerified)
+	ctx.Set("api_key_scopes", principal.Scopes)
+	ctx.Next()
+}
@@ -8,6 +8,16 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+type TokenDenylist interface {
+	IsRevoked(userID uint, jti string) (bool, string, error)
+}
+
+var denylist TokenDenylist
+
+func SetTokenDenylist(d TokenDenylist) {
+	denylist = d
+}
+
 func validateAccessToken(header string) (*jwt.Token, error) {
 	token := strings.TrimPrefix(header, "Bearer ")
 	return jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
@@ -15,7 +25,7 @@ func validateAccessToken(header string) (*jwt.Token, error) {
 	})
 }
 
-func JWTMiddleware() gin.HandlerFunc {
+func JWTMiddleware(apiKeyScopes ...string) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		authHeader := ctx.GetHeader("Authorization")
 		if authHeader == "" {
@@ -23,6 +33,11 @@ func JWTMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if key, ok := strings.CutPrefix(authHeader, "ApiKey "); ok {
+			authenticateAPIKey(ctx, key, apiKeyScopes)
+			return
+		}
+
 		token, err := validateAccessToken(authHeader)
 		if err != nil || !token.Valid {
 			ctx.AbortWithStatusJSON(401, gin.H{"error": "Invalid or expired token"})
@@ -42,7 +57,29 @@ func JWTMiddleware() gin.HandlerFunc {
 		}
 
 		userID := uint(userIDFloat)
+		jti, _ := claims["jti"].(string)
+		role, _ := claims["role"].(string)
+		if denylist != nil {
+			revoked, current, err := denylist.IsRevoked(userID, jti)
+			if err != nil {
+				ctx.AbortWithStatusJSON(500, gin.H{"error": "Failed to check token revocation"})
+				return
+			}
+			if revoked {
+				ctx.AbortWithStatusJSON(401, gin.H{"error": "Token has been revoked"})
+				return
+			}
+			role = current
+		}
+
 		ctx.Set("user_id", userID)
+		ctx.Set("jti", jti)
+		ctx.Set("role", role)
+		emailVerified, _ := claims["email_verified"].(bool)
+		ctx.Set("email_verified", emailVerified)
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			ctx.Set("token_expires_at", exp.Time)
+		}
 		ctx.Next()
 	}
 }
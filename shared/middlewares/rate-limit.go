@@ -0,0 +1,196 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"vixel/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+func ParseLimit(value string) (Limit, error) {
+	if value == "" || value == "0" {
+		return Limit{}, nil
+	}
+	requests, per, ok := strings.Cut(value, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected <requests>/<duration>", value)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: bad request count", value)
+	}
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: bad duration", value)
+	}
+	return Limit{Requests: n, Per: d}, nil
+}
+
+type RateLimitPolicy struct {
+	Name    string
+	PerIP   Limit
+	PerUser Limit
+}
+
+var rateLimitStore RateLimitStore = NewMemoryRateLimitStore()
+
+func SetRateLimitStore(s RateLimitStore) {
+	rateLimitStore = s
+}
+
+func RateLimit(policy RateLimitPolicy) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var checks []rateLimitCheck
+		if policy.PerIP.Enabled() {
+			checks = append(checks, rateLimitCheck{policy.Name + ":ip:" + ctx.ClientIP(), policy.PerIP})
+		}
+		if userID, ok := ctx.Value("user_id").(uint); ok && policy.PerUser.Enabled() {
+			checks = append(checks, rateLimitCheck{policy.Name + ":user:" + strconv.FormatUint(uint64(userID), 10), policy.PerUser})
+		}
+		if takeRateLimits(ctx, checks) {
+			ctx.Next()
+		}
+	}
+}
+
+// takeRateLimits takes a token from every bucket and aborts the request when
+// one of them is empty. It reports whether the request may proceed.
+func takeRateLimits(ctx *gin.Context, checks []rateLimitCheck) bool {
+	if len(checks) == 0 {
+		return true
+	}
+
+	var tightest *RateLimitResult
+	for _, check := range checks {
+		result, err := rateLimitStore.Take(ctx, check.key, check.limit)
+		if err != nil {
+			ctx.AbortWithStatusJSON(500, gin.H{"error": "Failed to check rate limit"})
+			return false
+		}
+		if tightest == nil || !result.Allowed || (tightest.Allowed && result.Remaining < tightest.Remaining) {
+			tightest = &result
+		}
+		if !result.Allowed {
+			break
+		}
+	}
+
+	setRateLimitHeaders(ctx, *tightest)
+	if !tightest.Allowed {
+		AbortTooManyRequests(ctx, tightest.RetryAfter, errors.New("Rate limit exceeded"))
+		return false
+	}
+	return true
+}
+
+func AbortTooManyRequests(ctx *gin.Context, retryAfter time.Duration, err error) {
+	ctx.Header("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+	ctx.AbortWithStatusJSON(429, gin.H{"error": err.Error()})
+}
+
+const (
+	AuthRouteRegister           = "register"
+	AuthRouteLogin              = "login"
+	AuthRouteTwoFactor          = "2fa"
+	AuthRouteRefresh            = "refresh"
+	AuthRouteVerifyEmail        = "verify-email"
+	AuthRouteResendVerification = "resend-verification"
+	AuthRouteForgotPassword     = "forgot-password"
+	AuthRouteResetPassword      = "reset-password"
+	AuthRouteOIDCLogin          = "oidc-login"
+	AuthRouteOIDCCallback       = "oidc-callback"
+)
+
+// AuthRateLimit limits an authentication route per client IP. Every route has
+// its own bucket, so refreshing tokens or finishing a sign-on does not use up
+// login attempts.
+func AuthRateLimit(route string) gin.HandlerFunc {
+	return RateLimit(RateLimitPolicy{Name: "auth:" + route, PerIP: configuredLimit(authRouteLimit(route))})
+}
+
+func authRouteLimit(route string) string {
+	switch route {
+	case AuthRouteRegister:
+		return config.Config.RateLimitRegister
+	case AuthRouteLogin:
+		return config.Config.RateLimitLogin
+	case AuthRouteTwoFactor:
+		return config.Config.RateLimitTwoFactor
+	case AuthRouteRefresh:
+		return config.Config.RateLimitRefresh
+	case AuthRouteOIDCLogin, AuthRouteOIDCCallback:
+		return config.Config.RateLimitOIDC
+	}
+	return config.Config.RateLimitAuth
+}
+
+// LoginUserRateLimit returns a limiter for login attempts against one
+// account, counted across all client IPs. Handlers call it before checking
+// the password, so throttled attempts do not count towards the lockout. It
+// aborts the request and returns false once the account's bucket is empty.
+func LoginUserRateLimit() func(ctx *gin.Context, login string) bool {
+	limit := configuredLimit(config.Config.RateLimitLoginUser)
+	return func(ctx *gin.Context, login string) bool {
+		if !limit.Enabled() {
+			return true
+		}
+		return takeRateLimits(ctx, []rateLimitCheck{{"auth:login:user:" + strings.ToLower(login), limit}})
+	}
+}
+
+func ProcessingRateLimit() gin.HandlerFunc {
+	return RateLimit(RateLimitPolicy{
+		Name:    "processing",
+		PerIP:   configuredLimit(config.Config.RateLimitProcessing),
+		PerUser: configuredLimit(config.Config.RateLimitProcessing),
+	})
+}
+
+func APIRateLimit() gin.HandlerFunc {
+	return RateLimit(RateLimitPolicy{Name: "api", PerIP: configuredLimit(config.Config.RateLimitAPI)})
+}
+
+type rateLimitCheck struct {
+	key   string
+	limit Limit
+}
+
+func configuredLimit(value string) Limit {
+	limit, err := ParseLimit(value)
+	if err != nil {
+		log.Fatalf("invalid rate limit configuration: %v", err)
+	}
+	return limit
+}
+
+func setRateLimitHeaders(ctx *gin.Context, result RateLimitResult) {
+	ctx.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	ctx.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	ctx.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
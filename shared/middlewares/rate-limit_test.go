@@ -0,0 +1,115 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"vixel/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseLimit(t *testing.T) {
+	cases := map[string]Limit{
+		"":       {},
+		"0":      {},
+		"10/1m":  {Requests: 10, Per: time.Minute},
+		"10/m":   {Requests: 10, Per: time.Minute},
+		"5/30s":  {Requests: 5, Per: 30 * time.Second},
+		"100/1h": {Requests: 100, Per: time.Hour},
+	}
+	for value, expected := range cases {
+		limit, err := ParseLimit(value)
+		if err != nil || limit != expected {
+			t.Errorf("ParseLimit(%q) = %+v, %v; expected %+v", value, limit, err, expected)
+		}
+	}
+
+	for _, value := range []string{"10", "x/1m", "10/0s", "-1/1m", "10/forever"} {
+		if _, err := ParseLimit(value); err == nil {
+			t.Errorf("Expected ParseLimit(%q) to fail", value)
+		}
+	}
+}
+
+func TestMemoryRateLimitStore_Refills(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Requests: 2, Per: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		if result, _ := store.Take(context.Background(), "k", limit); !result.Allowed {
+			t.Fatalf("Request %d should be allowed", i+1)
+		}
+	}
+	result, _ := store.Take(context.Background(), "k", limit)
+	if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > 30*time.Second {
+		t.Fatalf("Expected a rejection with retry-after of at most 30s, got %+v", result)
+	}
+
+	if result, _ := store.Take(context.Background(), "other", limit); !result.Allowed {
+		t.Error("Keys should be limited independently")
+	}
+
+	now = now.Add(30 * time.Second)
+	if result, _ := store.Take(context.Background(), "k", limit); !result.Allowed {
+		t.Error("A token should be refilled after 30s")
+	}
+}
+
+func TestRateLimit_RejectsWithHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	previous := rateLimitStore
+	SetRateLimitStore(NewMemoryRateLimitStore())
+	t.Cleanup(func() { SetRateLimitStore(previous) })
+
+	router := gin.New()
+	router.GET("/", RateLimit(RateLimitPolicy{Name: "test", PerIP: Limit{Requests: 1, Per: time.Minute}}), func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "1" || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("Expected first request to pass with headers, got %d %v", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestAuthRateLimit_RoutesHaveSeparateBuckets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	previous := rateLimitStore
+	SetRateLimitStore(NewMemoryRateLimitStore())
+	config.Config.RateLimitLogin = "1/1m"
+	config.Config.RateLimitRefresh = "1/1m"
+	t.Cleanup(func() {
+		SetRateLimitStore(previous)
+		config.Config.RateLimitLogin = ""
+		config.Config.RateLimitRefresh = ""
+	})
+
+	router := gin.New()
+	ok := func(ctx *gin.Context) { ctx.Status(http.StatusOK) }
+	router.POST("/login", AuthRateLimit(AuthRouteLogin), ok)
+	router.POST("/refresh", AuthRateLimit(AuthRouteRefresh), ok)
+
+	request := func(path string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		return w.Code
+	}
+	if request("/login") != http.StatusOK || request("/login") != http.StatusTooManyRequests {
+		t.Fatal("Expected the second login to be limited")
+	}
+	if code := request("/refresh"); code != http.StatusOK {
+		t.Errorf("Refreshing should not share the login bucket, got %d", code)
+	}
+}
@@ -0,0 +1,17 @@
+package middlewares
+
+import (
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !slices.Contains(roles, ctx.GetString("role")) {
+			ctx.AbortWithStatusJSON(403, gin.H{"error": "Insufficient permissions"})
+			return
+		}
+		ctx.Next()
+	}
+}
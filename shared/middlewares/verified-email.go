@@ -0,0 +1,17 @@
+package middlewares
+
+import (
+	"vixel/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if config.Config.RequireVerifiedEmail && !ctx.GetBool("email_verified") {
+			ctx.AbortWithStatusJSON(403, gin.H{"error": "Email address is not verified"})
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -3,8 +3,13 @@ package responses
 const (
 	OK                    = 200
 	CREATED               = 201
+	ACCEPTED              = 202
 	BAD_REQUEST           = 400
 	UNAUTHORIZED          = 401
+	FORBIDDEN             = 403
 	NOT_FOUND             = 404
+	CONFLICT              = 409
+	PAYLOAD_TOO_LARGE     = 413
+	UNPROCESSABLE_ENTITY  = 422
 	INTERNAL_SERVER_ERROR = 500
 )
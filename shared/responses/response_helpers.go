@@ -22,6 +22,14 @@ func Created(ctx *gin.Context, data interface{}) {
 	})
 }
 
+func Accepted(ctx *gin.Context, data interface{}) {
+	ctx.JSON(ACCEPTED, gin.H{
+		"status":    "accepted",
+		"timestamp": time.Now().Local(),
+		"data":      data,
+	})
+}
+
 func BadRequest(ctx *gin.Context, err error) {
 	ctx.JSON(BAD_REQUEST, gin.H{
 		"timestamp": time.Now().Local(),
@@ -46,6 +54,14 @@ func Unauthorized(ctx *gin.Context, err error) {
 	})
 }
 
+func Forbidden(ctx *gin.Context, err error) {
+	ctx.JSON(FORBIDDEN, gin.H{
+		"timestamp": time.Now().Local(),
+		"status":    "forbidden",
+		"error":     err.Error(),
+	})
+}
+
 func NotFound(ctx *gin.Context, err error) {
 	ctx.JSON(NOT_FOUND, gin.H{
 		"timestamp": time.Now().Local(),
@@ -53,3 +69,27 @@ func NotFound(ctx *gin.Context, err error) {
 		"error":     err.Error(),
 	})
 }
+
+func Conflict(ctx *gin.Context, err error) {
+	ctx.JSON(CONFLICT, gin.H{
+		"timestamp": time.Now().Local(),
+		"status":    "conflict",
+		"error":     err.Error(),
+	})
+}
+
+func PayloadTooLarge(ctx *gin.Context, err error) {
+	ctx.JSON(PAYLOAD_TOO_LARGE, gin.H{
+		"timestamp": time.Now().Local(),
+		"status":    "payload too large",
+		"error":     err.Error(),
+	})
+}
+
+func UnprocessableEntity(ctx *gin.Context, err error) {
+	ctx.JSON(UNPROCESSABLE_ENTITY, gin.H{
+		"timestamp": time.Now().Local(),
+		"status":    "unprocessable entity",
+		"error":     err.Error(),
+	})
+}
@@ -0,0 +1,14 @@
+//go:build !cgo
+
+package webp
+
+import (
+	"image"
+	"io"
+)
+
+const Supported = false
+
+func encode(w io.Writer, img *image.NRGBA, lossless bool, quality int) error {
+	return ErrUnsupported
+}
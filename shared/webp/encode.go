@@ -0,0 +1,33 @@
+//go:build cgo
+
+package webp
+
+import (
+	"image"
+	"io"
+
+	"github.com/chai2010/webp"
+)
+
+const Supported = true
+
+func encode(w io.Writer, img *image.NRGBA, lossless bool, quality int) error {
+	// libwebp takes straight alpha, so the NRGBA pixels are passed as they
+	// are rather than converted to the premultiplied image.RGBA they are
+	// labelled as.
+	rgba := &image.RGBA{Pix: img.Pix, Stride: img.Stride, Rect: img.Rect}
+	var (
+		data []byte
+		err  error
+	)
+	if lossless {
+		data, err = webp.EncodeExactLosslessRGBA(rgba)
+	} else {
+		data, err = webp.EncodeRGBA(rgba, float32(quality))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
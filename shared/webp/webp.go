@@ -0,0 +1,45 @@
+// Package webp encodes images in the WebP format with libwebp, bound through
+// cgo by github.com/chai2010/webp. Builds without cgo cannot encode WebP and
+// report it through Supported. Decoding is provided by golang.org/x/image/webp.
+package webp
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+)
+
+const DefaultQuality = 75
+
+var (
+	ErrTooLarge    = errors.New("webp: image is too large")
+	ErrUnsupported = errors.New("webp: encoding requires cgo")
+)
+
+type Options struct {
+	Lossless bool
+	Quality  int
+}
+
+func Encode(w io.Writer, img image.Image, o *Options) error {
+	lossless, quality := false, DefaultQuality
+	if o != nil {
+		lossless = o.Lossless
+		if o.Quality > 0 {
+			quality = min(o.Quality, 100)
+		}
+	}
+
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return errors.New("webp: empty image")
+	}
+	if bounds.Dx() > 16383 || bounds.Dy() > 16383 {
+		return ErrTooLarge
+	}
+	nrgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, bounds.Min, draw.Src)
+
+	return encode(w, nrgba, lossless, quality)
+}
@@ -0,0 +1,239 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func testImage(width, height int, alpha bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: uint8((x + y) * 4), A: 255}
+			if x > width/2 && y > height/2 {
+				c = color.NRGBA{R: 200, G: 30, B: 60, A: 255}
+			}
+			if alpha {
+				c.A = uint8(x * 255 / width)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodeDecode(t *testing.T, img image.Image, o *Options) image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, o); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	decoded, err := webp.Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded.Bounds().Dx() != img.Bounds().Dx() || decoded.Bounds().Dy() != img.Bounds().Dy() {
+		t.Fatalf("Expected %v, got %v", img.Bounds(), decoded.Bounds())
+	}
+	return decoded
+}
+
+func TestEncode_Lossless(t *testing.T) {
+	skipUnlessSupported(t)
+	for _, alpha := range []bool{false, true} {
+		img := testImage(37, 21, alpha)
+		decoded := encodeDecode(t, img, &Options{Lossless: true})
+
+		for y := 0; y < 21; y++ {
+			for x := 0; x < 37; x++ {
+				if got := color.NRGBAModel.Convert(decoded.At(x, y)); got != img.NRGBAAt(x, y) {
+					t.Fatalf("Pixel (%d, %d): expected %v, got %v", x, y, img.NRGBAAt(x, y), got)
+				}
+			}
+		}
+	}
+}
+
+func TestEncode_Lossy(t *testing.T) {
+	skipUnlessSupported(t)
+	img := testImage(37, 21, false)
+	decoded, ok := encodeDecode(t, img, &Options{Quality: 90}).(*image.YCbCr)
+	if !ok {
+		t.Fatal("Expected a YCbCr image")
+	}
+
+	var sse float64
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 37; x++ {
+			c := img.NRGBAAt(x, y)
+			expected := (16839*int(c.R) + 33059*int(c.G) + 6420*int(c.B) + 1<<15 + 16<<16) >> 16
+			d := float64(expected) - float64(decoded.Y[decoded.YOffset(x, y)])
+			sse += d * d
+		}
+	}
+	if psnr := 10 * math.Log10(255*255/(sse/(37*21))); psnr < 35 {
+		t.Errorf("Expected PSNR of at least 35dB, got %.1f", psnr)
+	}
+}
+
+func TestEncode_LossyQualityAffectsSize(t *testing.T) {
+	skipUnlessSupported(t)
+	img := testImage(64, 64, false)
+	var low, high bytes.Buffer
+	if err := Encode(&low, img, &Options{Quality: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Encode(&high, img, &Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	if low.Len() >= high.Len() {
+		t.Errorf("Expected quality 10 (%d bytes) to be smaller than quality 95 (%d bytes)", low.Len(), high.Len())
+	}
+}
+
+func TestEncode_LossyAlpha(t *testing.T) {
+	skipUnlessSupported(t)
+	img := testImage(20, 9, true)
+	decoded, ok := encodeDecode(t, img, nil).(*image.NYCbCrA)
+	if !ok {
+		t.Fatal("Expected an NYCbCrA image")
+	}
+
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 20; x++ {
+			if got := decoded.A[decoded.AOffset(x, y)]; got != img.NRGBAAt(x, y).A {
+				t.Fatalf("Alpha (%d, %d): expected %d, got %d", x, y, img.NRGBAAt(x, y).A, got)
+			}
+		}
+	}
+}
+
+func TestEncode_EdgeCases(t *testing.T) {
+	skipUnlessSupported(t)
+	palette := image.NewPaletted(image.Rect(0, 0, 15, 7), color.Palette{
+		color.NRGBA{A: 0},
+		color.NRGBA{R: 255, A: 255},
+		color.NRGBA{G: 128, B: 255, A: 128},
+	})
+	for i := range palette.Pix {
+		palette.Pix[i] = uint8(i % 3)
+	}
+	transparent := image.NewNRGBA(image.Rect(0, 0, 9, 9))
+	offset := testImage(40, 30, true).SubImage(image.Rect(3, 5, 24, 18))
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"1x1", testImage(1, 1, false)},
+		{"1x1 alpha", testImage(1, 1, true)},
+		{"single row", testImage(257, 1, true)},
+		{"single column", testImage(1, 257, false)},
+		{"odd size", testImage(17, 33, true)},
+		{"large", testImage(1031, 517, true)},
+		{"palette", palette},
+		{"fully transparent", transparent},
+		{"sub-image", offset},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected := image.NewNRGBA(image.Rect(0, 0, tt.img.Bounds().Dx(), tt.img.Bounds().Dy()))
+			draw.Draw(expected, expected.Bounds(), tt.img, tt.img.Bounds().Min, draw.Src)
+
+			decoded := encodeDecode(t, tt.img, &Options{Lossless: true})
+			for y := 0; y < expected.Bounds().Dy(); y++ {
+				for x := 0; x < expected.Bounds().Dx(); x++ {
+					got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+					if want := expected.NRGBAAt(x, y); got != want && !(got.A == 0 && want.A == 0) {
+						t.Fatalf("Lossless pixel (%d, %d): expected %v, got %v", x, y, want, got)
+					}
+				}
+			}
+
+			decoded = encodeDecode(t, tt.img, nil)
+			withAlpha, ok := decoded.(*image.NYCbCrA)
+			if expected.Opaque() {
+				if ok {
+					t.Fatal("Expected an opaque image to be encoded without alpha")
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("Expected an NYCbCrA image")
+			}
+			for y := 0; y < expected.Bounds().Dy(); y++ {
+				for x := 0; x < expected.Bounds().Dx(); x++ {
+					if got := withAlpha.A[withAlpha.AOffset(x, y)]; got != expected.NRGBAAt(x, y).A {
+						t.Fatalf("Lossy alpha (%d, %d): expected %d, got %d", x, y, expected.NRGBAAt(x, y).A, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func skipUnlessSupported(t *testing.T) {
+	if !Supported {
+		t.Skip("WebP encoding requires cgo")
+	}
+}
+
+func fuzzImage(width, height uint8, pix []byte) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, int(width)%64+1, int(height)%64+1))
+	if len(pix) > 0 {
+		for i := range img.Pix {
+			img.Pix[i] = pix[i%len(pix)]
+		}
+	}
+	return img
+}
+
+func FuzzEncodeLossless(f *testing.F) {
+	f.Add(uint8(0), uint8(0), []byte{})
+	f.Add(uint8(36), uint8(20), testImage(37, 21, true).Pix)
+	f.Add(uint8(63), uint8(1), []byte{1, 2, 3, 255, 0, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, width, height uint8, pix []byte) {
+		skipUnlessSupported(t)
+		img := fuzzImage(width, height, pix)
+		decoded := encodeDecode(t, img, &Options{Lossless: true})
+
+		bounds := img.Bounds()
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				if got := color.NRGBAModel.Convert(decoded.At(x, y)); got != img.NRGBAAt(x, y) {
+					t.Fatalf("Pixel (%d, %d): expected %v, got %v", x, y, img.NRGBAAt(x, y), got)
+				}
+			}
+		}
+	})
+}
+
+func FuzzEncodeLossy(f *testing.F) {
+	f.Add(uint8(0), uint8(0), uint8(0), []byte{})
+	f.Add(uint8(36), uint8(20), uint8(90), testImage(37, 21, false).Pix)
+	f.Add(uint8(19), uint8(8), uint8(10), testImage(20, 9, true).Pix)
+	f.Fuzz(func(t *testing.T, width, height, quality uint8, pix []byte) {
+		skipUnlessSupported(t)
+		img := fuzzImage(width, height, pix)
+		decoded := encodeDecode(t, img, &Options{Quality: int(quality)})
+
+		withAlpha, ok := decoded.(*image.NYCbCrA)
+		if !ok {
+			return
+		}
+		bounds := img.Bounds()
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				if got := withAlpha.A[withAlpha.AOffset(x, y)]; got != img.NRGBAAt(x, y).A {
+					t.Fatalf("Alpha (%d, %d): expected %d, got %d", x, y, img.NRGBAAt(x, y).A, got)
+				}
+			}
+		}
+	})
+}